@@ -0,0 +1,270 @@
+package edit
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/eaburns/T/rope"
+)
+
+const (
+	// maxLineEdits is the maximum number of line insertions and deletions
+	// computed by DiffRopes; see myers.
+	maxLineEdits = 1 << 12
+	// maxRuneDiff is the maximum number of runes in a changed block of lines
+	// for which DiffRopes computes rune-granularity changes.
+	maxRuneDiff = 1 << 14
+)
+
+// DiffRopes returns the Diffs that change rope a into rope b.
+//
+// The Diffs are a shortest edit script between the lines of the ropes,
+// computed with Myers' algorithm.
+// Each block of changed lines is further refined
+// into a shortest edit script between its runes,
+// unless more than half of the runes are changed.
+// If the ropes differ too much, the Diffs may not be minimal.
+//
+// The Diffs are in order of increasing address,
+// and each is relative to the text after applying those before it,
+// as with Diffs.Apply.
+func DiffRopes(a, b rope.Rope) Diffs {
+	as, bs := a.String(), b.String()
+	al, bl := splitLines(as), splitLines(bs)
+	aoff, boff := lineOffsets(al), lineOffsets(bl)
+
+	ids := make(map[string]int)
+	aids, bids := lineIDs(ids, al), lineIDs(ids, bl)
+	eq := func(i, j int) bool { return aids[i] == bids[j] }
+
+	var ds Diffs
+	var adj int64
+	for _, h := range myers(len(al), len(bl), maxLineEdits, eq) {
+		a0, a1 := aoff[h[0]], aoff[h[1]]
+		b0, b1 := boff[h[2]], boff[h[3]]
+		for _, d := range diffRunes(as[a0:a1], bs[b0:b1]) {
+			n := d.At[1] - d.At[0]
+			d.At[0] += int64(a0) + adj
+			d.At[1] = d.At[0] + n
+			adj += d.TextLen() - n
+			ds = append(ds, d)
+		}
+	}
+	return ds
+}
+
+// diffRunes returns the Diffs changing a into b
+// with addresses relative to a, not adjusted for previous Diffs.
+func diffRunes(a, b string) Diffs {
+	ar, br := []rune(a), []rune(b)
+	if len(ar)+len(br) > maxRuneDiff {
+		return Diffs{{At: [2]int64{0, int64(len(a))}, Text: rope.New(b)}}
+	}
+	aoff, boff := runeOffsets(a), runeOffsets(b)
+	eq := func(i, j int) bool { return ar[i] == br[j] }
+	var ds Diffs
+	for _, h := range myers(len(ar), len(br), (len(ar)+len(br))/2, eq) {
+		d := Diff{At: [2]int64{int64(aoff[h[0]]), int64(aoff[h[1]])}}
+		if h[2] < h[3] {
+			d.Text = rope.New(b[boff[h[2]]:boff[h[3]]])
+		}
+		ds = append(ds, d)
+	}
+	return ds
+}
+
+// splitLines returns the lines of s, each including its terminating newline.
+// The last line has no newline if s does not end with one.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// lineOffsets returns the byte offset of the start of each line,
+// followed by the total length.
+func lineOffsets(lines []string) []int {
+	offs := make([]int, len(lines)+1)
+	for i, l := range lines {
+		offs[i+1] = offs[i] + len(l)
+	}
+	return offs
+}
+
+// runeOffsets returns the byte offset of the start of each rune of s,
+// followed by len(s).
+func runeOffsets(s string) []int {
+	offs := make([]int, 0, utf8.RuneCountInString(s)+1)
+	for i := range s {
+		offs = append(offs, i)
+	}
+	return append(offs, len(s))
+}
+
+// lineIDs returns an integer ID for each line;
+// equal lines have equal IDs.
+func lineIDs(ids map[string]int, lines []string) []int {
+	lids := make([]int, len(lines))
+	for i, l := range lines {
+		id, ok := ids[l]
+		if !ok {
+			id = len(ids)
+			ids[l] = id
+		}
+		lids[i] = id
+	}
+	return lids
+}
+
+// myers returns the hunks of a shortest edit script
+// changing a sequence of length n into a sequence of length m,
+// using the linear-space variant of the algorithm of
+// Eugene W. Myers, "An O(ND) Difference Algorithm and Its Variations".
+// eq returns whether element i of the first sequence
+// equals element j of the second.
+//
+// A hunk [4]int{i0, i1, j0, j1} replaces elements [i0, i1) of the first sequence
+// with elements [j0, j1) of the second.
+// The hunks are in increasing order and do not touch.
+//
+// If the edit script has more than maxD insertions and deletions,
+// the common prefix and suffix are kept,
+// and everything between them is replaced by a single hunk.
+func myers(n, m, maxD int, eq func(i, j int) bool) [][4]int {
+	df := differ{eq: eq}
+	x0, x1, y0, y1 := df.trim(0, n, 0, m)
+	if x0 == x1 || y0 == y1 {
+		df.hunk(x0, x1, y0, y1)
+		return df.hs
+	}
+	switch d, s := df.middleSnake(x0, x1, y0, y1, (maxD+1)/2); {
+	case d < 0 || d > maxD:
+		df.hunk(x0, x1, y0, y1)
+	default:
+		df.split(x0, x1, y0, y1, d, s)
+	}
+	return df.hs
+}
+
+// A differ computes the hunks of an edit script.
+type differ struct {
+	eq func(i, j int) bool
+	hs [][4]int
+}
+
+// trim returns the range with its common prefix and suffix removed.
+func (df *differ) trim(x0, x1, y0, y1 int) (int, int, int, int) {
+	for x0 < x1 && y0 < y1 && df.eq(x0, y0) {
+		x0++
+		y0++
+	}
+	for x0 < x1 && y0 < y1 && df.eq(x1-1, y1-1) {
+		x1--
+		y1--
+	}
+	return x0, x1, y0, y1
+}
+
+// diff adds the hunks changing elements [x0, x1) of the first sequence
+// into elements [y0, y1) of the second.
+func (df *differ) diff(x0, x1, y0, y1 int) {
+	x0, x1, y0, y1 = df.trim(x0, x1, y0, y1)
+	if x0 == x1 || y0 == y1 {
+		df.hunk(x0, x1, y0, y1)
+		return
+	}
+	d, s := df.middleSnake(x0, x1, y0, y1, -1)
+	df.split(x0, x1, y0, y1, d, s)
+}
+
+// split adds the hunks of the edit script before and after
+// the middle snake s of an edit script with d insertions and deletions.
+func (df *differ) split(x0, x1, y0, y1, d int, s [4]int) {
+	if d <= 1 {
+		// The only change is one insertion or deletion
+		// on one side or the other of the snake.
+		df.hunk(x0, s[0], y0, s[1])
+		df.hunk(s[2], x1, s[3], y1)
+		return
+	}
+	df.diff(x0, s[0], y0, s[1])
+	df.diff(s[2], x1, s[3], y1)
+}
+
+// hunk adds a hunk replacing [x0, x1) with [y0, y1),
+// merging it with the previous hunk if they touch.
+func (df *differ) hunk(x0, x1, y0, y1 int) {
+	switch {
+	case x0 == x1 && y0 == y1:
+		return
+	case len(df.hs) > 0 && df.hs[len(df.hs)-1][1] == x0 && df.hs[len(df.hs)-1][3] == y0:
+		df.hs[len(df.hs)-1][1] = x1
+		df.hs[len(df.hs)-1][3] = y1
+	default:
+		df.hs = append(df.hs, [4]int{x0, x1, y0, y1})
+	}
+}
+
+// middleSnake returns the number of insertions and deletions
+// of a shortest edit script changing [x0, x1) into [y0, y1),
+// and the middle snake of the script:
+// the diagonal run of equal elements from s[0], s[1] to s[2], s[3]
+// through which the forward and reverse searches meet.
+//
+// If maxHalf is non-negative, and the forward and reverse searches
+// do not meet within maxHalf steps each, middleSnake returns -1.
+func (df *differ) middleSnake(x0, x1, y0, y1, maxHalf int) (int, [4]int) {
+	n, m := x1-x0, y1-y0
+	delta := n - m
+	odd := delta&1 != 0
+	lim := (n + m + 1) / 2
+	if maxHalf >= 0 && maxHalf < lim {
+		lim = maxHalf
+	}
+	// vf[off+k] is the furthest x reaching diagonal k = x-y
+	// from the start, and vr[off+k] is the furthest distance
+	// from the end reaching the reverse diagonal k = (n-x)-(m-y).
+	off := lim + 1
+	vf, vr := make([]int, 2*off+1), make([]int, 2*off+1)
+	for d := 0; d <= lim; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && vf[off+k-1] < vf[off+k+1] {
+				x = vf[off+k+1]
+			} else {
+				x = vf[off+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && df.eq(x0+x, y0+y) {
+				x++
+				y++
+			}
+			vf[off+k] = x
+			if r := delta - k; odd && r >= -(d-1) && r <= d-1 && x+vr[off+r] >= n {
+				return 2*d - 1, [4]int{x0 + sx, y0 + sy, x0 + x, y0 + y}
+			}
+		}
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && vr[off+k-1] < vr[off+k+1] {
+				x = vr[off+k+1]
+			} else {
+				x = vr[off+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && df.eq(x1-x-1, y1-y-1) {
+				x++
+				y++
+			}
+			vr[off+k] = x
+			if f := delta - k; !odd && f >= -d && f <= d && x+vf[off+f] >= n {
+				return 2 * d, [4]int{x1 - x, y1 - y, x1 - sx, y1 - sy}
+			}
+		}
+	}
+	return -1, [4]int{}
+}
@@ -0,0 +1,188 @@
+package edit
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/eaburns/T/rope"
+)
+
+func TestDiffRopes(t *testing.T) {
+	type diff struct {
+		at   [2]int64
+		text string
+	}
+	tests := []struct {
+		a, b string
+		want []diff
+	}{
+		{a: "", b: "", want: nil},
+		{a: "abc", b: "abc", want: nil},
+		{a: "", b: "abc", want: []diff{{[2]int64{0, 0}, "abc"}}},
+		{a: "abc", b: "", want: []diff{{[2]int64{0, 3}, ""}}},
+		{a: "abc", b: "abXc", want: []diff{{[2]int64{2, 2}, "X"}}},
+		{a: "abc", b: "ac", want: []diff{{[2]int64{1, 2}, ""}}},
+		{a: "abc", b: "aXc", want: []diff{{[2]int64{1, 2}, "X"}}},
+		{a: "abc", b: "xyz", want: []diff{{[2]int64{0, 3}, "xyz"}}},
+		{
+			a:    "a\nb\nc\n",
+			b:    "a\nc\n",
+			want: []diff{{[2]int64{2, 4}, ""}},
+		},
+		{
+			a:    "a\nb\nc\n",
+			b:    "a\nb\nX\nc\n",
+			want: []diff{{[2]int64{4, 4}, "X\n"}},
+		},
+		{
+			// The second diff's address follows the first.
+			a: "one\ntwo\nthree\nfour\n",
+			b: "ONE\ntwo\nthree\nFOUR\n",
+			want: []diff{
+				{[2]int64{0, 3}, "ONE"},
+				{[2]int64{14, 18}, "FOUR"},
+			},
+		},
+		{
+			a: "func f() {\n\treturn 1\n}\n",
+			b: "func f() {\n\treturn 12\n}\n",
+			want: []diff{
+				{[2]int64{20, 20}, "2"},
+			},
+		},
+		{
+			a:    "Hello, 世界\n",
+			b:    "Hello, 世!界\n",
+			want: []diff{{[2]int64{10, 10}, "!"}},
+		},
+		{
+			a:    "no newline",
+			b:    "no newline\n",
+			want: []diff{{[2]int64{10, 10}, "\n"}},
+		},
+	}
+	for _, test := range tests {
+		ds := DiffRopes(rope.New(test.a), rope.New(test.b))
+		var got []diff
+		for _, d := range ds {
+			var text string
+			if d.Text != nil {
+				text = d.Text.String()
+			}
+			got = append(got, diff{d.At, text})
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("DiffRopes(%q, %q)=%v, want %v", test.a, test.b, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("DiffRopes(%q, %q)=%v, want %v", test.a, test.b, got, test.want)
+				break
+			}
+		}
+		if ro, _ := ds.Apply(rope.New(test.a)); ro.String() != test.b {
+			t.Errorf("DiffRopes(%q, %q).Apply(%q)=%q", test.a, test.b, test.a, ro.String())
+		}
+	}
+}
+
+func TestDiffRopesApply(t *testing.T) {
+	rand.Seed(1)
+	for i := 0; i < 1000; i++ {
+		a := randText(rand.Intn(50))
+		b := mutate(a)
+		ds := DiffRopes(rope.New(a), rope.New(b))
+		if ro, _ := ds.Apply(rope.New(a)); ro.String() != b {
+			t.Fatalf("DiffRopes(%q, %q).Apply(%q)=%q", a, b, a, ro.String())
+		}
+		for j := 1; j < len(ds); j++ {
+			if ds[j].At[0] <= ds[j-1].At[0]+ds[j-1].TextLen() {
+				t.Fatalf("DiffRopes(%q, %q)=%v, not in order", a, b, ds)
+			}
+		}
+	}
+}
+
+func TestMyersMinimal(t *testing.T) {
+	rand.Seed(1)
+	for i := 0; i < 1000; i++ {
+		a, b := []rune(randText(rand.Intn(20))), []rune(randText(rand.Intn(20)))
+		eq := func(i, j int) bool { return a[i] == b[j] }
+		hs := myers(len(a), len(b), len(a)+len(b), eq)
+		var got int
+		for _, h := range hs {
+			got += h[1] - h[0] + h[3] - h[2]
+		}
+		if want := len(a) + len(b) - 2*lcs(a, b); got != want {
+			t.Fatalf("myers(%q, %q)=%v, %d edits, want %d", string(a), string(b), hs, got, want)
+		}
+	}
+}
+
+func TestMyersMaxD(t *testing.T) {
+	tests := []struct {
+		a, b string
+		maxD int
+		want [][4]int
+	}{
+		{a: "xabcx", b: "xABCx", maxD: 6, want: [][4]int{{1, 4, 1, 4}}},
+		{a: "xabcx", b: "xABCx", maxD: 5, want: [][4]int{{1, 4, 1, 4}}},
+		{a: "xabcx", b: "xAbCx", maxD: 4, want: [][4]int{{1, 2, 1, 2}, {3, 4, 3, 4}}},
+		{a: "xabcx", b: "xAbCx", maxD: 3, want: [][4]int{{1, 4, 1, 4}}},
+		{a: strings.Repeat("a", 1e5), b: strings.Repeat("b", 1e5), maxD: 1 << 12, want: [][4]int{{0, 1e5, 0, 1e5}}},
+	}
+	for _, test := range tests {
+		a, b := []rune(test.a), []rune(test.b)
+		eq := func(i, j int) bool { return a[i] == b[j] }
+		if hs := myers(len(a), len(b), test.maxD, eq); !reflect.DeepEqual(hs, test.want) {
+			t.Errorf("myers(%q, %q, %d)=%v, want %v", test.a, test.b, test.maxD, hs, test.want)
+		}
+	}
+}
+
+// lcs returns the length of the longest common subsequence of a and b.
+func lcs(a, b []rune) int {
+	n := make([][]int, len(a)+1)
+	for i := range n {
+		n[i] = make([]int, len(b)+1)
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			switch {
+			case a[i-1] == b[j-1]:
+				n[i][j] = n[i-1][j-1] + 1
+			case n[i-1][j] > n[i][j-1]:
+				n[i][j] = n[i-1][j]
+			default:
+				n[i][j] = n[i][j-1]
+			}
+		}
+	}
+	return n[len(a)][len(b)]
+}
+
+func randText(n int) string {
+	const runes = "ab\n世"
+	var s strings.Builder
+	for i := 0; i < n; i++ {
+		s.WriteRune([]rune(runes)[rand.Intn(4)])
+	}
+	return s.String()
+}
+
+// mutate returns s with a few random insertions and deletions.
+func mutate(s string) string {
+	rs := []rune(s)
+	for i := rand.Intn(5); i > 0; i-- {
+		j := rand.Intn(len(rs) + 1)
+		if rand.Intn(2) == 0 && j < len(rs) {
+			rs = append(rs[:j], rs[j+1:]...)
+		} else {
+			rs = append(rs[:j], append([]rune(randText(rand.Intn(4))), rs[j:]...)...)
+		}
+	}
+	return string(rs)
+}
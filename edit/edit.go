@@ -1,6 +1,10 @@
 // Package edit implements a subset of the Sam editing language.
-// It does not implement undo/redo, or multi-buffer commands.
-// However, these could be easily added on top of this implementation.
+// It does not implement undo/redo.
+// However, this could be easily added on top of this implementation.
+// Multi-file commands operate on a set of Files; see EditFiles.
+//
+// An edit can be parsed once with Parse
+// and then executed any number of times.
 //
 // The langage is described below using an informal, EBNF-like style.
 // Items enclosed in brackets, [ ] ,are optional, and
@@ -34,17 +38,28 @@
 //
 // 		a1 a2 is the same as a1+a2; the + is inserted.
 //
-// 	simple = "$" | "." | "#" digits | digits | "/" regexp [  "/"  ].
+// 	simple = "$" | "." | "'" mark | "#" digits | digits | "/" regexp [ "/" [ flags ] ] | "?" regexp [ "?" [ flags ] ].
 // 		$ is the empty string at the end of the text.
 // 		. is the current address of the editor, called dot.
+// 		'x is the address of the mark named x. (See the k command.)
 // 		#n is the empty string after rune number n. If n is absent then 1 is used.
 // 		n is the nth line in the text. 0 is the string before the first full line.
 // 		/ regexp / is the first match of the regular expression going forward.
+// 		? regexp ? is the first match of the regular expression going in reverse.
+// 		In a reverse address, such as -/regexp/, the directions are swapped.
 //
-// 		A regexp is an re1 regular expression delimited by / or a newline.
+// 		A regexp is an re1 regular expression delimited by / (or ?) or a newline.
+// 		The flags are any of i and s.
 // 		(See https://godoc.org/github.com/eaburns/T/re1)
 // 		Regexp matches wrap at the end (or beginning) of the text.
 // 		The resulting match may straddle the starting point.
+// 		Flags are set with inline flags at the start of the regexp,
+// 		or with a suffix after the closing delimiter.
+// 		For example, /(?i)abc/ and /abc/i match abc case-insensitively,
+// 		and in /(?s)a.c/ and /a.c/s the . also matches newline.
+// 		A suffix must be followed by a space, the end of the edit,
+// 		or an address operator, such as /abc/i,$;
+// 		otherwise it is a command: /abc/i/x/ inserts x before the match.
 //
 // 	All operators are left-associative.
 //
@@ -108,6 +123,23 @@
 // 	[ addr ] "p".
 // 		Prints the string at the address.
 //
+// 	[ addr ] "=" [ "#" ].
+// 		Prints the address.
+//
+// 		Without #, the address is printed as a line number.
+// 		If the address spans multiple lines,
+// 		it is printed as the first and last line numbers, separated by a comma.
+// 		With #, the address is printed as rune offsets,
+// 		#m for an empty address and #m,#n otherwise.
+// 		In either case, the address is followed by a newline.
+//
+// 	[ addr ] "k" mark.
+// 		Sets the named mark to the address.
+// 		A mark name is a single letter.
+//
+// 		Marks are stored in a table supplied by the caller; see Options.
+// 		It is an error to use marks in an edit without a table.
+//
 // 	[ addr ] "s" [ digits ] "/" regexp "/" [ substitution ] "/"  [ "g" ].
 // 		Substitutes matches of a regexp within the address.
 // 		As above, the regexp uses the re1 syntax.
@@ -129,6 +161,15 @@
 // 		The digits 1-9 correspond to text matched by capturing groups
 // 		numbered from left-to-right by order of their open parenthesis.
 // 		The 0 is the entire match.
+// 		Two escaped digits are a single group number
+// 		if the regexp has a group with that number;
+// 		otherwise the second digit is literal.
+// 		\{name} is substituted with text matched by the group
+// 		with the given name, as in (?P<name>regexp), or number.
+// 		It is an error if there is no such group.
+// 		\U converts the following text to upper case,
+// 		\L converts it to lower case,
+// 		and \E ends the conversion.
 //
 // 	[ addr ] ( "x" | "y" ) "/" regexp "/" command.
 // 		Executes a command for each match of the regular expression in the address.
@@ -138,9 +179,6 @@
 // 		and the command is executed.
 // 		It is an error if the resulting edits are not in ascending order.
 //
-// 		Note, the command is only interpreted if there is a match,
-// 		so a malformed command is not reported if there is no match.
-//
 // 	[ addr ] ( "g" | "v" ) "/" regexp "/" command.
 // 		Conditionally executes a command if a regular expression
 // 		matches in the address.
@@ -150,9 +188,6 @@
 // 		and the command is executed.
 // 		It is an error if the resulting edits are not in ascending order.
 //
-// 		Note, the command is only interpreted if the condition is satisfied,
-// 		so a malformed command is not reported if not.
-//
 // 	[ addr ] ( "|" | "<" | ">" ) shell command.
 // 		Pipes the addressed string to and/or from shell commands.
 //
@@ -170,6 +205,8 @@
 // 		The text is passed as the -c argument of
 // 		the shell program from the SHELL environment variable.
 // 		If SHELL is unset, /bin/sh is used.
+// 		Embedders may run the command differently
+// 		or disable shell commands; see Options.
 //
 // 	[ addr ] "{" { "\n" command } [ "\n" ] [ "}" ].
 // 		Performs a sequence of commands.
@@ -178,20 +215,78 @@
 // 		Commands do not see modifications made by each other.
 // 		Each sees the original text, before any changes are made.
 // 		It is an error if the resulting edits are not in ascending order.
+//
+// Multi-file commands
+//
+// The following commands operate on a set of files.
+// Except for r and w with a file name,
+// it is an error to use them in an edit without a set of Files.
+//
+// File names are terminated by an un-escaped newline or the end of input.
+// Where a list of files is accepted, the names are separated by spaces.
+//
+// 	( "X" | "Y" ) "/" regexp "/" command.
+// 		Executes a command for each file whose menu line
+// 		matches (X) or does not match (Y) the regular expression.
+// 		(See the n command for a description of menu lines.)
+//
+// 		The command is executed with the file as the current file
+// 		and dot set to the file's dot.
+// 		Edits to each file are returned separately;
+// 		It is an error if the resulting edits to a single file
+// 		are not in ascending order.
+//
+// 	"b" file.
+// 		Makes the named file the current file
+// 		for subsequent edits.
+// 		It is an error if the file is not in the set.
+//
+// 	"B" file { file }.
+// 		Adds files to the set and makes the last the current file
+// 		for subsequent edits.
+//
+// 	"D" { file }.
+// 		Removes files from the set.
+// 		If no file is named, the current file is removed.
+// 		If the current file is removed,
+// 		there is no current file for subsequent edits.
+//
+// 	"e" [ file ].
+// 		Replaces the text of the current file with the contents of a file,
+// 		and sets the name of the current file to that of the file.
+// 		If the file is absent, the current file name is used.
+//
+// 	[ addr ] "r" [ file ].
+// 		Replaces the addressed string with the contents of a file.
+// 		If the file is absent, the current file name is used.
+//
+// 	[ addr ] "w" [ file ].
+// 		Writes the addressed string to a file.
+// 		If the address is absent, the entire text is written, not dot.
+// 		If the file is absent, the current file name is used.
+//
+// 	"f" [ file ].
+// 		Sets the name of the current file, if a name is present,
+// 		and prints the menu line of the current file.
+//
+// 	"n".
+// 		Prints the menu line of each file in the set.
+//
+// 		A menu line is . for the current file or a space otherwise,
+// 		followed by a space, the file name, and a newline.
 package edit
 
 import (
+	"context"
 	"errors"
 	"io"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
-	"sync"
 	"unicode"
 	"unicode/utf8"
 
-	"github.com/eaburns/T/re1"
 	"github.com/eaburns/T/rope"
 )
 
@@ -207,6 +302,54 @@ type Diff struct {
 	Text rope.Rope
 }
 
+// A File is a named text in a set of Files.
+type File interface {
+	// Name returns the name of the file.
+	Name() string
+	// Text returns the text of the file.
+	Text() rope.Rope
+	// Dot returns the value of dot in the file.
+	Dot() [2]int64
+	// Marks returns the marks of the file or nil.
+	Marks() Marks
+}
+
+// Files is a set of files on which multi-file commands operate.
+type Files interface {
+	// Files returns the files in the set.
+	Files() []File
+	// Current returns the current file or nil if there is none.
+	Current() File
+	// SetCurrent makes a file in the set the current file.
+	SetCurrent(File)
+	// Open returns the file with the given name,
+	// adding it to the set if it is not already in the set.
+	Open(name string) (File, error)
+	// Close removes a file from the set.
+	Close(File) error
+	// Rename changes the name of a file in the set.
+	Rename(File, string) error
+}
+
+// Marks are named addresses.
+type Marks map[rune][2]int64
+
+// Update updates the addresses of the marks
+// to account for the application of Diffs.
+func (ms Marks) Update(ds Diffs) {
+	for r, a := range ms {
+		ms[r] = ds.Update(a)
+	}
+}
+
+// FileDiffs are the Diffs computed for a single File.
+type FileDiffs struct {
+	File  File
+	Diffs Diffs
+	// Dot is the value of dot after applying the Diffs.
+	Dot [2]int64
+}
+
 // NoCommandError is returned when there was no command to execute.
 type NoCommandError struct {
 	// At contains the evaluation of the address preceding the missing command.
@@ -220,6 +363,67 @@ func (e NoCommandError) Error() string {
 	return "no command"
 }
 
+// ErrorKind is the kind of an Error.
+type ErrorKind int
+
+const (
+	// Syntax is a malformed edit or regular expression.
+	Syntax ErrorKind = iota + 1
+	// NoMatch is a regular expression that did not match.
+	NoMatch
+	// BadAddr is an address that is out of range or out of order.
+	BadAddr
+	// OutOfOrder is a command with changes that are out of order.
+	OutOfOrder
+	// NoMark is a mark that is not set, or a mark used with no mark table.
+	NoMark
+	// NoFile is a command missing a file set, a current file, or a named file.
+	NoFile
+	// IO is an error reading or writing a file or printing.
+	IO
+	// Shell is an error running a shell command.
+	Shell
+	// Aborted is a regular expression search
+	// that was aborted because the edit's context was done.
+	Aborted
+)
+
+// An Error is an error parsing or executing an edit.
+type Error struct {
+	// Kind is the kind of the error.
+	Kind ErrorKind
+	// Pos is the byte offset into the edit
+	// of the text at which the error was detected.
+	Pos int
+	// Err is the underlying error, or nil if there is none.
+	// For example, a malformed regular expression has an re1.Error,
+	// a failed shell command may have an *exec.ExitError,
+	// a disabled shell command has ErrNoShell,
+	// and an aborted search has re1.ErrAborted.
+	Err error
+	msg string
+}
+
+// Error returns the error message, which does not include the position.
+func (err Error) Error() string { return err.msg }
+
+func errorAt(kind ErrorKind, n Node, msg string) error {
+	return Error{Kind: kind, Pos: n.Span()[0], msg: msg}
+}
+
+// wrapError returns err as an Error of the given kind at the start of the node.
+// If err is nil, wrapError returns nil,
+// and if err is already an Error, it is returned unchanged.
+func wrapError(kind ErrorKind, n Node, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(Error); ok {
+		return err
+	}
+	return Error{Kind: kind, Pos: n.Span()[0], Err: err, msg: err.Error()}
+}
+
 // TextLen is the length of Text; 0 if Text is nil.
 func (d Diff) TextLen() int64 {
 	if d.Text == nil {
@@ -299,215 +503,402 @@ func (d Diff) Apply(ro rope.Rope) (rope.Rope, Diff) {
 
 // Addr computes an address using the given value for dot.
 func Addr(dot [2]int64, t string, ro rope.Rope) ([2]int64, error) {
-	var err error
-	switch dot, t, err = addr(&dot, ro, t); {
+	p := &parser{src: t}
+	a, t, err := parseAddr(p, t)
+	switch {
 	case err != nil:
 		return [2]int64{}, err
 	case strings.TrimSpace(t) != "":
-		return [2]int64{}, errors.New("expected end-of-input")
-	case dot[0] < 0:
-		return [2]int64{}, errors.New("no address")
+		return [2]int64{}, syntaxError(p, trimSpaceLeft(t), "expected end-of-input")
+	case a == nil:
+		return [2]int64{}, syntaxError(p, trimSpaceLeft(t), "no address")
 	default:
-		return dot, nil
+		return addr(&state{ctx: context.Background()}, &dot, a, ro)
 	}
 }
 
 // Edit computes an edit on the rope using the given value for dot.
 func Edit(dot [2]int64, t string, print io.Writer, ro rope.Rope) (Diffs, error) {
-	switch ds, t, err := edit(dot, t, print, ro); {
-	case err != nil:
+	p, err := Parse(t)
+	if err != nil {
 		return nil, err
-	case strings.TrimSpace(t) != "":
-		return nil, errors.New("expected end-of-input")
-	default:
-		return ds, nil
 	}
+	return p.Exec(dot, ro, print)
 }
 
-func edit(dot [2]int64, t string, print io.Writer, ro rope.Rope) (Diffs, string, error) {
-	a, t, err := addr(&dot, ro, t)
-	switch {
-	case err != nil:
-		return nil, "", err
-	case a[0] < 0:
-		a = dot
+// Options are options for executing an edit.
+// The zero value is the behavior of Edit.
+type Options struct {
+	// Context, if non-nil, is the context of the edit.
+	// If it is done during a regular expression search,
+	// the edit is aborted and an Error of kind Aborted is returned.
+	// It is also the context of shell commands, unless ShellContext is set.
+	Context context.Context
+	// ShellContext, if non-nil, is the context passed to the Runner
+	// of shell commands instead of Context.
+	// For example, it lets shell commands run longer
+	// than a deadline on the searches of an edit.
+	ShellContext context.Context
+	// Marks, if non-nil, is the mark table read and set by the edit.
+	// Marks set by the edit are addresses in the text before the edit;
+	// the caller should Update the marks after applying the returned Diffs.
+	Marks Marks
+	// Runner, if non-nil, runs the shell commands of the edit.
+	// If nil, shell commands are run by an ExecRunner
+	// in the current directory and environment.
+	// If Runner is an ExecRunner with a non-empty Dir,
+	// relative file names of the e, r, and w commands
+	// are also relative to its Dir instead of the current directory.
+	Runner Runner
+	// NoShell disables shell commands.
+	// If true, an edit with a shell command fails
+	// with an Error of kind Shell wrapping ErrNoShell.
+	NoShell bool
+	// NoFiles disables reading and writing files
+	// with the e, r, and w commands.
+	// If true, an edit with one of these commands fails
+	// with an Error of kind IO wrapping ErrNoFiles.
+	//
+	// Together, NoShell and NoFiles allow executing untrusted edits.
+	NoFiles bool
+}
+
+// EditOptions is like Edit, but with the given Options.
+// It also returns the value of dot after the edit,
+// as an address in the text after applying the Diffs.
+// As in Sam, dot is the address of the last command executed,
+// or the text changed by the command if it changed the text.
+func EditOptions(dot [2]int64, t string, print io.Writer, ro rope.Rope, opts Options) (Diffs, [2]int64, error) {
+	p, err := Parse(t)
+	if err != nil {
+		return nil, dot, err
 	}
-	switch r, t := next(trimSpaceLeft(t)); r {
-	default:
-		return nil, "", errors.New("bad command " + string([]rune{r}))
-	case eof, '\n':
-		return nil, "", NoCommandError{At: a}
-	case 'a', 'c', 'd', 'i':
-		return change(a, t, r, ro)
-	case 'm':
-		return move(dot, a, t, ro)
-	case 'p':
-		_, err := rope.Slice(ro, a[0], a[1]).WriteTo(print)
-		return nil, t, err
-	case 't':
-		return copy(dot, a, t, ro)
-	case 's':
-		return sub(a, t, ro)
-	case 'g', 'v':
-		return cond(a, t, r, print, ro)
-	case 'x', 'y':
-		return loop(a, t, r, print, ro)
-	case '{':
-		return seq(a, t, print, ro)
-	case '<', '>', '|':
-		return pipe(a, t, r, print, ro)
-	}
-}
-
-func change(a [2]int64, t string, op rune, ro rope.Rope) (Diffs, string, error) {
-	switch op {
-	case 'a':
-		a[0] = a[1]
-	case 'i':
-		a[1] = a[0]
+	return p.ExecOptions(dot, ro, print, opts)
+}
+
+// EditFiles computes an edit on a set of files with the given Options.
+// The edit begins in the current file using the file's values for dot and marks.
+// The Marks of the Options are not used;
+// each file's edit uses the file's Marks.
+//
+// The returned slice has an element for each file changed by the edit,
+// in the order in which the files were first changed,
+// followed by the current file at the end of the edit, if it was not changed.
+// The Diffs of each element are computed against
+// the text of the file at the time that EditFiles was called.
+func EditFiles(fs Files, t string, print io.Writer, opts Options) ([]FileDiffs, error) {
+	p, err := Parse(t)
+	if err != nil {
+		return nil, err
+	}
+	return p.ExecFiles(fs, print, opts)
+}
+
+// Exec computes the edit of the program
+// on the rope using the given value for dot.
+func (p *Program) Exec(dot [2]int64, ro rope.Rope, print io.Writer) (Diffs, error) {
+	return edit(&state{ctx: context.Background(), print: print}, dot, p.Cmd, ro)
+}
+
+// ExecOptions is like Exec, but with the given Options.
+// See EditOptions.
+func (p *Program) ExecOptions(dot [2]int64, ro rope.Rope, print io.Writer, opts Options) (Diffs, [2]int64, error) {
+	st := newState(print, opts)
+	st.marks = opts.Marks
+	ds, err := edit(st, dot, p.Cmd, ro)
+	if err != nil {
+		return nil, dot, err
 	}
-	var text string
-	if op != 'd' {
-		text, t = parseText(t)
+	return ds, adjustDot(st.dot, ds), nil
+}
+
+// adjustDot returns dot, an address in the text before the Diffs,
+// adjusted to the text after applying the Diffs.
+// Text inserted at the start of dot is not included in dot,
+// and text inserted at the end of dot is.
+func adjustDot(dot [2]int64, ds Diffs) [2]int64 {
+	for _, d := range ds {
+		delta := d.TextLen() - (d.At[1] - d.At[0])
+		switch {
+		case dot[0] >= d.At[1] && dot[0] > d.At[0]:
+			dot[0] += delta
+		case dot[0] > d.At[0]:
+			dot[0] = d.At[0]
+		}
+		switch {
+		case dot[1] >= d.At[1]:
+			dot[1] += delta
+		case dot[1] > d.At[0]:
+			dot[1] = d.At[0] + d.TextLen()
+		}
 	}
-	return Diffs{{At: a, Text: rope.New(text)}}, t, nil
+	return dot
 }
 
-func parseText(t0 string) (text, t string) {
-	t = strings.TrimLeftFunc(t0, func(r rune) bool {
-		return unicode.IsSpace(r) && r != '\n'
-	})
+// ExecFiles computes the edit of the program on a set of files
+// with the given Options.
+// See EditFiles.
+func (p *Program) ExecFiles(fs Files, print io.Writer, opts Options) ([]FileDiffs, error) {
+	st := newState(print, opts)
+	st.files, st.file = fs, fs.Current()
+	dot, ro := [2]int64{}, rope.Empty()
+	if st.file != nil {
+		dot, ro, st.marks = st.file.Dot(), st.file.Text(), st.file.Marks()
+	}
+	st.dot = dot
+	ds, err := edit(st, dot, p.Cmd, ro)
+	if err != nil {
+		return nil, err
+	}
+	if err := addFileDiffs(st, p.Cmd, st.file, ds); err != nil {
+		return nil, err
+	}
+	leaveFile(st, st.file, st.dot)
+	var fds []FileDiffs
+	cur := false
+	for _, d := range st.diffs {
+		d.Dot = adjustDot(fileDot(st, d.File), d.Diffs)
+		fds = append(fds, d.FileDiffs)
+		cur = cur || d.File == st.file
+	}
+	if !cur && st.file != nil {
+		fds = append(fds, FileDiffs{File: st.file, Dot: fileDot(st, st.file)})
+	}
+	return fds, nil
+}
+
+// leaveFile records the dot of a file that is no longer current,
+// in the text before the edit.
+func leaveFile(st *state, f File, dot [2]int64) {
+	if f != nil {
+		st.dots[f] = dot
+	}
+}
+
+// fileDot returns the dot of a file in the text before the edit.
+func fileDot(st *state, f File) [2]int64 {
+	if dot, ok := st.dots[f]; ok {
+		return dot
+	}
+	return f.Dot()
+}
+
+// newState returns a new state for an edit with the given Options,
+// but with no marks.
+func newState(print io.Writer, opts Options) *state {
+	st := &state{
+		ctx:      opts.Context,
+		shellCtx: opts.ShellContext,
+		print:    print,
+		runner:   opts.Runner,
+		noShell:  opts.NoShell,
+		noFiles:  opts.NoFiles,
+		dots:     make(map[File][2]int64),
+	}
+	if st.ctx == nil {
+		st.ctx = context.Background()
+	}
+	if r, ok := opts.Runner.(ExecRunner); ok {
+		st.dir = r.Dir
+	}
+	return st
+}
+
+// state is the state of an edit in progress.
+type state struct {
+	// ctx aborts regular expression searches when it is done.
+	ctx context.Context
+	// shellCtx is the context of shell commands,
+	// or nil to use ctx.
+	shellCtx context.Context
+	print    io.Writer
+	// runner runs shell commands, or is nil for an ExecRunner.
+	runner Runner
+	// noShell disables shell commands.
+	noShell bool
+	// noFiles disables the e, r, and w commands.
+	noFiles bool
+	// dir is the directory of relative file names,
+	// or empty for the current directory.
+	dir string
+	// files is the file set, or nil if there is none.
+	files Files
+	// file is the current file, or nil if there is none.
+	file File
+	// marks are the marks of the current file, or nil if there are none.
+	marks Marks
+	// diffs are the accumulated diffs of each file.
+	diffs []pendingDiffs
+	// dot is the address of the last command executed,
+	// or of the text it changed, in the text before the edit.
+	dot [2]int64
+	// dots are the dots of files that were the current file,
+	// in the text before the edit.
+	dots map[File][2]int64
+}
+
+type pendingDiffs struct {
+	FileDiffs
+	at, adj int64 // see appendAdjusted
+}
+
+func addFileDiffs(st *state, c Command, f File, ds Diffs) error {
+	if len(ds) == 0 {
+		return nil
+	}
+	if f == nil {
+		return errorAt(NoFile, c, "no current file")
+	}
+	for i := range st.diffs {
+		d := &st.diffs[i]
+		if d.File != f {
+			continue
+		}
+		var err error
+		d.at, d.adj, d.Diffs, err = appendAdjusted(c, d.at, d.adj, d.Diffs, ds)
+		return err
+	}
+	d := pendingDiffs{FileDiffs: FileDiffs{File: f}, at: -1}
+	var err error
+	if d.at, d.adj, d.Diffs, err = appendAdjusted(c, d.at, d.adj, nil, ds); err != nil {
+		return err
+	}
+	st.diffs = append(st.diffs, d)
+	return nil
+}
+
+func edit(st *state, dot [2]int64, c Command, ro rope.Rope) (Diffs, error) {
+	a0, err := addr(st, &dot, c.Address(), ro)
+	a := a0
 	switch {
-	case len(t) == 0:
-		return "", ""
-	case t[0] == '\n':
-		return parseLines(t[1:])
+	case err != nil:
+		return nil, err
+	case a[0] < 0:
+		a = dot
+	}
+	st.dot = a
+	switch c := c.(type) {
+	case *AddrCmd:
+		return nil, NoCommandError{At: a}
+	case *ChangeCmd:
+		return change(st, a, c), nil
+	case *MoveCmd:
+		return move(st, dot, a, c, ro)
+	case *CopyCmd:
+		return copy(st, dot, a, c, ro)
+	case *PrintCmd:
+		_, err := rope.Slice(ro, a[0], a[1]).WriteTo(st.print)
+		return nil, wrapError(IO, c, err)
+	case *MarkCmd:
+		return nil, mark(st, a, c)
+	case *PrintAddrCmd:
+		return nil, printAddr(st, a, c, ro)
+	case *SubCmd:
+		return sub(st, a, c, ro)
+	case *CondCmd:
+		return cond(st, a, c, ro)
+	case *LoopCmd:
+		return loop(st, a, c, ro)
+	case *SeqCmd:
+		return seq(st, a, c, ro)
+	case *PipeCmd:
+		return pipe(st, a, c, ro)
+	case *FileLoopCmd:
+		return nil, fileLoop(st, c)
+	case *FileSetCmd:
+		return nil, fileSet(st, c)
+	case *FileCmd:
+		if c.Op == 'w' && a0[0] < 0 {
+			a = [2]int64{0, ro.Len()}
+		}
+		return file(st, a, c, ro)
 	default:
-		delim, w := utf8.DecodeRuneInString(t)
-		return parseDelimited(t[w:], nil, delim)
+		panic("impossible")
 	}
 }
 
-func parseLines(t string) (string, string) {
-	var i int
-	nl := true
-	var s strings.Builder
-	for {
-		var r rune
-		switch r, t = next(t); {
-		case r == eof:
-			if nl && i > 0 {
-				s.WriteRune('\n')
-			}
-			return s.String(), ""
-		case nl && r == '.' && len(t) == 0:
-			return s.String(), t
-		case nl && r == '.' && t[0] == '\n':
-			return s.String(), t[1:]
-		default:
-			if nl && i > 0 {
-				s.WriteRune('\n')
-			}
-			i++
-			if r == '\n' {
-				nl = true
-			} else {
-				s.WriteRune(r)
-				nl = false
-			}
-		}
+func mark(st *state, a [2]int64, c *MarkCmd) error {
+	if st.marks == nil {
+		return errorAt(NoMark, c, "no marks")
 	}
+	st.marks[c.Name] = a
+	return nil
 }
 
-func parseDelimited(t string, sub func(int) string, delim rune) (string, string) {
-	var s strings.Builder
-	for {
-		var r rune
-		switch r, t = next(t); {
-		case r == eof || r == '\n' || r == delim:
-			return s.String(), t
-		case r == '\\' && sub != nil:
-			if r, t1 := next(t); '0' <= r && r <= '9' {
-				t = t1
-				s.WriteString(sub(int(r - '0')))
-				continue
-			}
-			fallthrough
-		case r == '\\':
-			r, t = next(t)
-			s.WriteRune(esc(r))
-		default:
-			s.WriteRune(r)
+func printAddr(st *state, a [2]int64, c *PrintAddrCmd, ro rope.Rope) error {
+	var str string
+	if c.Runes {
+		m := rope.RuneNumber(ro, a[0])
+		str = "#" + strconv.FormatInt(m, 10)
+		if a[1] > a[0] {
+			n := rope.RuneNumber(ro, a[1])
+			str += ",#" + strconv.FormatInt(n, 10)
+		}
+	} else {
+		m := rope.LineNumber(ro, a[0]) + 1
+		n := rope.LineNumber(ro, a[1]) + 1
+		if n > m && rope.Slice(ro, a[1]-1, a[1]).String() == "\n" {
+			n--
+		}
+		str = strconv.FormatInt(m, 10)
+		if n > m {
+			str += "," + strconv.FormatInt(n, 10)
 		}
 	}
+	_, err := io.WriteString(st.print, str+"\n")
+	return wrapError(IO, c, err)
 }
 
-func esc(r rune) rune {
-	switch r {
-	case eof:
-		return '\\'
-	case 'n', '\n':
-		return '\n'
-	case 't':
-		return '\t'
-	default:
-		return r
+func change(st *state, a [2]int64, c *ChangeCmd) Diffs {
+	switch c.Op {
+	case 'a':
+		a[0] = a[1]
+	case 'i':
+		a[1] = a[0]
 	}
+	st.dot = a
+	return Diffs{{At: a, Text: rope.New(c.Text)}}
 }
 
-func move(dot, a [2]int64, t string, ro rope.Rope) (Diffs, string, error) {
-	b, t, err := addr(&dot, ro, t)
+func move(st *state, dot, a [2]int64, c *MoveCmd, ro rope.Rope) (Diffs, error) {
+	b, err := addr(st, &dot, c.Dest, ro)
 	switch {
 	case err != nil:
-		return nil, "", err
-	case b[0] < 0:
-		return nil, "", errors.New("expected address")
+		return nil, err
 	case a[0] == a[1]:
 		// Moving nothing is a no-op,
-		return nil, t, nil
+		return nil, nil
 	case a[0] <= b[1] && b[1] < a[1]:
 		// Moving to a destination inside the moved text is a no-op,
-		return nil, t, nil
+		return nil, nil
 	case a[1] < b[1]:
 		// Moving text from before the dest, slide left by the delta
+		st.dot = [2]int64{b[1], b[1]}
 		b[1] -= a[1] - a[0]
+	default:
+		st.dot = [2]int64{b[1], b[1]}
 	}
 	ds := Diffs{
 		{At: a, Text: nil},
 		{At: [2]int64{b[1], b[1]}, Text: rope.Slice(ro, a[0], a[1])},
 	}
-	return ds, t, nil
+	return ds, nil
 }
 
-func copy(dot, a [2]int64, t string, ro rope.Rope) (Diffs, string, error) {
-	b, t, err := addr(&dot, ro, t)
+func copy(st *state, dot, a [2]int64, c *CopyCmd, ro rope.Rope) (Diffs, error) {
+	b, err := addr(st, &dot, c.Dest, ro)
 	switch {
 	case err != nil:
-		return nil, "", err
+		return nil, err
 	case a[0] == a[1]:
 		// Copying nothing is a no-op,
-		return nil, t, nil
-	case b[0] < 0:
-		return nil, "", errors.New("expected address")
+		return nil, nil
 	}
-	return Diffs{{At: [2]int64{b[1], b[1]}, Text: rope.Slice(ro, a[0], a[1])}}, t, nil
+	st.dot = [2]int64{b[1], b[1]}
+	return Diffs{{At: [2]int64{b[1], b[1]}, Text: rope.Slice(ro, a[0], a[1])}}, nil
 }
 
-func sub(a [2]int64, t string, ro rope.Rope) (Diffs, string, error) {
-	n, t, _ := number(trimSpaceLeft(t))
-	delim, _ := next(trimSpaceLeft(t))
-	re, t, err := parseRegexp(t)
-	if err != nil {
-		return nil, "", err
-	}
-	tmpl := t
-	_, t = parseDelimited(t, nil, delim)
-	var global bool
-	if r, t1 := next(trimSpaceLeft(t)); r == 'g' {
-		t = t1
-		global = true
-	}
-
+func sub(st *state, a [2]int64, c *SubCmd, ro rope.Rope) (Diffs, error) {
 	var ds Diffs
 	var adj int64
 	var ms []int64
@@ -518,128 +909,115 @@ func sub(a [2]int64, t string, ro rope.Rope) (Diffs, string, error) {
 		}
 		return rope.Slice(ro, ms[i], ms[i+1]).String()
 	}
-	for a[0] <= a[1] {
-		if ms = re.FindInRope(ro, a[0], a[1]); ms == nil {
-			break
-		}
-		if len(ms) > 0 {
-			ms = ms[:len(ms)-1] // trim regexp ID
-		}
-		if ms[1] == ms[0] {
-			a[0]++
-		} else {
-			a[0] = ms[1]
+	n := c.N
+	err := c.Regexp.FindAllInRopeContext(st.ctx, ro, a[0], a[1], func(m []int64) bool {
+		ms = m[:len(m)-1] // trim regexp ID
+		if n--; n > 0 {
+			return true
 		}
-		n--
-		if n > 0 {
-			continue
-		}
-		s, _ := parseDelimited(tmpl, sub, delim)
+		s, _, _ := expandTemplate(c.Template, c.Regexp, sub)
 		ds = append(ds, Diff{
 			At:   [2]int64{ms[0] - adj, ms[1] - adj},
 			Text: rope.New(s),
 		})
-		if !global {
-			break
-		}
 		adj += ms[1] - ms[0] - int64(len(s))
+		return c.Global
+	})
+	if err != nil {
+		return nil, wrapError(Aborted, c, err)
 	}
 	if len(ds) == 0 {
-		return nil, "", errors.New("no match")
+		return nil, errorAt(NoMatch, c, "no match")
 	}
-	return ds, t, nil
+	return ds, nil
 }
 
-func cond(a [2]int64, t string, op rune, print io.Writer, ro rope.Rope) (Diffs, string, error) {
-	re, t, err := parseRegexp(t)
+func cond(st *state, a [2]int64, c *CondCmd, ro rope.Rope) (Diffs, error) {
+	ms, err := c.Regexp.FindInRopeContext(st.ctx, ro, a[0], a[1])
 	if err != nil {
-		return nil, "", err
+		return nil, wrapError(Aborted, c, err)
 	}
-	cmd, t := splitNewline(t)
-	ms := re.FindInRope(ro, a[0], a[1])
-	if op == 'g' && ms == nil || op == 'v' && ms != nil {
-		return nil, t, nil
+	if c.Op == 'g' && ms == nil || c.Op == 'v' && ms != nil {
+		return nil, nil
 	}
-	ds, err := Edit(a, cmd, print, ro)
-	return ds, t, err
+	return edit(st, a, c.Cmd, ro)
 }
 
-func loop(a [2]int64, t string, op rune, print io.Writer, ro rope.Rope) (Diffs, string, error) {
-	re, t, err := parseRegexp(t)
-	if err != nil {
-		return nil, "", err
-	}
-	cmd, t := splitNewline(t)
+func loop(st *state, a [2]int64, c *LoopCmd, ro rope.Rope) (Diffs, error) {
 	var diffs Diffs
 	prev := a[0]
 	at := int64(-1)
 	var adj int64
-	for a[0] <= a[1] {
-		ms := re.FindInRope(ro, a[0], a[1])
-		if ms == nil {
-			break
-		}
-		if ms[0] == ms[1] {
-			// If we matched empty, advance by one over it.
-			a[0] = ms[1] + 1
-		} else {
-			a[0] = ms[1]
-		}
+	var err error
+	ferr := c.Regexp.FindAllInRopeContext(st.ctx, ro, a[0], a[1], func(ms []int64) bool {
 		dot := [2]int64{ms[0], ms[1]}
-		if op == 'y' {
+		if c.Op == 'y' {
 			dot = [2]int64{prev, ms[0]}
 			prev = ms[1]
 		}
 		var ds Diffs
-		if ds, err = Edit(dot, cmd, print, ro); err != nil {
-			return nil, "", err
-		}
-		if at, adj, diffs, err = appendAdjusted(at, adj, diffs, ds); err != nil {
-			return nil, "", err
+		if ds, err = edit(st, dot, c.Cmd, ro); err != nil {
+			return false
 		}
+		at, adj, diffs, err = appendAdjusted(c, at, adj, diffs, ds)
+		return err == nil
+	})
+	switch {
+	case err != nil:
+		return nil, err
+	case ferr != nil:
+		return nil, wrapError(Aborted, c, ferr)
 	}
-	if op == 'y' {
-		ds, err := Edit([2]int64{prev, a[1]}, cmd, print, ro)
+	if c.Op == 'y' {
+		ds, err := edit(st, [2]int64{prev, a[1]}, c.Cmd, ro)
 		if err != nil {
-			return nil, "", err
+			return nil, err
 		}
-		if _, _, diffs, err = appendAdjusted(at, adj, diffs, ds); err != nil {
-			return nil, "", err
+		if _, _, diffs, err = appendAdjusted(c, at, adj, diffs, ds); err != nil {
+			return nil, err
 		}
 	}
-	return diffs, t, nil
+	return diffs, nil
 }
 
-func seq(a [2]int64, t string, print io.Writer, ro rope.Rope) (Diffs, string, error) {
+func seq(st *state, a [2]int64, c *SeqCmd, ro rope.Rope) (Diffs, error) {
 	var diffs Diffs
 	at := int64(-1)
 	var adj int64
-	for {
-		t = trimSpaceLeft(t)
-		if r, t1 := next(t); r == '}' {
-			return diffs, t1, nil
-		} else if r == eof {
-			return nil, "", errors.New("unclosed {")
-		}
-
-		var cmd string
-		cmd, t = splitNewline(t)
-		ds, err := Edit(a, cmd, print, ro)
+	for _, kid := range c.Cmds {
+		f, dot := st.file, st.dot
+		ds, err := edit(st, a, kid, ro)
 		if err != nil {
-			return nil, "", err
+			return nil, err
+		}
+		if st.file != f {
+			// The command changed the current file.
+			// The diffs so far are of the previous file,
+			// and the following commands edit the new one.
+			if err := addFileDiffs(st, c, f, diffs); err != nil {
+				return nil, err
+			}
+			leaveFile(st, f, dot)
+			diffs, at, adj = nil, -1, 0
+			a, ro = [2]int64{}, rope.Empty()
+			if st.file != nil {
+				a, ro = st.file.Dot(), st.file.Text()
+			}
+			st.dot = a
 		}
-		if at, adj, diffs, err = appendAdjusted(at, adj, diffs, ds); err != nil {
-			return nil, "", err
+		if at, adj, diffs, err = appendAdjusted(c, at, adj, diffs, ds); err != nil {
+			return nil, err
 		}
 	}
+	return diffs, nil
 }
 
-func appendAdjusted(at, adj int64, diffs, ds Diffs) (int64, int64, Diffs, error) {
+func appendAdjusted(c Command, at, adj int64, diffs, ds Diffs) (int64, int64, Diffs, error) {
 	var atNext int64
 	for i := range ds {
 		d := &ds[i]
 		if d.At[0] < at {
-			return 0, 0, nil, errors.New("out of order")
+			return 0, 0, nil, errorAt(OutOfOrder, c, "out of order")
 		}
 		// TODO: if a single diff was a delete+add instead of delete|add,
 		// then we could just update at here.
@@ -657,298 +1035,399 @@ func appendAdjusted(at, adj int64, diffs, ds Diffs) (int64, int64, Diffs, error)
 	return at, adj, diffs, nil
 }
 
-func pipe(a [2]int64, t string, op rune, print io.Writer, ro rope.Rope) (Diffs, string, error) {
-	shell := os.Getenv("SHELL")
-	if shell == "" {
-		shell = "/bin/sh"
-	}
-	arg, t := splitNewline(t)
-	if arg = strings.TrimSpace(arg); arg == "" {
-		return nil, "", errors.New("expected command")
+func pipe(st *state, a [2]int64, c *PipeCmd, ro rope.Rope) (Diffs, error) {
+	if st.noShell {
+		return nil, wrapError(Shell, c, ErrNoShell)
+	}
+	r := st.runner
+	if r == nil {
+		r = ExecRunner{}
+	}
+	var stdin io.Reader
+	if c.Op == '>' || c.Op == '|' {
+		stdin = rope.NewReader(rope.Slice(ro, a[0], a[1]))
+	}
+	stdout := st.print
+	var out strings.Builder
+	if c.Op == '<' || c.Op == '|' {
+		stdout = &out
+	}
+	ctx := st.shellCtx
+	if ctx == nil {
+		ctx = st.ctx
+	}
+	if err := r.Run(ctx, c.Shell, stdin, stdout, st.print); err != nil {
+		return nil, wrapError(Shell, c, err)
+	}
+	switch c.Op {
+	case '<':
+		return Diffs{{At: a, Text: rope.New(out.String())}}, nil
+	case '|':
+		// Only change what the command changed,
+		// so addresses in unchanged text are kept.
+		ds := DiffRopes(rope.Slice(ro, a[0], a[1]), rope.New(out.String()))
+		for i := range ds {
+			ds[i].At[0] += a[0]
+			ds[i].At[1] += a[0]
+		}
+		return ds, nil
+	default:
+		return nil, nil
 	}
-	cmd := exec.Command(shell, "-c", arg)
-	stdin, stdout, err := openPipes(cmd, print, op)
-	if err != nil {
-		return nil, "", err
+}
+
+func fileLoop(st *state, c *FileLoopCmd) error {
+	if st.files == nil {
+		return errorAt(NoFile, c, "no files")
 	}
-	if err := cmd.Start(); err != nil {
-		closeIfNonNil(stdin)
-		closeIfNonNil(stdout)
-		return nil, "", err
+	for _, f := range st.files.Files() {
+		ms := c.Regexp.Find(strings.NewReader(menuLine(st, f)))
+		if c.Op == 'X' && ms == nil || c.Op == 'Y' && ms != nil {
+			continue
+		}
+		fst := *st
+		fst.file, fst.marks, fst.dot = f, f.Marks(), f.Dot()
+		ds, err := edit(&fst, f.Dot(), c.Cmd, f.Text())
+		st.diffs = fst.diffs
+		if err != nil {
+			return err
+		}
+		// The command may have changed the current file.
+		if err := addFileDiffs(st, c, fst.file, ds); err != nil {
+			return err
+		}
+		leaveFile(st, fst.file, fst.dot)
+		if fst.file == st.file {
+			st.dot = fst.dot
+		}
 	}
+	return nil
+}
 
-	var ds Diffs
-	var wg sync.WaitGroup
-	if op == '<' || op == '|' {
-		wg.Add(1)
-		go func() {
-			txt, _ := rope.ReadFrom(stdout)
-			ds = Diffs{{At: a, Text: txt}}
-			wg.Done()
-		}()
-	}
-	if op == '>' || op == '|' {
-		wg.Add(1)
-		go func() {
-			rope.Slice(ro, a[0], a[1]).WriteTo(stdin)
-			stdin.Close()
-			wg.Done()
-		}()
-	}
-
-	wg.Wait()
-	err = cmd.Wait()
-	return ds, t, err
-}
-
-func openPipes(cmd *exec.Cmd, print io.Writer, op rune) (io.WriteCloser, io.ReadCloser, error) {
-	cmd.Stderr = print
-	if op == '>' {
-		cmd.Stdout = print
+func fileSet(st *state, c *FileSetCmd) error {
+	if st.files == nil {
+		return errorAt(NoFile, c, "no files")
 	}
-	var err error
-	var stdin io.WriteCloser
-	if op == '>' || op == '|' {
-		if stdin, err = cmd.StdinPipe(); err != nil {
-			return nil, nil, err
+	switch c.Op {
+	case 'b':
+		f, err := findFile(st, c, c.Names[0])
+		if err != nil {
+			return err
 		}
-	}
-	var stdout io.ReadCloser
-	if op == '<' || op == '|' {
-		if stdout, err = cmd.StdoutPipe(); err != nil {
-			closeIfNonNil(stdin)
-			return nil, nil, err
+		setFile(st, f)
+
+	case 'B':
+		var f File
+		for _, name := range c.Names {
+			var err error
+			if f, err = st.files.Open(name); err != nil {
+				return wrapError(IO, c, err)
+			}
+		}
+		setFile(st, f)
+
+	case 'D':
+		if len(c.Names) == 0 {
+			if st.file == nil {
+				return errorAt(NoFile, c, "no current file")
+			}
+			return closeFile(st, c, st.file)
+		}
+		for _, name := range c.Names {
+			f, err := findFile(st, c, name)
+			if err != nil {
+				return err
+			}
+			if err := closeFile(st, c, f); err != nil {
+				return err
+			}
+		}
+
+	case 'n':
+		for _, f := range st.files.Files() {
+			if _, err := io.WriteString(st.print, menuLine(st, f)); err != nil {
+				return wrapError(IO, c, err)
+			}
 		}
 	}
-	return stdin, stdout, nil
+	return nil
+}
+
+// setFile makes f the current file
+// of the file set and of the following commands.
+func setFile(st *state, f File) {
+	st.files.SetCurrent(f)
+	st.file, st.marks = f, f.Marks()
 }
 
-func closeIfNonNil(c io.Closer) {
-	if c != nil {
-		c.Close()
+// closeFile closes f.
+// If f is the current file, there is no current file
+// for the following commands.
+func closeFile(st *state, c Command, f File) error {
+	if err := st.files.Close(f); err != nil {
+		return wrapError(IO, c, err)
 	}
+	if f == st.file {
+		st.file, st.marks = nil, nil
+	}
+	return nil
 }
 
-func splitNewline(str string) (string, string) {
-	i := strings.IndexRune(str, '\n')
-	if i < 0 {
-		return str, ""
+func findFile(st *state, c Command, name string) (File, error) {
+	for _, f := range st.files.Files() {
+		if f.Name() == name {
+			return f, nil
+		}
 	}
-	return str[:i+1], str[i+1:]
+	return nil, errorAt(NoFile, c, "no file "+name)
 }
 
-func parseRegexp(t string) (*re1.Regexp, string, error) {
-	delim, t := next(trimSpaceLeft(t))
-	if delim == eof {
-		return nil, "", errors.New("expected regular expression")
+func menuLine(st *state, f File) string {
+	if f == st.file {
+		return ". " + f.Name() + "\n"
+	}
+	return "  " + f.Name() + "\n"
+}
+
+func file(st *state, a [2]int64, c *FileCmd, ro rope.Rope) (Diffs, error) {
+	if (c.Op == 'e' || c.Op == 'f') && (st.files == nil || st.file == nil) {
+		return nil, errorAt(NoFile, c, "no current file")
+	}
+	name := c.Name
+	if name == "" && c.Op != 'f' {
+		if st.file == nil {
+			return nil, errorAt(NoFile, c, "expected file name")
+		}
+		name = st.file.Name()
+	}
+	if c.Op != 'f' {
+		if st.noFiles {
+			return nil, wrapError(IO, c, ErrNoFiles)
+		}
+		if st.dir != "" && !filepath.IsAbs(name) {
+			name = filepath.Join(st.dir, name)
+		}
+	}
+	switch c.Op {
+	case 'e':
+		txt, err := readFile(name)
+		if err != nil {
+			return nil, wrapError(IO, c, err)
+		}
+		if name != st.file.Name() {
+			if err := st.files.Rename(st.file, name); err != nil {
+				return nil, wrapError(IO, c, err)
+			}
+		}
+		return Diffs{{At: [2]int64{0, ro.Len()}, Text: txt}}, nil
+
+	case 'f':
+		if name != "" {
+			if err := st.files.Rename(st.file, name); err != nil {
+				return nil, wrapError(IO, c, err)
+			}
+		}
+		_, err := io.WriteString(st.print, menuLine(st, st.file))
+		return nil, wrapError(IO, c, err)
+
+	case 'r':
+		txt, err := readFile(name)
+		if err != nil {
+			return nil, wrapError(IO, c, err)
+		}
+		return Diffs{{At: a, Text: txt}}, nil
+
+	default: // 'w'
+		f, err := os.Create(name)
+		if err != nil {
+			return nil, wrapError(IO, c, err)
+		}
+		if _, err := rope.Slice(ro, a[0], a[1]).WriteTo(f); err != nil {
+			f.Close()
+			return nil, wrapError(IO, c, err)
+		}
+		return nil, wrapError(IO, c, f.Close())
 	}
-	re, t, err := re1.New(t, re1.Opts{Delimiter: delim})
-	return re, t, err
 }
 
-func addr(dot *[2]int64, ro rope.Rope, t string) ([2]int64, string, error) {
-	left, t, err := addr1(*dot, 0, false, ro, t)
+// ErrNoFiles is the error of an e, r, or w command in an edit
+// executed with Options.NoFiles.
+var ErrNoFiles = errors.New("file commands are disabled")
+
+func readFile(name string) (rope.Rope, error) {
+	f, err := os.Open(name)
 	if err != nil {
-		return [2]int64{}, "", err
+		return nil, err
 	}
-	if left, t, err = addr2(*dot, left, ro, t); err != nil {
-		return [2]int64{}, "", err
+	defer f.Close()
+	return rope.ReadFrom(f)
+}
+
+func addr(st *state, dot *[2]int64, a Address, ro rope.Rope) ([2]int64, error) {
+	switch a := a.(type) {
+	case nil:
+		return [2]int64{-1, -1}, nil
+	case *RangeAddr:
+		return rangeAddr(st, dot, a, ro)
+	case *RelAddr:
+		return relAddr(st, *dot, a, ro)
+	default:
+		return addr1(st, *dot, 0, a, ro)
 	}
-	return addr3(dot, left, ro, t)
 }
 
-func addr3(dot *[2]int64, left [2]int64, ro rope.Rope, t0 string) ([2]int64, string, error) {
-	r, t := next(trimSpaceLeft(t0))
+func rangeAddr(st *state, dot *[2]int64, a *RangeAddr, ro rope.Rope) ([2]int64, error) {
+	left, err := addr(st, dot, a.Left, ro)
 	switch {
-	case r == eof:
-		return left, "", nil
-	case r != ',' && r != ';':
-		return left, t0, nil
+	case err != nil:
+		return [2]int64{}, err
 	case left[0] < 0:
 		left = [2]int64{}
 	}
-	if r == ';' {
+	if a.Op == ';' {
 		*dot = left
 	}
-	switch right, t, err := addr(dot, ro, t); {
+	switch right, err := addr(st, dot, a.Right, ro); {
 	case err != nil:
-		return [2]int64{}, "", err
+		return [2]int64{}, err
 	case right[0] < 0:
 		right = [2]int64{ro.Len(), ro.Len()}
 		fallthrough
 	default:
 		if left[0] > right[1] {
-			return [2]int64{}, t, errors.New("address out of order")
+			return [2]int64{}, errorAt(BadAddr, a, "address out of order")
 		}
-		return addr3(dot, [2]int64{left[0], right[1]}, ro, t)
+		return [2]int64{left[0], right[1]}, nil
 	}
 }
 
-func addr2(dot, left [2]int64, ro rope.Rope, t0 string) ([2]int64, string, error) {
-	r, t := next(trimSpaceLeft(t0))
+func relAddr(st *state, dot [2]int64, a *RelAddr, ro rope.Rope) ([2]int64, error) {
+	left, err := addr(st, &dot, a.Left, ro)
 	switch {
-	case r == eof:
-		return left, "", nil
-	case strings.ContainsRune(addr1First, r):
-		t, r = t0, '+' // Insert +
-	case r != '+' && r != '-':
-		return left, t0, nil
-	}
-	if left[0] < 0 {
+	case err != nil:
+		return [2]int64{}, err
+	case left[0] < 0:
 		left = dot
 	}
 	at := left[1]
-	if r == '-' {
+	if a.Op == '-' {
 		at = left[0]
 	}
-	switch right, t, err := addr1(dot, at, r == '-', ro, t); {
-	case err != nil:
-		return [2]int64{}, "", err
-	case right[0] < 0:
-		if right, _, err = addr1(dot, at, r == '-', ro, "1"); err != nil {
-			return [2]int64{}, t, err
-		}
-		fallthrough
-	default:
-		return addr2(dot, right, ro, t)
+	right := a.Right
+	if right == nil {
+		right = &LineAddr{node: a.node, N: 1, Reverse: a.Op == '-'}
 	}
+	return addr1(st, dot, at, right, ro)
 }
 
-const addr1First = ".'#0123456789/$"
-
-func addr1(dot [2]int64, at int64, rev bool, ro rope.Rope, t0 string) ([2]int64, string, error) {
-	t0 = trimSpaceLeft(t0)
-	switch r, t := next(t0); r {
-	case eof:
-		return [2]int64{-1, -1}, "", nil
+func addr1(st *state, dot [2]int64, at int64, a Address, ro rope.Rope) ([2]int64, error) {
+	switch a := a.(type) {
+	case *DotAddr:
+		return dot, nil
+	case *EndAddr:
+		return [2]int64{ro.Len(), ro.Len()}, nil
+	case *MarkAddr:
+		return markAddr(st, a)
+	case *RuneAddr:
+		at, err := runeAddr(ro, at, a.Reverse, a.N)
+		return at, wrapError(BadAddr, a, err)
+	case *LineAddr:
+		at, err := lineAddr(ro, at, a.Reverse, a.N)
+		return at, wrapError(BadAddr, a, err)
+	case *RegexpAddr:
+		return regexpAddr(st, ro, at, a)
 	default:
-		return [2]int64{-1, -1}, t0, nil
-	case '.':
-		return dot, t, nil
-	case '#':
-		return runeAddr(ro, at, rev, t)
-	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
-		return lineAddr(ro, at, rev, t0)
-	case '/':
-		return regexpAddr(ro, at, rev, t)
-	case '$':
-		return [2]int64{ro.Len(), ro.Len()}, t, nil
-	}
-}
-
-func runeAddr(ro rope.Rope, at int64, rev bool, t string) ([2]int64, string, error) {
-	nrunes, t, err := number(t)
-	if err != nil {
-		return [2]int64{}, "", err
+		panic("impossible")
 	}
-	var r io.RuneReader
-	if rev {
-		sl := rope.Slice(ro, 0, at)
-		r = rope.NewReverseReader(sl)
-	} else {
-		sl := rope.Slice(ro, at, ro.Len())
-		r = rope.NewReader(sl)
-	}
-	var nbytes int64
-	for nrunes > 0 {
-		_, w, err := r.ReadRune()
-		if err != nil {
-			return [2]int64{}, "", errors.New("address out of range")
-		}
-		nbytes += int64(w)
-		nrunes--
+}
+
+func markAddr(st *state, a *MarkAddr) ([2]int64, error) {
+	if st.marks == nil {
+		return [2]int64{}, errorAt(NoMark, a, "no marks")
 	}
-	if rev {
-		return [2]int64{at - nbytes, at - nbytes}, t, nil
+	m, ok := st.marks[a.Name]
+	if !ok {
+		return [2]int64{}, errorAt(NoMark, a, "mark "+string([]rune{a.Name})+" not set")
 	}
-	return [2]int64{at + nbytes, at + nbytes}, t, nil
+	return m, nil
 }
 
-func lineAddr(ro rope.Rope, at int64, rev bool, t string) ([2]int64, string, error) {
-	n, t, err := number(t)
-	if err != nil {
-		return [2]int64{}, "", err
-	}
-	var dot [2]int64
+func runeAddr(ro rope.Rope, at int64, rev bool, nrunes int) ([2]int64, error) {
+	n := rope.RuneNumber(ro, at)
 	if rev {
-		r := rope.NewReverseReader(rope.Slice(ro, 0, at))
-		dot, err = lineReverse(r, at, n)
+		n -= int64(nrunes)
 	} else {
-		// Check the previous rune.
-		rr := rope.NewReverseReader(rope.Slice(ro, 0, at))
-		if r, _, err := rr.ReadRune(); err != nil || r == '\n' {
-			n--
-		}
-		r := rope.NewReader(rope.Slice(ro, at, ro.Len()))
-		dot, err = lineForward(r, at, n)
+		n += int64(nrunes)
+	}
+	i := rope.RuneIndex(ro, n)
+	if i < 0 {
+		return [2]int64{}, errors.New("address out of range")
 	}
-	return dot, t, err
+	return [2]int64{i, i}, nil
 }
 
-func lineForward(in *rope.Reader, at int64, nlines int) ([2]int64, error) {
-	dot := [2]int64{at, at}
-	for nlines >= 0 {
-		b, err := in.ReadByte()
-		switch {
-		case err != nil && nlines == 0:
-			b = '\n'
-		case err != nil:
-			return [2]int64{}, errors.New("address out of range")
-		default:
-			at++
-		}
-		if b == '\n' {
-			nlines--
-			dot[0], dot[1] = dot[1], at
+func lineAddr(ro rope.Rope, at int64, rev bool, n int) ([2]int64, error) {
+	line := rope.LineNumber(ro, at)
+	if rev {
+		return lineReverse(ro, at, line, int64(n))
+	}
+	if rope.LineIndex(ro, line) == at {
+		// at begins a line, so that line is the first line after at.
+		if n == 0 {
+			return [2]int64{at, at}, nil
 		}
+		line--
 	}
-	return dot, nil
+	line += int64(n)
+	start := rope.LineIndex(ro, line)
+	if start < 0 {
+		return [2]int64{}, errors.New("address out of range")
+	}
+	if start < at {
+		start = at
+	}
+	end := rope.LineIndex(ro, line+1)
+	if end < 0 {
+		end = ro.Len()
+	}
+	return [2]int64{start, end}, nil
 }
 
-func lineReverse(in *rope.ReverseReader, at int64, nlines int) ([2]int64, error) {
-	dot := [2]int64{at, at}
-	for {
-		b, err := in.ReadByte()
-		switch {
-		case err != nil && nlines == 0:
-			b = '\n'
-		case err != nil:
-			if nlines == 1 {
-				return [2]int64{}, nil
-			}
-			return [2]int64{}, errors.New("address out of range")
-		}
-		if b == '\n' {
-			dot[0], dot[1] = at, dot[0]
-			if nlines--; nlines < 0 {
-				return dot, nil
-			}
-		}
-		at--
+func lineReverse(ro rope.Rope, at, line, n int64) ([2]int64, error) {
+	switch {
+	case n == line+1:
+		return [2]int64{}, nil
+	case n > line:
+		return [2]int64{}, errors.New("address out of range")
+	case n == 0:
+		return [2]int64{rope.LineIndex(ro, line), at}, nil
+	default:
+		return [2]int64{rope.LineIndex(ro, line-n), rope.LineIndex(ro, line-n+1)}, nil
 	}
 }
 
-func regexpAddr(ro rope.Rope, at int64, rev bool, t string) ([2]int64, string, error) {
-	re, t, err := re1.New(t, re1.Opts{Delimiter: '/', Reverse: rev})
-	if err != nil {
-		return [2]int64{}, "", err
-	}
-	var ms []int64
-	if rev {
-		ms = re.FindReverseInRope(ro, 0, at)
-		if ms == nil {
-			ms = re.FindReverseInRope(ro, 0, ro.Len())
-		}
-	} else {
-		ms = re.FindInRope(ro, at, ro.Len())
-		if ms == nil {
-			ms = re.FindInRope(ro, 0, ro.Len())
+func regexpAddr(st *state, ro rope.Rope, at int64, a *RegexpAddr) ([2]int64, error) {
+	find := func(s, e int64) ([]int64, error) {
+		if a.Reverse {
+			return a.Regexp.FindReverseInRopeContext(st.ctx, ro, s, e)
 		}
+		return a.Regexp.FindInRopeContext(st.ctx, ro, s, e)
 	}
-	if len(ms) == 0 {
-		return [2]int64{}, t, errors.New("no match")
+	s, e := at, ro.Len()
+	if a.Reverse {
+		s, e = 0, at
+	}
+	ms, err := find(s, e)
+	if err == nil && ms == nil {
+		ms, err = find(0, ro.Len())
+	}
+	switch {
+	case err != nil:
+		return [2]int64{}, wrapError(Aborted, a, err)
+	case len(ms) == 0:
+		return [2]int64{}, errorAt(NoMatch, a, "no match")
 	}
-	return [2]int64{ms[0], ms[1]}, t, err
+	return [2]int64{ms[0], ms[1]}, nil
 }
 
 const eof = -1
@@ -1,10 +1,18 @@
 package edit
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"regexp"
 	"strings"
 	"testing"
 
+	"github.com/eaburns/T/re1"
 	"github.com/eaburns/T/rope"
 )
 
@@ -260,6 +268,8 @@ func TestAddr(t *testing.T) {
 				{edit: "1+1", want: "世界\n"},
 				{edit: "1+0", want: ""},
 				{edit: "/世界/+0", want: "\n"},
+				{edit: "/,.世/s", want: ",\n世"},
+				{edit: "/,.世/", err: "no match"},
 				{edit: "1+3", err: "address out of range"},
 			},
 		},
@@ -290,6 +300,11 @@ func TestAddr(t *testing.T) {
 				{edit: "/世界/", want: "世界"},
 				{edit: "/[a-z][a-z][a-z]", want: "ell"},
 				{edit: "/X*", want: ""},
+				{edit: "/(?i)LLO", want: "llo"},
+				{edit: "/(?i)x", err: "no match"},
+				{edit: "/LLO/i", want: "llo"},
+				{edit: "/LLO/i,$", want: "llo, 世界"},
+				{edit: "/LLO/", err: "no match"},
 				{edit: "#2+/...", want: "llo"},
 				{edit: "$+/世界", want: "世界"}, // wrap
 				{edit: "/NoMatch", err: "no match"},
@@ -305,6 +320,25 @@ func TestAddr(t *testing.T) {
 				{edit: "#1-/NoMatch", err: "no match"},
 			},
 		},
+		{
+			name: "backward regexp",
+			str:  "Hello, 世界 Hello",
+			cases: []testCase{
+				{edit: "?Hello", want: "Hello"},
+				{edit: "?Hello?", want: "Hello"},
+				{edit: "?Hello?,$", want: "Hello"},
+				{edit: "?(?i)HELLO?,$", want: "Hello"},
+				{edit: "?HELLO?i,$", want: "Hello"},
+				{edit: "?Hello?-#1,$", want: " Hello"},
+				{edit: "$-?Hello?", want: "Hello"},
+				{edit: "#1-?l+o", want: "llo"}, // reversed, so forward
+				{edit: "#7+?..", want: ", "},
+				{edit: "#1?H", want: "H"}, // wrap
+				{edit: `?\??`, err: "no match"},
+				{edit: "?NoMatch", err: "no match"},
+				{edit: "?(", err: "unclosed [(]"},
+			},
+		},
 		{
 			// This tests a bug in a previous implementation of addr
 			// that used forward matching to implement reverse match.
@@ -692,6 +726,9 @@ func TestEdit(t *testing.T) {
 				{edit: ",s/line//g", want: "1\n2\n3"},
 				{edit: ",s/line/1/g", want: "11\n12\n13"},
 				{edit: ",s/line/12345/g", want: "123451\n123452\n123453"},
+				{edit: "1s/i*/-/g", want: "-l-n-e-1-\n-line2\nline3"},
+				{edit: "1s2/i*/-/", want: "l-ne1\nline2\nline3"},
+				{edit: "1s3/i*/-/", want: "lin-e1\nline2\nline3"},
 				{edit: `1s/line/\0\0/g`, want: "lineline1\nline2\nline3"},
 				{edit: `1s/line/\1\1/g`, want: "1\nline2\nline3"},
 				{edit: `1s/(li)(ne)(X?)/\1\1/g`, want: "lili1\nline2\nline3"},
@@ -701,6 +738,16 @@ func TestEdit(t *testing.T) {
 				{edit: `1s/(li)(ne)(X?)/\\/g`, want: "\\1\nline2\nline3"},
 				{edit: `,s/(li)(ne)(X?)/\1/g`, want: "li1\nli2\nli3"},
 				{edit: `,s//./g`, want: ".l.i.n.e.1.\n.l.i.n.e.2.\n.l.i.n.e.3."},
+				{edit: `,s/(l)(i)(n)(e)(1)(\n)(l)(i)(n)(e)(2)/\11\10\1/`, want: "2el\nline3"},
+				{edit: `1s/(l)(i)(n)(e)/\10\41/`, want: "l0e11\nline2\nline3"},
+				{edit: `1s/(?P<a>li)(?P<b>ne)/\{b}\{a}/`, want: "neli1\nline2\nline3"},
+				{edit: `1s/(?P<a>li)(ne)/\{2}\{0}\{a}/`, want: "nelineli1\nline2\nline3"},
+				{edit: `1s/(?P<a>li)ne/\{x}/`, err: "unknown group x"},
+				{edit: `1s/(li)ne/\{2}/`, err: "unknown group 2"},
+				{edit: `1s/(li)ne/\{1/`, err: "unclosed"},
+				{edit: `,s/line/\U&\0x\Ey/g`, want: "&LINEXy1\n&LINEXy2\n&LINEXy3"},
+				{edit: `,s/(l)(ine)/\U\1\L\2X/g`, want: "Linex1\nLinex2\nLinex3"},
+				{edit: `2s/line/\U\0`, want: "line1\nLINE2\nline3"},
 
 				{edit: `,s0/line/LINE/`, want: "LINE1\nline2\nline3"},
 				{edit: `,s1/line/LINE/`, want: "LINE1\nline2\nline3"},
@@ -719,7 +766,7 @@ func TestEdit(t *testing.T) {
 				{edit: ",g/*", err: "unexpected *"},
 				{edit: ",g/line/12d", err: "address out of range"},
 				{edit: ",g/line/d junk", err: "expected end-of-input"},
-				{edit: ",g/no match/d junk", want: "line1\nline2\nline3"},
+				{edit: ",g/no match/d junk", err: "expected end-of-input"},
 				{edit: ",g/no match/,d", want: "line1\nline2\nline3"},
 				{edit: ",g/line/.d", want: ""},
 				{edit: "2g/line/.d", want: "line1\nline3"},
@@ -733,7 +780,7 @@ func TestEdit(t *testing.T) {
 				{edit: ",v/*", err: "unexpected *"},
 				{edit: ",v/no match/12d", err: "address out of range"},
 				{edit: ",v/no match/d junk", err: "expected end-of-input"},
-				{edit: ",v/line/d junk", want: "line1\nline2\nline3"},
+				{edit: ",v/line/d junk", err: "expected end-of-input"},
 				{edit: ",v/line/,d", want: "line1\nline2\nline3"},
 				{edit: ",v/no match/.d", want: ""},
 				{edit: "2v/no match/.d", want: "line1\nline3"},
@@ -753,11 +800,9 @@ func TestEdit(t *testing.T) {
 				{edit: ",x/line/.+100d", err: "address out of range"},
 				// Trailing newline is OK.
 				{edit: ",x/line/d\n", want: "1\n2\n3"},
-				// Trailing junk is not OK, but we only notice on a match.
+				// Trailing junk is not OK, even if there is no match.
 				{edit: ",x/line/d junk", err: "expected end-of-input"},
-
-				// Here there is no match, so we don't notice.
-				{edit: ",x/NO MATCH/d junk", want: "line1\nline2\nline3"},
+				{edit: ",x/NO MATCH/d junk", err: "expected end-of-input"},
 
 				{edit: ",x/line/,d", err: "out of order"},
 
@@ -768,7 +813,8 @@ func TestEdit(t *testing.T) {
 				{edit: ",x/./.d", want: "\n\n"},
 				{edit: ",x/^.*$/x/[a-z]/c/x", want: "xxxx1\nxxxx2\nxxxx3"},
 				{edit: ",x//c/.", want: ".l.i.n.e.1.\n.l.i.n.e.2.\n.l.i.n.e.3."},
-				{edit: ",x/no match/d junk", want: "line1\nline2\nline3"},
+				{edit: ",x/i*/c/-", want: "-l-n-e-1-\n-l-n-e-2-\n-l-n-e-3-"},
+				{edit: ",x/no match/d junk", err: "expected end-of-input"},
 			},
 		},
 		{
@@ -897,6 +943,24 @@ func TestEdit(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "print address",
+			str:  "line1\nline2\n世界3",
+			dot:  [2]int64{8, 8},
+			cases: []testCase{
+				{edit: "=", print: "^2\n$", want: "line1\nline2\n世界3"},
+				{edit: "=#", print: "^#8\n$", want: "line1\nline2\n世界3"},
+				{edit: "0=", print: "^1\n$", want: "line1\nline2\n世界3"},
+				{edit: "1=", print: "^1\n$", want: "line1\nline2\n世界3"},
+				{edit: "1,2=", print: "^1,2\n$", want: "line1\nline2\n世界3"},
+				{edit: "3=", print: "^3\n$", want: "line1\nline2\n世界3"},
+				{edit: "$=", print: "^3\n$", want: "line1\nline2\n世界3"},
+				{edit: ",=", print: "^1,3\n$", want: "line1\nline2\n世界3"},
+				{edit: "3=#", print: "^#12,#15\n$", want: "line1\nline2\n世界3"},
+				{edit: "$=#", print: "^#15\n$", want: "line1\nline2\n世界3"},
+				{edit: "/界/=#", print: "^#13,#14\n$", want: "line1\nline2\n世界3"},
+			},
+		},
 		{
 			name: "pipe from",
 			str:  "line1\nline2\nline3",
@@ -958,6 +1022,621 @@ func TestEdit(t *testing.T) {
 	}
 }
 
+func TestEditOptions_Marks(t *testing.T) {
+	tests := []struct {
+		edit      string
+		want      string
+		wantMarks Marks
+		err       string // regex
+	}{
+		{edit: "'a d", want: "ac", wantMarks: Marks{'a': {1, 2}, 'z': {3, 3}}},
+		{edit: "'a,'z d", want: "a", wantMarks: Marks{'a': {1, 2}, 'z': {3, 3}}},
+		{edit: "'a,'a+#1 d", want: "a", wantMarks: Marks{'a': {1, 2}, 'z': {3, 3}}},
+		{edit: "#0k a", want: "abc", wantMarks: Marks{'a': {0, 0}, 'z': {3, 3}}},
+		{edit: "k x", want: "abc", wantMarks: Marks{'a': {1, 2}, 'x': {2, 3}, 'z': {3, 3}}},
+		{edit: ",kz", want: "abc", wantMarks: Marks{'a': {1, 2}, 'z': {0, 3}}},
+		{edit: "{\nk x\n'x c/X/\n}", want: "abX", wantMarks: Marks{'a': {1, 2}, 'x': {2, 3}, 'z': {3, 3}}},
+		{edit: "'b d", err: "mark b not set"},
+		{edit: "'1 d", err: "expected mark name"},
+		{edit: "k", err: "expected mark name"},
+		{edit: "k 1", err: "expected mark name"},
+	}
+	for _, test := range tests {
+		marks := Marks{'a': {1, 2}, 'z': {3, 3}}
+		ro := rope.New("abc")
+		switch diffs, _, err := EditOptions([2]int64{2, 3}, test.edit, ioutil.Discard, ro, Options{Marks: marks}); {
+		case test.err == "" && err == nil:
+			if text, _ := diffs.Apply(ro); text.String() != test.want {
+				t.Errorf("EditOptions(%q) buf=%q, want %q", test.edit, text.String(), test.want)
+			}
+			if !reflect.DeepEqual(marks, test.wantMarks) {
+				t.Errorf("EditOptions(%q) marks=%v, want %v", test.edit, marks, test.wantMarks)
+			}
+
+		case test.err == "" && err != nil:
+			t.Errorf("EditOptions(%q)=_,%v, want nil", test.edit, err)
+
+		case test.err != "" && err == nil:
+			t.Errorf("EditOptions(%q)=_,nil, want matching %q", test.edit, test.err)
+
+		default: // test.err != " && err != nil:
+			if !match(test.err, err.Error()) {
+				t.Errorf("EditOptions(%q)=_,%q, want matching %q",
+					test.edit, err.Error(), test.err)
+			}
+		}
+	}
+}
+
+func TestEditNoMarks(t *testing.T) {
+	for _, e := range []string{"'a", "k a"} {
+		_, err := Edit([2]int64{}, e, ioutil.Discard, rope.New(""))
+		if err == nil || !match("no marks", err.Error()) {
+			t.Errorf("Edit(%q)=_,%v, want no marks", e, err)
+		}
+	}
+}
+
+func TestMarks_Update(t *testing.T) {
+	marks := Marks{'a': {1, 2}, 'b': {5, 6}}
+	marks.Update(Diffs{{At: [2]int64{0, 3}, Text: rope.New("xyzxyz")}})
+	want := Marks{'a': {0, 0}, 'b': {8, 9}}
+	if !reflect.DeepEqual(marks, want) {
+		t.Errorf("marks=%v, want %v", marks, want)
+	}
+}
+
+func TestEditFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "edit_test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "in")
+	if err := ioutil.WriteFile(in, []byte("from disk"), 0666); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	out := filepath.Join(dir, "out")
+
+	tests := []struct {
+		edit string
+		// want is the name and text of each file after the edit.
+		// The current file name is prefixed with a .
+		want  []string
+		print string // regex
+		err   string // regex
+	}{
+		{edit: ",d", want: []string{".a=", "b=xyz", "c=abc"}},
+		{edit: "n", print: "^. a\n  b\n  c\n$", want: []string{".a=abc", "b=xyz", "c=abc"}},
+		{edit: "f", print: "^. a\n$", want: []string{".a=abc", "b=xyz", "c=abc"}},
+		{edit: "f z", print: "^. z\n$", want: []string{".z=abc", "b=xyz", "c=abc"}},
+		{edit: "1f", err: "unexpected address"},
+		{edit: "X/./ ,d", want: []string{".a=", "b=", "c="}},
+		{edit: "X/b/ ,d", want: []string{".a=abc", "b=", "c=abc"}},
+		{edit: "Y/b/ ,d", want: []string{".a=", "b=xyz", "c="}},
+		{edit: "X/^\\./ ,d", want: []string{".a=", "b=xyz", "c=abc"}},
+		{edit: "X/[ac]/ ,x/b/c/B/", want: []string{".a=aBc", "b=xyz", "c=aBc"}},
+		{edit: "X/b/ ,p", print: "^xyz$", want: []string{".a=abc", "b=xyz", "c=abc"}},
+		{edit: "X/./ f", print: "^. a\n. b\n. c\n$", want: []string{".a=abc", "b=xyz", "c=abc"}},
+		{edit: "{\nX/a/ 0a/x/\n,d\n}", want: []string{".a=x", "b=xyz", "c=abc"}},
+		{edit: "{\nX/a/ ,d\n0a/x/\n}", err: "out of order"},
+		{edit: "X/z/ ,d", want: []string{".a=abc", "b=xyz", "c=abc"}},
+		{edit: "X/a/ @", err: "bad command"},
+		{edit: "b b", want: []string{"a=abc", ".b=xyz", "c=abc"}},
+		{edit: "b z", err: "no file z"},
+		{edit: "b", err: "expected file name"},
+		{edit: "B d e", want: []string{"a=abc", "b=xyz", "c=abc", "d=", ".e="}},
+		{edit: "B b", want: []string{"a=abc", ".b=xyz", "c=abc"}},
+		{edit: "B", err: "expected file name"},
+		{edit: "D", want: []string{"b=xyz", "c=abc"}},
+		{edit: "D b c", want: []string{".a=abc"}},
+		{edit: "D z", err: "no file z"},
+		{edit: "{\nb b\n,d\n}", want: []string{"a=abc", ".b=", "c=abc"}},
+		{edit: "{\n,d\nb b\n,c/X/\n}", want: []string{"a=", ".b=X", "c=abc"}},
+		{edit: "{\nb b\n,d\nb a\n,c/A/\n}", want: []string{".a=A", "b=", "c=abc"}},
+		{edit: "{\nb c\nb a\n,d\n}", want: []string{".a=", "b=xyz", "c=abc"}},
+		{edit: "{\nB d\n$a/new/\n}", want: []string{"a=abc", "b=xyz", "c=abc", ".d=new"}},
+		{edit: "{\nD\n,d\n}", err: "no current file"},
+		{edit: "{\nD a\n,d\n}", err: "no current file"},
+		{edit: "{\nD b\n,d\n}", want: []string{".a=", "c=abc"}},
+		{edit: "e " + in, want: []string{"." + in + "=from disk", "b=xyz", "c=abc"}},
+		{edit: "e " + filepath.Join(dir, "notfound"), err: "no such file"},
+		{edit: "1e " + in, err: "unexpected address"},
+		{edit: "#1,#2r " + in, want: []string{".a=afrom diskc", "b=xyz", "c=abc"}},
+		{edit: "X/./ $r " + in, want: []string{".a=abcfrom disk", "b=xyzfrom disk", "c=abcfrom disk"}},
+		{edit: "{\nw " + out + "\n$r " + out + "\n}", want: []string{".a=abcabc", "b=xyz", "c=abc"}},
+		{edit: "{\n#1,#2w " + out + "\n$r " + out + "\n}", want: []string{".a=abcb", "b=xyz", "c=abc"}},
+	}
+	for _, test := range tests {
+		fs := &testFiles{files: []*testFile{
+			{name: "a", text: rope.New("abc")},
+			{name: "b", text: rope.New("xyz")},
+			{name: "c", text: rope.New("abc")},
+		}}
+		fs.cur = fs.files[0]
+		var print strings.Builder
+		switch fds, err := EditFiles(fs, test.edit, &print, Options{}); {
+		case test.err == "" && err == nil:
+			for _, fd := range fds {
+				f := fd.File.(*testFile)
+				f.text, _ = fd.Diffs.Apply(f.text)
+			}
+			var got []string
+			for _, f := range fs.files {
+				s := f.name + "=" + f.text.String()
+				if f == fs.cur {
+					s = "." + s
+				}
+				got = append(got, s)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("EditFiles(%q)=%q, want %q", test.edit, got, test.want)
+			}
+			if !match(test.print, print.String()) {
+				t.Errorf("EditFiles(%q) print=%q want matching %q",
+					test.edit, print.String(), test.print)
+			}
+
+		case test.err == "" && err != nil:
+			t.Errorf("EditFiles(%q)=_,%v, want nil", test.edit, err)
+
+		case test.err != "" && err == nil:
+			t.Errorf("EditFiles(%q)=_,nil, want matching %q", test.edit, test.err)
+
+		default: // test.err != " && err != nil:
+			if !match(test.err, err.Error()) {
+				t.Errorf("EditFiles(%q)=_,%q, want matching %q",
+					test.edit, err.Error(), test.err)
+			}
+		}
+	}
+}
+
+func TestEditFiles_Dot(t *testing.T) {
+	tests := []struct {
+		edit string
+		// want is the name and dot of each returned file.
+		want []string
+	}{
+		{edit: "/b/p", want: []string{"a=[1 2]"}},
+		{edit: ",x/b/c/XX/", want: []string{"a=[1 3]"}},
+		{edit: "X/b/ ,x/y/d", want: []string{"b=[1 1]", "a=[0 0]"}},
+		{edit: "X/[ab]/ ,x/./p", want: []string{"a=[2 3]"}},
+		{edit: "{\n,x/b/c/B/\nb b\n/z/p\n}", want: []string{"a=[1 2]", "b=[2 3]"}},
+		{edit: "{\nb b\n#1,#2d\nb a\n/c/p\n}", want: []string{"b=[1 1]", "a=[2 3]"}},
+	}
+	for _, test := range tests {
+		fs := &testFiles{files: []*testFile{
+			{name: "a", text: rope.New("abc")},
+			{name: "b", text: rope.New("xyz")},
+			{name: "c", text: rope.New("abc")},
+		}}
+		fs.cur = fs.files[0]
+		fds, err := EditFiles(fs, test.edit, ioutil.Discard, Options{})
+		if err != nil {
+			t.Errorf("EditFiles(%q)=_,%v, want nil", test.edit, err)
+			continue
+		}
+		var got []string
+		for _, fd := range fds {
+			got = append(got, fmt.Sprintf("%s=%v", fd.File.Name(), fd.Dot))
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("EditFiles(%q) dots=%q, want %q", test.edit, got, test.want)
+		}
+	}
+}
+
+func TestEditNoFiles(t *testing.T) {
+	for _, e := range []string{"X/./ p", "Y/./ p", "b a", "B a", "D", "e", "f", "n", "r", "w"} {
+		if _, err := Edit([2]int64{}, e, ioutil.Discard, rope.New("")); err == nil {
+			t.Errorf("Edit(%q)=_,nil, want error", e)
+		}
+	}
+}
+
+func TestEditError(t *testing.T) {
+	tests := []struct {
+		edit string
+		kind ErrorKind
+		pos  int
+	}{
+		{edit: "1,2@", kind: Syntax, pos: 3},
+		{edit: "d junk", kind: Syntax, pos: 2},
+		{edit: "1 /a(b/", kind: Syntax, pos: 4},
+		{edit: "x/a/ s/[b/c/", kind: Syntax, pos: 7},
+		{edit: "{\n1d\n2 @\n}", kind: Syntax, pos: 7},
+		{edit: "{\n1d\n", kind: Syntax, pos: 0},
+		{edit: "1X/a/ p", kind: Syntax, pos: 0},
+		{edit: "k 1", kind: Syntax, pos: 2},
+		{edit: `1s/(a)bc/x\{y}/`, kind: Syntax, pos: 10},
+		{edit: "1,/xyz/d", kind: NoMatch, pos: 2},
+		{edit: ",s/xyz/abc/", kind: NoMatch, pos: 0},
+		{edit: ",x/a/ 1,100d", kind: BadAddr, pos: 8},
+		{edit: "#5,#1p", kind: BadAddr, pos: 0},
+		{edit: " #100", kind: BadAddr, pos: 1},
+		{edit: "{\n2d\n1d\n}", kind: OutOfOrder, pos: 0},
+		{edit: "'a", kind: NoMark, pos: 0},
+		{edit: "b x", kind: NoFile, pos: 0},
+		{edit: "< exit 1", kind: Shell, pos: 0},
+	}
+	for _, test := range tests {
+		_, err := Edit([2]int64{}, test.edit, ioutil.Discard, rope.New("abc\nabc\n"))
+		e, ok := err.(Error)
+		if !ok {
+			t.Errorf("Edit(%q)=_,%#v, want an Error", test.edit, err)
+			continue
+		}
+		if e.Kind != test.kind || e.Pos != test.pos {
+			t.Errorf("Edit(%q)=_,{Kind: %d, Pos: %d}, want {Kind: %d, Pos: %d}",
+				test.edit, e.Kind, e.Pos, test.kind, test.pos)
+		}
+	}
+}
+
+func TestPipeDiffs(t *testing.T) {
+	ds, err := Edit([2]int64{}, ",| sed 's/2/X/'", ioutil.Discard, rope.New("line1\nline2\nline3"))
+	if err != nil {
+		t.Fatalf("Edit(...)=_,%v, want nil", err)
+	}
+	if len(ds) != 1 || ds[0].At != [2]int64{10, 11} || ds[0].Text.String() != "X" {
+		t.Errorf("Edit(...)=%v, want [{[10 11] X}]", ds)
+	}
+}
+
+func TestEditFiles_Options(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		edit string
+		opts Options
+		kind ErrorKind
+	}{
+		{edit: "X/b/ ,| cat", opts: Options{NoShell: true}, kind: Shell},
+		{edit: "X/b/ ,s/x/y/", opts: Options{Context: canceled}, kind: Aborted},
+	}
+	for _, test := range tests {
+		fs := &testFiles{files: []*testFile{
+			{name: "a", text: rope.New("abc")},
+			{name: "b", text: rope.New("xyz")},
+		}}
+		fs.cur = fs.files[0]
+		_, err := EditFiles(fs, test.edit, ioutil.Discard, test.opts)
+		if e, ok := err.(Error); !ok || e.Kind != test.kind {
+			t.Errorf("EditFiles(%q)=_,%#v, want Kind: %d", test.edit, err, test.kind)
+		}
+	}
+	fs := &testFiles{files: []*testFile{{name: "a", text: rope.New("abc")}}}
+	fs.cur = fs.files[0]
+	var r testRunner
+	if _, err := EditFiles(fs, ",| upper", ioutil.Discard, Options{Runner: &r}); err != nil || r.stdin != "abc" {
+		t.Errorf("EditFiles(\",| upper\")=_,%v and ran with stdin %q, want nil and %q", err, r.stdin, "abc")
+	}
+}
+
+func TestEditOptions_Dot(t *testing.T) {
+	tests := []struct {
+		text, edit string
+		want       [2]int64
+	}{
+		{text: "abc\ndef\n", edit: "2p", want: [2]int64{4, 8}},
+		{text: "abcabc", edit: "/c/=", want: [2]int64{2, 3}},
+		{text: "abcabc", edit: ",x/b/p", want: [2]int64{4, 5}},
+		{text: "abcabc", edit: "#1,#2d", want: [2]int64{1, 1}},
+		{text: "abcabc", edit: ",x/b/d", want: [2]int64{3, 3}},
+		{text: "abcabc", edit: ",x/b/c/XX/", want: [2]int64{5, 7}},
+		{text: "abc\ndef\n", edit: "1c/X/", want: [2]int64{0, 1}},
+		{text: "abc", edit: "#1a/X/", want: [2]int64{1, 2}},
+		{text: "abc", edit: "#1i/X/", want: [2]int64{1, 2}},
+		{text: "abc", edit: "#0,#1m$", want: [2]int64{2, 3}},
+		{text: "abc", edit: "#2,#3m0", want: [2]int64{0, 1}},
+		{text: "abc", edit: "#0,#1t$", want: [2]int64{3, 4}},
+		{text: "abcabc", edit: ",s/b/XX/g", want: [2]int64{0, 8}},
+		{text: "abc", edit: "{\n#0i/X/\n$a/Y/\n}", want: [2]int64{4, 5}},
+	}
+	for _, test := range tests {
+		_, dot, err := EditOptions([2]int64{}, test.edit, ioutil.Discard, rope.New(test.text), Options{})
+		if err != nil || dot != test.want {
+			t.Errorf("EditOptions(%q) on %q=_,%v,%v, want %v,nil", test.edit, test.text, dot, err, test.want)
+		}
+	}
+}
+
+func TestEditOptions_Runner(t *testing.T) {
+	tests := []struct {
+		edit, text string
+		want       string
+		// cmd and stdin are what the Runner is passed;
+		// stdin is "<nil>" if the Runner is passed a nil stdin.
+		cmd, stdin string
+		print      string
+	}{
+		{
+			edit:  "2| upper",
+			text:  "abc\ndef\nghi\n",
+			want:  "abc\nDEF\nghi\n",
+			cmd:   "upper",
+			stdin: "def\n",
+		},
+		{
+			edit:  "2< upper",
+			text:  "abc\ndef\nghi\n",
+			want:  "abc\nghi\n",
+			cmd:   "upper",
+			stdin: "<nil>",
+		},
+		{
+			edit:  "2> upper",
+			text:  "abc\ndef\nghi\n",
+			want:  "abc\ndef\nghi\n",
+			cmd:   "upper",
+			stdin: "def\n",
+			print: "DEF\n",
+		},
+	}
+	for _, test := range tests {
+		var r testRunner
+		var print strings.Builder
+		ro := rope.New(test.text)
+		ds, _, err := EditOptions([2]int64{}, test.edit, &print, ro, Options{Runner: &r})
+		if err != nil {
+			t.Errorf("EditOptions(%q)=_,%v, want nil", test.edit, err)
+			continue
+		}
+		if got, _ := ds.Apply(ro); got.String() != test.want {
+			t.Errorf("EditOptions(%q) applied %q, want %q", test.edit, got.String(), test.want)
+		}
+		if r.cmd != test.cmd || r.stdin != test.stdin || print.String() != test.print {
+			t.Errorf("EditOptions(%q) ran %q with stdin %q and printed %q, want %q with %q and %q",
+				test.edit, r.cmd, r.stdin, print.String(), test.cmd, test.stdin, test.print)
+		}
+	}
+}
+
+// testRunner is a Runner that records the command and its input,
+// and writes the input, if any, converted to upper case.
+type testRunner struct {
+	cmd, stdin string
+}
+
+func (r *testRunner) Run(_ context.Context, cmd string, stdin io.Reader, stdout, _ io.Writer) error {
+	r.cmd, r.stdin = cmd, "<nil>"
+	if stdin == nil {
+		return nil
+	}
+	b, err := ioutil.ReadAll(stdin)
+	if err != nil {
+		return err
+	}
+	r.stdin = string(b)
+	_, err = io.WriteString(stdout, strings.ToUpper(r.stdin))
+	return err
+}
+
+func TestEditOptions_ShellContext(t *testing.T) {
+	done, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		opts Options
+		want error
+	}{
+		{opts: Options{Context: done}, want: context.Canceled},
+		{opts: Options{Context: done, ShellContext: context.Background()}, want: nil},
+	}
+	for _, test := range tests {
+		var got error
+		test.opts.Runner = runnerFunc(func(ctx context.Context) { got = ctx.Err() })
+		if _, _, err := EditOptions([2]int64{}, "< echo", ioutil.Discard, rope.New("abc"), test.opts); err != nil {
+			t.Errorf("EditOptions(...)=_,%v, want nil", err)
+		}
+		if got != test.want {
+			t.Errorf("EditOptions(...) ran with ctx.Err()=%v, want %v", got, test.want)
+		}
+	}
+}
+
+// runnerFunc is a Runner that calls the function with the context
+// and runs nothing.
+type runnerFunc func(context.Context)
+
+func (f runnerFunc) Run(ctx context.Context, _ string, _ io.Reader, _, _ io.Writer) error {
+	f(ctx)
+	return nil
+}
+
+func TestEditOptions_NoShell(t *testing.T) {
+	for _, edit := range []string{"| cat", "< echo", "> cat", ",x/b/ | cat"} {
+		_, _, err := EditOptions([2]int64{}, edit, ioutil.Discard, rope.New("abc"), Options{NoShell: true})
+		if e, ok := err.(Error); !ok || e.Kind != Shell || e.Err != ErrNoShell {
+			t.Errorf("EditOptions(%q) with NoShell=_,%#v, want {Kind: %d, Err: %v}",
+				edit, err, Shell, ErrNoShell)
+		}
+	}
+}
+
+func TestEditOptions_NoFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "edit_test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(name, []byte("secret"), 0666); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	opts := Options{NoFiles: true}
+	for _, edit := range []string{"r " + name, ",w " + name, "w " + filepath.Join(dir, "new")} {
+		_, _, err := EditOptions([2]int64{}, edit, ioutil.Discard, rope.New("abc"), opts)
+		if e, ok := err.(Error); !ok || e.Kind != IO || e.Err != ErrNoFiles {
+			t.Errorf("EditOptions(%q) with NoFiles=_,_,%#v, want {Kind: %d, Err: %v}",
+				edit, err, IO, ErrNoFiles)
+		}
+	}
+	fs := &testFiles{files: []*testFile{{name: "a", text: rope.New("abc")}}}
+	fs.cur = fs.files[0]
+	_, err = EditFiles(fs, "e "+name, ioutil.Discard, opts)
+	if e, ok := err.(Error); !ok || e.Kind != IO || e.Err != ErrNoFiles {
+		t.Errorf("EditFiles(\"e ...\") with NoFiles=_,%#v, want {Kind: %d, Err: %v}", err, IO, ErrNoFiles)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new")); !os.IsNotExist(err) {
+		t.Errorf("w with NoFiles created a file")
+	}
+	if b, err := ioutil.ReadFile(name); err != nil || string(b) != "secret" {
+		t.Errorf("w with NoFiles changed the file to %q, %v", b, err)
+	}
+}
+
+func TestEditOptions_fileDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "edit_test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "in"), []byte("from disk"), 0666); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	opts := Options{Runner: ExecRunner{Dir: dir}}
+	ro := rope.New("abc")
+	ds, _, err := EditOptions([2]int64{}, "{\n,w out\n,r in\n}", ioutil.Discard, ro, opts)
+	if err != nil {
+		t.Fatalf("EditOptions(...)=_,_,%v, want nil", err)
+	}
+	if got, _ := ds.Apply(ro); got.String() != "from disk" {
+		t.Errorf("EditOptions(...) applied %q, want %q", got.String(), "from disk")
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "out")); err != nil || string(b) != "abc" {
+		t.Errorf("w out wrote %q, %v, want %q", b, err, "abc")
+	}
+}
+
+func TestExecRunner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "edit_test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatalf("failed to evaluate symlinks: %v", err)
+	}
+
+	r := ExecRunner{Dir: dir, Env: []string{"EDIT_TEST=xyz"}}
+	opts := Options{Runner: r}
+	ds, _, err := EditOptions([2]int64{}, `< echo "$(pwd) $EDIT_TEST"`, ioutil.Discard, rope.Empty(), opts)
+	if err != nil {
+		t.Fatalf("EditOptions(...)=_,%v, want nil", err)
+	}
+	want := dir + " xyz\n"
+	if got, _ := ds.Apply(rope.Empty()); got.String() != want {
+		t.Errorf("EditOptions(...) applied %q, want %q", got.String(), want)
+	}
+
+	echo := Options{Runner: ExecRunner{Shell: "echo"}}
+	ds, _, err = EditOptions([2]int64{}, "< cmd", ioutil.Discard, rope.Empty(), echo)
+	if err != nil {
+		t.Fatalf("EditOptions(...) with Shell=_,%v, want nil", err)
+	}
+	if got, _ := ds.Apply(rope.Empty()); got.String() != "-c cmd\n" {
+		t.Errorf("EditOptions(...) with Shell applied %q, want %q", got.String(), "-c cmd\n")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	opts.Context = ctx
+	if _, _, err := EditOptions([2]int64{}, "< echo", ioutil.Discard, rope.Empty(), opts); err == nil {
+		t.Errorf("EditOptions(...) canceled=_,nil, want an error")
+	}
+}
+
+func TestEditOptions_Context(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		edit string
+		pos  int
+	}{
+		{edit: "/abc/d", pos: 0},
+		{edit: "1,-/abc/d", pos: 3},
+		{edit: ",s/abc/xyz/g", pos: 0},
+		{edit: ",x/abc/d", pos: 0},
+		{edit: ",g/abc/d", pos: 0},
+	}
+	for _, test := range tests {
+		ro := rope.New("abc\nabc\n")
+		if _, _, err := EditOptions([2]int64{}, test.edit, ioutil.Discard, ro, Options{}); err != nil {
+			t.Errorf("EditOptions(%q)=_,_,%v, want nil", test.edit, err)
+		}
+		_, _, err := EditOptions([2]int64{}, test.edit, ioutil.Discard, ro, Options{Context: ctx})
+		e, ok := err.(Error)
+		if !ok || e.Kind != Aborted || e.Pos != test.pos || e.Err != re1.ErrAborted {
+			t.Errorf("EditOptions(%q) canceled=_,_,%#v, want {Kind: %d, Pos: %d, Err: %v}",
+				test.edit, err, Aborted, test.pos, re1.ErrAborted)
+		}
+	}
+}
+
+type testFiles struct {
+	files []*testFile
+	cur   *testFile
+}
+
+type testFile struct {
+	name  string
+	text  rope.Rope
+	dot   [2]int64
+	marks Marks
+}
+
+func (f *testFile) Name() string    { return f.name }
+func (f *testFile) Text() rope.Rope { return f.text }
+func (f *testFile) Dot() [2]int64   { return f.dot }
+func (f *testFile) Marks() Marks    { return f.marks }
+
+func (fs *testFiles) Files() []File {
+	var files []File
+	for _, f := range fs.files {
+		files = append(files, f)
+	}
+	return files
+}
+
+func (fs *testFiles) Current() File {
+	if fs.cur == nil {
+		return nil
+	}
+	return fs.cur
+}
+
+func (fs *testFiles) SetCurrent(f File) { fs.cur = f.(*testFile) }
+
+func (fs *testFiles) Open(name string) (File, error) {
+	for _, f := range fs.files {
+		if f.name == name {
+			return f, nil
+		}
+	}
+	f := &testFile{name: name, text: rope.Empty()}
+	fs.files = append(fs.files, f)
+	return f, nil
+}
+
+func (fs *testFiles) Close(f File) error {
+	for i := range fs.files {
+		if fs.files[i] == f {
+			fs.files = append(fs.files[:i], fs.files[i+1:]...)
+			break
+		}
+	}
+	if fs.cur == f {
+		fs.cur = nil
+	}
+	return nil
+}
+
+func (fs *testFiles) Rename(f File, name string) error {
+	f.(*testFile).name = name
+	return nil
+}
+
 type test struct {
 	name  string
 	str   string
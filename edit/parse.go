@@ -0,0 +1,810 @@
+package edit
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/eaburns/T/re1"
+)
+
+// A Program is a parsed edit.
+type Program struct {
+	// Cmd is the command of the program.
+	Cmd Command
+}
+
+// A Node is an address or a command of a Program.
+type Node interface {
+	// Span returns the byte offsets in the edit source
+	// of the start and end of the node.
+	Span() [2]int
+}
+
+type node struct{ span [2]int }
+
+func (n node) Span() [2]int { return n.span }
+
+// An Address is a parsed address.
+//
+// It is one of *RangeAddr, *RelAddr, *DotAddr, *EndAddr,
+// *MarkAddr, *RuneAddr, *LineAddr, or *RegexpAddr.
+type Address interface {
+	Node
+	isAddress()
+}
+
+func (node) isAddress() {}
+
+// A RangeAddr is an address of the form [a2],[a3] or [a2];[a3].
+type RangeAddr struct {
+	node
+	// Left is the address to the left of the operator, or nil if absent.
+	Left Address
+	// Op is the operator: , or ;.
+	Op rune
+	// Right is the address to the right of the operator, or nil if absent.
+	Right Address
+}
+
+// A RelAddr is an address of the form [a1]+[a2] or [a1]-[a2].
+type RelAddr struct {
+	node
+	// Left is the address to the left of the operator, or nil if absent.
+	Left Address
+	// Op is the operator: + or -.
+	// Op is + if the operator was inserted between two simple addresses.
+	Op rune
+	// Right is the simple address to the right of the operator, or nil if absent.
+	Right Address
+}
+
+// A DotAddr is the address . .
+type DotAddr struct{ node }
+
+// An EndAddr is the address $.
+type EndAddr struct{ node }
+
+// A MarkAddr is a mark address, 'x.
+type MarkAddr struct {
+	node
+	// Name is the name of the mark.
+	Name rune
+}
+
+// A RuneAddr is a rune address, #n.
+type RuneAddr struct {
+	node
+	// N is the number of runes.
+	N int
+	// Reverse is whether the address is evaluated in reverse.
+	Reverse bool
+}
+
+// A LineAddr is a line address, n.
+type LineAddr struct {
+	node
+	// N is the number of lines.
+	N int
+	// Reverse is whether the address is evaluated in reverse.
+	Reverse bool
+}
+
+// A RegexpAddr is a regular expression address, /regexp/ or ?regexp?.
+type RegexpAddr struct {
+	node
+	// Regexp is the regular expression.
+	// It was compiled with the re1.Opts.Reverse option set to Reverse.
+	Regexp *re1.Regexp
+	// Reverse is whether the address is evaluated in reverse.
+	Reverse bool
+}
+
+// A Command is a parsed command.
+//
+// It is one of *AddrCmd, *ChangeCmd, *MoveCmd, *CopyCmd,
+// *PrintCmd, *PrintAddrCmd, *MarkCmd, *SubCmd,
+// *CondCmd, *LoopCmd, *SeqCmd, *PipeCmd,
+// *FileLoopCmd, *FileSetCmd, or *FileCmd.
+type Command interface {
+	Node
+	// Address returns the address of the command
+	// or nil if the address was elided.
+	Address() Address
+	base() *cmd
+}
+
+type cmd struct {
+	node
+	// Addr is the address of the command
+	// or nil if the address was elided.
+	Addr Address
+}
+
+func (c *cmd) Address() Address { return c.Addr }
+func (c *cmd) base() *cmd       { return c }
+
+// An AddrCmd is an address with no command.
+type AddrCmd struct{ cmd }
+
+// A ChangeCmd is an a, c, d, or i command.
+type ChangeCmd struct {
+	cmd
+	// Op is the command: a, c, d, or i.
+	Op rune
+	// Text is the text of the change with all escapes interpreted.
+	Text string
+}
+
+// A MoveCmd is an m command.
+type MoveCmd struct {
+	cmd
+	// Dest is the destination address.
+	Dest Address
+}
+
+// A CopyCmd is a t command.
+type CopyCmd struct {
+	cmd
+	// Dest is the destination address.
+	Dest Address
+}
+
+// A PrintCmd is a p command.
+type PrintCmd struct{ cmd }
+
+// A PrintAddrCmd is an = command.
+type PrintAddrCmd struct {
+	cmd
+	// Runes is whether to print rune offsets (=#)
+	// instead of line numbers (=).
+	Runes bool
+}
+
+// A MarkCmd is a k command.
+type MarkCmd struct {
+	cmd
+	// Name is the name of the mark.
+	Name rune
+}
+
+// A SubCmd is an s command.
+type SubCmd struct {
+	cmd
+	// N is the number of the first match substituted.
+	N int
+	// Regexp is the regular expression.
+	Regexp *re1.Regexp
+	// Template is the substitution text
+	// with escapes not yet interpreted.
+	Template string
+	// Delim is the delimiter of the Regexp and Template.
+	Delim rune
+	// Global is whether all matches after the Nth are substituted.
+	Global bool
+}
+
+// A CondCmd is a g or v command.
+type CondCmd struct {
+	cmd
+	// Op is the command: g or v.
+	Op rune
+	// Regexp is the regular expression.
+	Regexp *re1.Regexp
+	// Cmd is the conditionally executed command.
+	Cmd Command
+}
+
+// A LoopCmd is an x or y command.
+type LoopCmd struct {
+	cmd
+	// Op is the command: x or y.
+	Op rune
+	// Regexp is the regular expression.
+	Regexp *re1.Regexp
+	// Cmd is the command executed for each match.
+	Cmd Command
+}
+
+// A SeqCmd is a { command.
+type SeqCmd struct {
+	cmd
+	// Cmds are the commands of the sequence.
+	Cmds []Command
+}
+
+// A PipeCmd is a |, <, or > command.
+type PipeCmd struct {
+	cmd
+	// Op is the command: |, <, or >.
+	Op rune
+	// Shell is the shell command.
+	Shell string
+}
+
+// A FileLoopCmd is an X or Y command.
+type FileLoopCmd struct {
+	cmd
+	// Op is the command: X or Y.
+	Op rune
+	// Regexp is the regular expression.
+	Regexp *re1.Regexp
+	// Cmd is the command executed for each file.
+	Cmd Command
+}
+
+// A FileSetCmd is a b, B, D, or n command.
+type FileSetCmd struct {
+	cmd
+	// Op is the command: b, B, D, or n.
+	Op rune
+	// Names are the file names.
+	Names []string
+}
+
+// A FileCmd is an e, f, r, or w command.
+type FileCmd struct {
+	cmd
+	// Op is the command: e, f, r, or w.
+	Op rune
+	// Name is the file name, or the empty string if absent.
+	Name string
+}
+
+// Parse parses an edit.
+// The returned Program can be executed any number of times.
+func Parse(t string) (*Program, error) {
+	p := &parser{src: t}
+	switch c, t, err := parseCmd(p, t); {
+	case err != nil:
+		return nil, err
+	case strings.TrimSpace(t) != "":
+		return nil, syntaxError(p, trimSpaceLeft(t), "expected end-of-input")
+	default:
+		return &Program{Cmd: c}, nil
+	}
+}
+
+type parser struct {
+	// src is the source text being parsed.
+	// Every string parsed is a suffix of src.
+	src string
+}
+
+// pos returns the byte offset into the source of the start of t.
+func pos(p *parser, t string) int { return len(p.src) - len(t) }
+
+func span(p *parser, t0, t string) node {
+	return node{span: [2]int{pos(p, trimSpaceLeft(t0)), pos(p, t)}}
+}
+
+// syntaxError returns a Syntax Error at the start of t.
+func syntaxError(p *parser, t, msg string) error {
+	return Error{Kind: Syntax, Pos: pos(p, t), msg: msg}
+}
+
+// wrapSyntaxError returns err as a Syntax Error in the text beginning at t.
+// If err is an re1.Error, its position is relative to the start of t.
+func wrapSyntaxError(p *parser, t string, err error) error {
+	at := pos(p, t)
+	if e, ok := err.(re1.Error); ok {
+		at += e.Pos
+	}
+	return Error{Kind: Syntax, Pos: at, Err: err, msg: err.Error()}
+}
+
+func parseCmd(p *parser, t0 string) (Command, string, error) {
+	a, t, err := parseAddr(p, t0)
+	if err != nil {
+		return nil, "", err
+	}
+	var c Command
+	t = trimSpaceLeft(t)
+	switch r, t1 := next(t); r {
+	default:
+		return nil, "", syntaxError(p, t, "bad command "+string([]rune{r}))
+	case eof, '\n':
+		c, t = &AddrCmd{}, t1
+	case 'a', 'c', 'd', 'i':
+		c, t = parseChange(r, t1)
+	case 'm', 't':
+		c, t, err = parseMoveCopy(p, r, t1)
+	case 'p':
+		c, t = &PrintCmd{}, t1
+	case '=':
+		c, t = parsePrintAddr(t1)
+	case 'k':
+		c, t, err = parseMark(p, t1)
+	case 's':
+		c, t, err = parseSub(p, t1)
+	case 'g', 'v':
+		c, t, err = parseCond(p, r, t1)
+	case 'x', 'y':
+		c, t, err = parseLoop(p, r, t1)
+	case '{':
+		c, t, err = parseSeq(p, t1)
+	case '<', '>', '|':
+		c, t, err = parsePipe(p, r, t1)
+	case 'X', 'Y':
+		c, t, err = parseFileLoop(p, r, t1)
+	case 'b', 'B', 'D', 'n':
+		c, t, err = parseFileSet(p, r, t1)
+	case 'e', 'f', 'r', 'w':
+		c, t = parseFile(r, t1)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	switch c.(type) {
+	case *FileLoopCmd, *FileSetCmd:
+		if a != nil {
+			return nil, "", errorAt(Syntax, a, "unexpected address")
+		}
+	case *FileCmd:
+		if op := c.(*FileCmd).Op; a != nil && (op == 'e' || op == 'f') {
+			return nil, "", errorAt(Syntax, a, "unexpected address")
+		}
+	}
+	b := c.base()
+	b.node, b.Addr = span(p, t0, t), a
+	return c, t, nil
+}
+
+// parseLine parses a command that spans the rest of the line.
+func parseLine(p *parser, t string) (Command, string, error) {
+	line, rest := splitNewline(t)
+	q := &parser{src: p.src[:pos(p, t)+len(line)]}
+	switch c, t, err := parseCmd(q, line); {
+	case err != nil:
+		return nil, "", err
+	case strings.TrimSpace(t) != "":
+		return nil, "", syntaxError(q, trimSpaceLeft(t), "expected end-of-input")
+	default:
+		return c, rest, nil
+	}
+}
+
+func parseChange(op rune, t string) (Command, string) {
+	var text string
+	if op != 'd' {
+		text, t = parseText(t)
+	}
+	return &ChangeCmd{Op: op, Text: text}, t
+}
+
+func parseText(t0 string) (text, t string) {
+	t = strings.TrimLeftFunc(t0, func(r rune) bool {
+		return unicode.IsSpace(r) && r != '\n'
+	})
+	switch {
+	case len(t) == 0:
+		return "", ""
+	case t[0] == '\n':
+		return parseLines(t[1:])
+	default:
+		delim, w := utf8.DecodeRuneInString(t)
+		return parseDelimited(t[w:], delim)
+	}
+}
+
+func parseLines(t string) (string, string) {
+	var i int
+	nl := true
+	var s strings.Builder
+	for {
+		var r rune
+		switch r, t = next(t); {
+		case r == eof:
+			if nl && i > 0 {
+				s.WriteRune('\n')
+			}
+			return s.String(), ""
+		case nl && r == '.' && len(t) == 0:
+			return s.String(), t
+		case nl && r == '.' && t[0] == '\n':
+			return s.String(), t[1:]
+		default:
+			if nl && i > 0 {
+				s.WriteRune('\n')
+			}
+			i++
+			if r == '\n' {
+				nl = true
+			} else {
+				s.WriteRune(r)
+				nl = false
+			}
+		}
+	}
+}
+
+func parseDelimited(t string, delim rune) (string, string) {
+	var s strings.Builder
+	for {
+		var r rune
+		switch r, t = next(t); {
+		case r == eof || r == '\n' || r == delim:
+			return s.String(), t
+		case r == '\\':
+			r, t = next(t)
+			s.WriteRune(esc(r))
+		default:
+			s.WriteRune(r)
+		}
+	}
+}
+
+// expandTemplate returns the substitution text of an s command template
+// for a match of the regexp, where group returns the text of a capture group.
+// On error, expandTemplate also returns the template text
+// beginning at the malformed escape.
+func expandTemplate(t string, re *re1.Regexp, group func(int) string) (string, string, error) {
+	var s strings.Builder
+	var conv func(string) string
+	write := func(str string) {
+		if conv != nil {
+			str = conv(str)
+		}
+		s.WriteString(str)
+	}
+	for {
+		t0 := t
+		var r rune
+		switch r, t = next(t); {
+		case r == eof:
+			return s.String(), "", nil
+		case r != '\\':
+			write(string(r))
+			continue
+		}
+		switch r, t1 := next(t); {
+		case r == 'U':
+			conv, t = strings.ToUpper, t1
+		case r == 'L':
+			conv, t = strings.ToLower, t1
+		case r == 'E':
+			conv, t = nil, t1
+		case r == '{':
+			i := strings.IndexRune(t1, '}')
+			if i < 0 {
+				return "", t0, errors.New("unclosed \\{")
+			}
+			n := groupIndex(re, t1[:i])
+			if n < 0 {
+				return "", t0, errors.New("unknown group " + t1[:i])
+			}
+			write(group(n))
+			t = t1[i+1:]
+		case '0' <= r && r <= '9':
+			n := int(r - '0')
+			t = t1
+			// Two digits are a group number only if there is such a group,
+			// so \10 is otherwise group 1 followed by 0.
+			if r, t2 := next(t1); '0' <= r && r <= '9' && n*10+int(r-'0') < re.NumCap() {
+				n, t = n*10+int(r-'0'), t2
+			}
+			write(group(n))
+		default:
+			r, t = next(t)
+			write(string(esc(r)))
+		}
+	}
+}
+
+// groupIndex returns the number of the capture group
+// with the given name or decimal number, or -1 if there is none.
+func groupIndex(re *re1.Regexp, name string) int {
+	if n, err := strconv.Atoi(name); err == nil && name[0] != '-' && name[0] != '+' {
+		if n >= re.NumCap() {
+			return -1
+		}
+		return n
+	}
+	return re.SubexpIndex(name)
+}
+
+func esc(r rune) rune {
+	switch r {
+	case eof:
+		return '\\'
+	case 'n', '\n':
+		return '\n'
+	case 't':
+		return '\t'
+	default:
+		return r
+	}
+}
+
+func parseMoveCopy(p *parser, op rune, t string) (Command, string, error) {
+	dest, t, err := parseAddr(p, t)
+	switch {
+	case err != nil:
+		return nil, "", err
+	case dest == nil:
+		return nil, "", syntaxError(p, trimSpaceLeft(t), "expected address")
+	case op == 'm':
+		return &MoveCmd{Dest: dest}, t, nil
+	default:
+		return &CopyCmd{Dest: dest}, t, nil
+	}
+}
+
+func parsePrintAddr(t string) (Command, string) {
+	if r, t1 := next(t); r == '#' {
+		return &PrintAddrCmd{Runes: true}, t1
+	}
+	return &PrintAddrCmd{}, t
+}
+
+func parseMark(p *parser, t string) (Command, string, error) {
+	t = trimSpaceLeft(t)
+	r, t1 := next(t)
+	if !unicode.IsLetter(r) {
+		return nil, "", syntaxError(p, t, "expected mark name")
+	}
+	t = t1
+	return &MarkCmd{Name: r}, t, nil
+}
+
+func parseSub(p *parser, t string) (Command, string, error) {
+	n, t, _ := number(trimSpaceLeft(t))
+	delim, _ := next(trimSpaceLeft(t))
+	re, t, err := parseRegexp(p, t)
+	if err != nil {
+		return nil, "", err
+	}
+	t0 := t
+	tmpl, t := splitDelimited(t, delim)
+	if _, rest, err := expandTemplate(tmpl, re, func(int) string { return "" }); err != nil {
+		return nil, "", syntaxError(p, t0[len(tmpl)-len(rest):], err.Error())
+	}
+	var global bool
+	if r, t1 := next(trimSpaceLeft(t)); r == 'g' {
+		t = t1
+		global = true
+	}
+	c := &SubCmd{N: n, Regexp: re, Template: tmpl, Delim: delim, Global: global}
+	return c, t, nil
+}
+
+// splitDelimited returns the un-interpreted text
+// up to an un-escaped delimiter or newline,
+// and the text following the delimiter or newline.
+func splitDelimited(t string, delim rune) (string, string) {
+	var esc bool
+	for i, r := range t {
+		switch {
+		case esc:
+			esc = false
+		case r == '\\':
+			esc = true
+		case r == '\n' || r == delim:
+			return t[:i], t[i+utf8.RuneLen(r):]
+		}
+	}
+	return t, ""
+}
+
+func parseCond(p *parser, op rune, t string) (Command, string, error) {
+	re, t, err := parseRegexp(p, t)
+	if err != nil {
+		return nil, "", err
+	}
+	c, t, err := parseLine(p, t)
+	if err != nil {
+		return nil, "", err
+	}
+	return &CondCmd{Op: op, Regexp: re, Cmd: c}, t, nil
+}
+
+func parseLoop(p *parser, op rune, t string) (Command, string, error) {
+	re, t, err := parseRegexp(p, t)
+	if err != nil {
+		return nil, "", err
+	}
+	c, t, err := parseLine(p, t)
+	if err != nil {
+		return nil, "", err
+	}
+	return &LoopCmd{Op: op, Regexp: re, Cmd: c}, t, nil
+}
+
+func parseSeq(p *parser, t string) (Command, string, error) {
+	open := pos(p, t) - 1 // the {
+	var cmds []Command
+	for {
+		t = trimSpaceLeft(t)
+		if r, t1 := next(t); r == '}' {
+			return &SeqCmd{Cmds: cmds}, t1, nil
+		} else if r == eof {
+			return nil, "", Error{Kind: Syntax, Pos: open, msg: "unclosed {"}
+		}
+		var c Command
+		var err error
+		if c, t, err = parseLine(p, t); err != nil {
+			return nil, "", err
+		}
+		cmds = append(cmds, c)
+	}
+}
+
+func parsePipe(p *parser, op rune, t0 string) (Command, string, error) {
+	arg, t := splitNewline(t0)
+	if arg = strings.TrimSpace(arg); arg == "" {
+		return nil, "", syntaxError(p, t0, "expected command")
+	}
+	return &PipeCmd{Op: op, Shell: arg}, t, nil
+}
+
+func parseFileLoop(p *parser, op rune, t string) (Command, string, error) {
+	re, t, err := parseRegexp(p, t)
+	if err != nil {
+		return nil, "", err
+	}
+	c, t, err := parseLine(p, t)
+	if err != nil {
+		return nil, "", err
+	}
+	return &FileLoopCmd{Op: op, Regexp: re, Cmd: c}, t, nil
+}
+
+func parseFileSet(p *parser, op rune, t0 string) (Command, string, error) {
+	arg, t := splitNewline(t0)
+	names := strings.Fields(arg)
+	switch {
+	case op == 'b' && len(names) != 1:
+		return nil, "", syntaxError(p, t0, "expected file name")
+	case op == 'B' && len(names) == 0:
+		return nil, "", syntaxError(p, t0, "expected file name")
+	}
+	return &FileSetCmd{Op: op, Names: names}, t, nil
+}
+
+func parseFile(op rune, t string) (Command, string) {
+	name, t := splitNewline(t)
+	return &FileCmd{Op: op, Name: strings.TrimSpace(name)}, t
+}
+
+func splitNewline(str string) (string, string) {
+	i := strings.IndexRune(str, '\n')
+	if i < 0 {
+		return str, ""
+	}
+	return str[:i+1], str[i+1:]
+}
+
+func parseRegexp(p *parser, t0 string) (*re1.Regexp, string, error) {
+	t0 = trimSpaceLeft(t0)
+	delim, t := next(t0)
+	if delim == eof {
+		return nil, "", syntaxError(p, t0, "expected regular expression")
+	}
+	re, rest, err := re1.New(t, re1.Opts{Delimiter: delim})
+	if err != nil {
+		return nil, "", wrapSyntaxError(p, t, err)
+	}
+	return re, rest, nil
+}
+
+func parseAddr(p *parser, t0 string) (Address, string, error) {
+	left, t, err := parseAddr1(p, t0, false)
+	if err != nil {
+		return nil, "", err
+	}
+	if left, t, err = parseAddr2(p, t0, left, t); err != nil {
+		return nil, "", err
+	}
+	return parseAddr3(p, t0, left, t)
+}
+
+func parseAddr3(p *parser, start string, left Address, t0 string) (Address, string, error) {
+	r, t := next(trimSpaceLeft(t0))
+	if r != ',' && r != ';' {
+		return left, t0, nil
+	}
+	right, t, err := parseAddr(p, t)
+	if err != nil {
+		return nil, "", err
+	}
+	a := &RangeAddr{node: span(p, start, t), Left: left, Op: r, Right: right}
+	return parseAddr3(p, start, a, t)
+}
+
+func parseAddr2(p *parser, start string, left Address, t0 string) (Address, string, error) {
+	r, t := next(trimSpaceLeft(t0))
+	switch {
+	case r == eof:
+		return left, t0, nil
+	case strings.ContainsRune(addr1First, r):
+		t, r = t0, '+' // Insert +
+	case r != '+' && r != '-':
+		return left, t0, nil
+	}
+	right, t, err := parseAddr1(p, t, r == '-')
+	if err != nil {
+		return nil, "", err
+	}
+	a := &RelAddr{node: span(p, start, t), Left: left, Op: r, Right: right}
+	return parseAddr2(p, start, a, t)
+}
+
+const addr1First = ".'#0123456789/?$"
+
+func parseAddr1(p *parser, t0 string, rev bool) (Address, string, error) {
+	t0 = trimSpaceLeft(t0)
+	switch r, t := next(t0); r {
+	default:
+		return nil, t0, nil
+	case '.':
+		return &DotAddr{node: span(p, t0, t)}, t, nil
+	case '$':
+		return &EndAddr{node: span(p, t0, t)}, t, nil
+	case '\'':
+		if r, t = next(t); !unicode.IsLetter(r) {
+			return nil, "", syntaxError(p, t0, "expected mark name")
+		}
+		return &MarkAddr{node: span(p, t0, t), Name: r}, t, nil
+	case '#':
+		n, t, err := number(t)
+		if err != nil {
+			return nil, "", wrapSyntaxError(p, t0, err)
+		}
+		return &RuneAddr{node: span(p, t0, t), N: n, Reverse: rev}, t, nil
+	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		n, t, err := number(t0)
+		if err != nil {
+			return nil, "", wrapSyntaxError(p, t0, err)
+		}
+		return &LineAddr{node: span(p, t0, t), N: n, Reverse: rev}, t, nil
+	case '/', '?':
+		if r == '?' {
+			rev = !rev
+		}
+		opts := re1.Opts{Delimiter: r, Reverse: rev}
+		re, t1, err := re1.New(t, opts)
+		if err != nil {
+			return nil, "", wrapSyntaxError(p, t, err)
+		}
+		if strings.HasSuffix(t[:len(t)-len(t1)], string(r)) {
+			var ok bool
+			var t2 string
+			if opts, t2, ok = regexpFlags(t1, opts); ok {
+				if re, _, err = re1.New(t, opts); err != nil {
+					return nil, "", wrapSyntaxError(p, t, err)
+				}
+				t1 = t2
+			}
+		}
+		t = t1
+		return &RegexpAddr{node: span(p, t0, t), Regexp: re, Reverse: rev}, t, nil
+	}
+}
+
+// regexpFlags returns the Opts with the flags of a regexp address suffix
+// at the start of t, the text following the suffix, and whether there were flags.
+// The suffix is only flags if it is followed by a space,
+// the end of the text, or an address operator;
+// otherwise it is a command, such as i/text/ or s/a/b/.
+func regexpFlags(t string, opts re1.Opts) (re1.Opts, string, bool) {
+	i := strings.IndexFunc(t, func(r rune) bool { return r != 'i' && r != 's' })
+	switch {
+	case i == 0:
+		return opts, t, false
+	case i < 0:
+		i = len(t)
+	case !strings.ContainsRune(" \t,;+-", rune(t[i])):
+		return opts, t, false
+	}
+	for _, r := range t[:i] {
+		switch r {
+		case 'i':
+			opts.FoldCase = true
+		case 's':
+			opts.DotNewline = true
+		}
+	}
+	return opts, t[i:], true
+}
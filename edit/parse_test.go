@@ -0,0 +1,170 @@
+package edit
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/eaburns/T/rope"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		edit string
+		want string // tree of nodes; see dump
+		err  string // regex
+	}{
+		{edit: "", want: "AddrCmd[0,0](<nil>)"},
+		{edit: ".", want: "AddrCmd[0,1](DotAddr[0,1])"},
+		{edit: "  $  ", want: "AddrCmd[2,5](EndAddr[2,3])"},
+		{edit: "'a", want: "AddrCmd[0,2](MarkAddr[0,2])"},
+		{edit: "#12", want: "AddrCmd[0,3](RuneAddr[0,3])"},
+		{edit: "12", want: "AddrCmd[0,2](LineAddr[0,2])"},
+		{edit: "/abc/", want: "AddrCmd[0,5](RegexpAddr[0,5])"},
+		{edit: "?abc?", want: "AddrCmd[0,5](RegexpAddr[0,5])"},
+		{edit: "/abc/is", want: "AddrCmd[0,7](RegexpAddr[0,7])"},
+		{edit: "/abc/i,$", want: "AddrCmd[0,8](RangeAddr[0,8](RegexpAddr[0,6] EndAddr[7,8]))"},
+		{edit: "/abc/i p", want: "PrintCmd[0,8](RegexpAddr[0,6])"},
+		{edit: "/abc/i/x/", want: "ChangeCmd[0,9](RegexpAddr[0,5])"},
+		{edit: "/abc/s/a/b/", want: "SubCmd[0,11](RegexpAddr[0,5])"},
+		{edit: "/abc\ni", want: "ChangeCmd[0,6](RegexpAddr[0,5])"},
+		{edit: "1,2", want: "AddrCmd[0,3](RangeAddr[0,3](LineAddr[0,1] LineAddr[2,3]))"},
+		{edit: ",", want: "AddrCmd[0,1](RangeAddr[0,1](<nil> <nil>))"},
+		{edit: "1;/x/", want: "AddrCmd[0,5](RangeAddr[0,5](LineAddr[0,1] RegexpAddr[2,5]))"},
+		{edit: "1+2", want: "AddrCmd[0,3](RelAddr[0,3](LineAddr[0,1] LineAddr[2,3]))"},
+		{edit: "1 2", want: "AddrCmd[0,3](RelAddr[0,3](LineAddr[0,1] LineAddr[2,3]))"},
+		{edit: "-", want: "AddrCmd[0,1](RelAddr[0,1](<nil> <nil>))"},
+		{
+			edit: "1+2-3",
+			want: "AddrCmd[0,5](RelAddr[0,5](RelAddr[0,3](LineAddr[0,1] LineAddr[2,3]) LineAddr[4,5]))",
+		},
+		{edit: "d", want: "ChangeCmd[0,1](<nil>)"},
+		{edit: "1d", want: "ChangeCmd[0,2](LineAddr[0,1])"},
+		{edit: "1 , 2 d ", want: "ChangeCmd[0,7](RangeAddr[0,5](LineAddr[0,1] LineAddr[4,5]))"},
+		{edit: "a/xyz/", want: "ChangeCmd[0,6](<nil>)"},
+		{edit: "c\nxyz\n.\n", want: "ChangeCmd[0,8](<nil>)"},
+		{edit: "m$", want: "MoveCmd[0,2](<nil> EndAddr[1,2])"},
+		{edit: "t 1", want: "CopyCmd[0,3](<nil> LineAddr[2,3])"},
+		{edit: "m", err: "expected address"},
+		{edit: "p", want: "PrintCmd[0,1](<nil>)"},
+		{edit: "=#", want: "PrintAddrCmd[0,2](<nil>)"},
+		{edit: "k a", want: "MarkCmd[0,3](<nil>)"},
+		{edit: "s/a/b/g", want: "SubCmd[0,7](<nil>)"},
+		{edit: ",x/a/d", want: "LoopCmd[0,6](RangeAddr[0,1](<nil> <nil>) ChangeCmd[5,6](<nil>))"},
+		{edit: "g/a/ 1d", want: "CondCmd[0,7](<nil> ChangeCmd[5,7](LineAddr[5,6]))"},
+		{
+			edit: "{\n1d\n2d\n}",
+			want: "SeqCmd[0,9](<nil> ChangeCmd[2,4](LineAddr[2,3]) ChangeCmd[5,7](LineAddr[5,6]))",
+		},
+		{edit: "| sort", want: "PipeCmd[0,6](<nil>)"},
+		{edit: "X/a/ p", want: "FileLoopCmd[0,6](<nil> PrintCmd[5,6](<nil>))"},
+		{edit: "B a b", want: "FileSetCmd[0,5](<nil>)"},
+		{edit: "1w file", want: "FileCmd[0,7](LineAddr[0,1])"},
+
+		// Errors in commands that are not executed are reported.
+		{edit: ",x/no match/d junk", err: "expected end-of-input"},
+		{edit: ",g/no match/d junk", err: "expected end-of-input"},
+		{edit: ",x/no match/x/(/d", err: "unclosed [(]"},
+		{edit: "{\n1d\n2 @\n}", err: "bad command @"},
+		{edit: "{\n1d\n2d\n", err: "unclosed {"},
+		{edit: "@", err: "bad command @"},
+		{edit: "1X/a/ p", err: "unexpected address"},
+		{edit: "1b x", err: "unexpected address"},
+		{edit: "1e x", err: "unexpected address"},
+		{edit: "1f x", err: "unexpected address"},
+		{edit: "b", err: "expected file name"},
+		{edit: "B", err: "expected file name"},
+		{edit: "d junk", err: "expected end-of-input"},
+	}
+	for _, test := range tests {
+		switch p, err := Parse(test.edit); {
+		case test.err == "" && err == nil:
+			if got := dump(p.Cmd); got != test.want {
+				t.Errorf("Parse(%q)=%s, want %s", test.edit, got, test.want)
+			}
+
+		case test.err == "" && err != nil:
+			t.Errorf("Parse(%q)=_,%v, want nil", test.edit, err)
+
+		case test.err != "" && err == nil:
+			t.Errorf("Parse(%q)=_,nil, want matching %q", test.edit, test.err)
+
+		default: // test.err != " && err != nil:
+			if !match(test.err, err.Error()) {
+				t.Errorf("Parse(%q)=_,%q, want matching %q",
+					test.edit, err.Error(), test.err)
+			}
+		}
+	}
+}
+
+// dump returns a string representation of a node
+// and the addresses and sub-commands beneath it.
+func dump(n Node) string {
+	if n == nil || fmt.Sprint(n) == "<nil>" {
+		return "<nil>"
+	}
+	var kids []Node
+	switch n := n.(type) {
+	case *RangeAddr:
+		kids = []Node{n.Left, n.Right}
+	case *RelAddr:
+		kids = []Node{n.Left, n.Right}
+	case *MoveCmd:
+		kids = []Node{n.Addr, n.Dest}
+	case *CopyCmd:
+		kids = []Node{n.Addr, n.Dest}
+	case *CondCmd:
+		kids = []Node{n.Addr, n.Cmd}
+	case *LoopCmd:
+		kids = []Node{n.Addr, n.Cmd}
+	case *FileLoopCmd:
+		kids = []Node{n.Addr, n.Cmd}
+	case *SeqCmd:
+		kids = []Node{n.Addr}
+		for _, c := range n.Cmds {
+			kids = append(kids, c)
+		}
+	case Command:
+		kids = []Node{n.Address()}
+	}
+	var s strings.Builder
+	name := fmt.Sprintf("%T", n)
+	name = name[strings.LastIndex(name, ".")+1:]
+	fmt.Fprintf(&s, "%s[%d,%d]", name, n.Span()[0], n.Span()[1])
+	for i, k := range kids {
+		if i == 0 {
+			s.WriteRune('(')
+		} else {
+			s.WriteRune(' ')
+		}
+		s.WriteString(dump(k))
+		if i == len(kids)-1 {
+			s.WriteRune(')')
+		}
+	}
+	return s.String()
+}
+
+func TestProgramExec(t *testing.T) {
+	p, err := Parse(",x/[0-9]+/c/#/")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	for _, str := range []string{"", "abc", "1 2 3", "abc123def"} {
+		ro := rope.New(str)
+		want, err := Edit([2]int64{}, ",x/[0-9]+/c/#/", &strings.Builder{}, ro)
+		if err != nil {
+			t.Fatalf("Edit failed: %v", err)
+		}
+		got, err := p.Exec([2]int64{}, ro, &strings.Builder{})
+		if err != nil {
+			t.Fatalf("Exec failed: %v", err)
+		}
+		wantStr, _ := want.Apply(ro)
+		gotStr, _ := got.Apply(ro)
+		if gotStr.String() != wantStr.String() {
+			t.Errorf("Exec on %q=%q, want %q", str, gotStr.String(), wantStr.String())
+		}
+	}
+}
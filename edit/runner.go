@@ -0,0 +1,59 @@
+package edit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// ErrNoShell is the error of a shell command in an edit
+// executed with Options.NoShell.
+var ErrNoShell = errors.New("shell commands are disabled")
+
+// A Runner runs the shell commands of the |, <, and > edit commands.
+type Runner interface {
+	// Run runs the shell command cmd,
+	// reading its standard input from stdin
+	// and writing its standard output and error to stdout and stderr.
+	// If stdin is nil, the command has no input.
+	// The returned error is reported as an Error of kind Shell.
+	Run(ctx context.Context, cmd string, stdin io.Reader, stdout, stderr io.Writer) error
+}
+
+// An ExecRunner is a Runner that executes commands
+// as the -c argument of a shell program.
+// A command is killed if the context is done before it exits.
+type ExecRunner struct {
+	// Dir is the working directory of the commands.
+	// If Dir is empty, commands run in the current directory.
+	Dir string
+	// Env are environment variables of the form "key=value",
+	// added to the environment of the current process.
+	Env []string
+	// Shell is the shell program.
+	// If Shell is empty, the program is from the SHELL environment variable,
+	// or /bin/sh if SHELL is unset.
+	Shell string
+}
+
+// Run implements Runner.
+func (r ExecRunner) Run(ctx context.Context, cmd string, stdin io.Reader, stdout, stderr io.Writer) error {
+	shell := r.Shell
+	if shell == "" {
+		shell = os.Getenv("SHELL")
+	}
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+	c := exec.CommandContext(ctx, shell, "-c", cmd)
+	c.Dir = r.Dir
+	if len(r.Env) > 0 {
+		c.Env = append(os.Environ(), r.Env...)
+	}
+	c.Stdin = stdin
+	c.Stdout = stdout
+	c.Stderr = stderr
+	return c.Run()
+}
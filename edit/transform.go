@@ -0,0 +1,209 @@
+package edit
+
+import (
+	"math"
+
+	"github.com/eaburns/T/rope"
+)
+
+// Transform returns Diffs a' and b'
+// such that applying b then a' is the same as applying a then b',
+// where a and b are Diffs against the same rope.
+//
+// For example, if a are changes already applied to a rope
+// and b are changes computed concurrently against the original rope,
+// then b' are the changes of b rebased onto the changed rope.
+//
+// Text deleted by both a and b is deleted once.
+// Text inserted by a and b at the same address
+// is ordered with the text of a first.
+// The returned Diffs are in order of increasing address
+// and do not overlap.
+func Transform(a, b Diffs) (Diffs, Diffs) {
+	var as, bs ops
+	ai, bi := newOpIter(toOps(a)), newOpIter(toOps(b))
+	for !ai.done() || !bi.done() {
+		ca, cb := ai.peek(), bi.peek()
+		switch {
+		case ca.ins != nil:
+			as = as.push(ca)
+			bs = bs.push(op{n: ca.n})
+			ai.next(ca.n)
+		case cb.ins != nil:
+			as = as.push(op{n: cb.n})
+			bs = bs.push(cb)
+			bi.next(cb.n)
+		default:
+			n := ca.n
+			if cb.n < n {
+				n = cb.n
+			}
+			switch {
+			case !ca.del && !cb.del:
+				as = as.push(op{n: n})
+				bs = bs.push(op{n: n})
+			case ca.del && !cb.del:
+				as = as.push(op{n: n, del: true})
+			case !ca.del && cb.del:
+				bs = bs.push(op{n: n, del: true})
+			}
+			ai.next(n)
+			bi.next(n)
+		}
+	}
+	return as.diffs(), bs.diffs()
+}
+
+// Compose returns Diffs with the same effect as applying a then b.
+// The returned Diffs are in order of increasing address
+// and do not overlap.
+func Compose(a, b Diffs) Diffs {
+	return composeOps(toOps(a), toOps(b)).diffs()
+}
+
+// An op is a component of a change to a rope.
+// It either retains n bytes, deletes n bytes, or inserts ins,
+// in which case n is the length of ins.
+type op struct {
+	n   int64
+	del bool
+	ins rope.Rope
+}
+
+// ops are a change to a rope, as a sequence of op.
+// Bytes following the last op are retained.
+type ops []op
+
+// push returns the ops with o appended,
+// merging it with the last op if they are the same kind.
+func (os ops) push(o op) ops {
+	if o.n == 0 {
+		return os
+	}
+	if len(os) > 0 {
+		last := &os[len(os)-1]
+		switch {
+		case o.ins != nil && last.ins != nil:
+			last.ins = rope.Append(last.ins, o.ins)
+			last.n += o.n
+			return os
+		case o.ins == nil && last.ins == nil && o.del == last.del:
+			last.n += o.n
+			return os
+		}
+	}
+	return append(os, o)
+}
+
+// diffs returns the ops as Diffs.
+func (os ops) diffs() Diffs {
+	var ds Diffs
+	var d *Diff
+	var at int64
+	for _, o := range os {
+		switch {
+		case o.ins == nil && !o.del:
+			if d != nil {
+				at += d.TextLen()
+				d = nil
+			}
+			at += o.n
+			continue
+		case d == nil:
+			ds = append(ds, Diff{At: [2]int64{at, at}})
+			d = &ds[len(ds)-1]
+		}
+		if o.del {
+			d.At[1] += o.n
+		} else if d.Text == nil {
+			d.Text = o.ins
+		} else {
+			d.Text = rope.Append(d.Text, o.ins)
+		}
+	}
+	return ds
+}
+
+// toOps returns the ops of the Diffs.
+func toOps(ds Diffs) ops {
+	var os ops
+	for _, d := range ds {
+		var dos ops
+		// The text is inserted before the deletion,
+		// so it stays at the start of the changed span.
+		dos = dos.push(op{n: d.At[0]})
+		dos = dos.push(op{n: d.TextLen(), ins: d.Text})
+		dos = dos.push(op{n: d.At[1] - d.At[0], del: true})
+		os = composeOps(os, dos)
+	}
+	return os
+}
+
+// composeOps returns ops with the same effect as applying a then b.
+func composeOps(a, b ops) ops {
+	var os ops
+	ai, bi := newOpIter(a), newOpIter(b)
+	for !ai.done() || !bi.done() {
+		ca, cb := ai.peek(), bi.peek()
+		switch {
+		case ca.del:
+			os = os.push(ca)
+			ai.next(ca.n)
+		case cb.ins != nil:
+			os = os.push(cb)
+			bi.next(cb.n)
+		default:
+			n := ca.n
+			if cb.n < n {
+				n = cb.n
+			}
+			switch {
+			case ca.ins == nil && !cb.del:
+				os = os.push(op{n: n})
+			case ca.ins == nil && cb.del:
+				os = os.push(op{n: n, del: true})
+			case ca.ins != nil && !cb.del:
+				os = os.push(op{n: n, ins: rope.Slice(ca.ins, 0, n)})
+			}
+			ai.next(n)
+			bi.next(n)
+		}
+	}
+	return os
+}
+
+// An opIter iterates over ops, possibly splitting them.
+// After the last op, it returns an unbounded retain.
+type opIter struct {
+	os ops
+	// off is the number of bytes consumed of os[0].
+	off int64
+}
+
+func newOpIter(os ops) *opIter { return &opIter{os: os} }
+
+func (it *opIter) done() bool { return len(it.os) == 0 }
+
+// peek returns the remainder of the current op.
+func (it *opIter) peek() op {
+	if it.done() {
+		return op{n: math.MaxInt64}
+	}
+	o := it.os[0]
+	o.n -= it.off
+	if o.ins != nil {
+		o.ins = rope.Slice(o.ins, it.off, o.ins.Len())
+	}
+	return o
+}
+
+// next consumes n bytes of the current op.
+func (it *opIter) next(n int64) {
+	if it.done() {
+		return
+	}
+	if it.off += n; it.off >= it.os[0].n {
+		it.os = it.os[1:]
+		it.off = 0
+	}
+}
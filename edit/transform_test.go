@@ -0,0 +1,174 @@
+package edit
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/eaburns/T/rope"
+)
+
+func TestTransform(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		a, b Diffs
+		want string
+	}{
+		{
+			name: "empty",
+			text: "abc",
+			want: "abc",
+		},
+		{
+			name: "only a",
+			text: "abc",
+			a:    Diffs{{At: [2]int64{1, 2}, Text: rope.New("B")}},
+			want: "aBc",
+		},
+		{
+			name: "only b",
+			text: "abc",
+			b:    Diffs{{At: [2]int64{1, 2}, Text: rope.New("B")}},
+			want: "aBc",
+		},
+		{
+			name: "disjoint",
+			text: "abc",
+			a:    Diffs{{At: [2]int64{0, 1}, Text: rope.New("AA")}},
+			b:    Diffs{{At: [2]int64{2, 3}, Text: rope.New("CC")}},
+			want: "AAbCC",
+		},
+		{
+			name: "insert at the same address",
+			text: "abc",
+			a:    Diffs{{At: [2]int64{1, 1}, Text: rope.New("x")}},
+			b:    Diffs{{At: [2]int64{1, 1}, Text: rope.New("y")}},
+			want: "axybc",
+		},
+		{
+			name: "same delete",
+			text: "abcd",
+			a:    Diffs{{At: [2]int64{1, 3}}},
+			b:    Diffs{{At: [2]int64{1, 3}}},
+			want: "ad",
+		},
+		{
+			name: "overlapping delete",
+			text: "abcd",
+			a:    Diffs{{At: [2]int64{0, 2}}},
+			b:    Diffs{{At: [2]int64{1, 3}}},
+			want: "d",
+		},
+		{
+			name: "insert inside delete",
+			text: "abcd",
+			a:    Diffs{{At: [2]int64{0, 4}}},
+			b:    Diffs{{At: [2]int64{2, 2}, Text: rope.New("x")}},
+			want: "x",
+		},
+		{
+			name: "change inside change",
+			text: "abcd",
+			a:    Diffs{{At: [2]int64{0, 4}, Text: rope.New("1234")}},
+			b:    Diffs{{At: [2]int64{1, 3}, Text: rope.New("BC")}},
+			want: "1234BC",
+		},
+		{
+			name: "typing racing a pipe",
+			text: "hello\nworld\n",
+			a:    Diffs{{At: [2]int64{5, 5}, Text: rope.New(", there")}},
+			b:    Diffs{{At: [2]int64{6, 11}, Text: rope.New("WORLD")}},
+			want: "hello, there\nWORLD\n",
+		},
+		{
+			name: "sequential Diffs",
+			text: "abcdef",
+			a: Diffs{
+				{At: [2]int64{1, 2}, Text: rope.New("BB")},
+				{At: [2]int64{5, 6}, Text: rope.New("E")},
+			},
+			b: Diffs{
+				{At: [2]int64{0, 0}, Text: rope.New(">")},
+				{At: [2]int64{7, 7}, Text: rope.New("<")},
+			},
+			want: ">aBBcdEf<",
+		},
+	}
+	for _, test := range tests {
+		ro := rope.New(test.text)
+		a1, b1 := Transform(test.a, test.b)
+		ab := apply(apply(ro, test.a), b1)
+		ba := apply(apply(ro, test.b), a1)
+		if ab.String() != test.want || ba.String() != test.want {
+			t.Errorf("%s: Transform(%v, %v)=%v, %v; a then b'=%q, b then a'=%q, want %q",
+				test.name, test.a, test.b, a1, b1, ab.String(), ba.String(), test.want)
+		}
+	}
+}
+
+func TestTransformRandom(t *testing.T) {
+	rand.Seed(1)
+	for i := 0; i < 1000; i++ {
+		ro := rope.New(randText(rand.Intn(20)))
+		a, b := randDiffs(ro), randDiffs(ro)
+		a1, b1 := Transform(a, b)
+		ab := apply(apply(ro, a), b1)
+		ba := apply(apply(ro, b), a1)
+		if ab.String() != ba.String() {
+			t.Fatalf("Transform(%v, %v)=%v, %v; a then b'=%q, b then a'=%q",
+				a, b, a1, b1, ab.String(), ba.String())
+		}
+		if !increasing(a1) || !increasing(b1) {
+			t.Fatalf("Transform(%v, %v)=%v, %v, not in order", a, b, a1, b1)
+		}
+	}
+}
+
+func TestCompose(t *testing.T) {
+	rand.Seed(1)
+	for i := 0; i < 1000; i++ {
+		ro := rope.New(randText(rand.Intn(20)))
+		a := randDiffs(ro)
+		b := randDiffs(apply(ro, a))
+		ds := Compose(a, b)
+		if got, want := apply(ro, ds).String(), apply(apply(ro, a), b).String(); got != want {
+			t.Fatalf("Compose(%v, %v)=%v, applied %q, want %q", a, b, ds, got, want)
+		}
+		if !increasing(ds) {
+			t.Fatalf("Compose(%v, %v)=%v, not in order", a, b, ds)
+		}
+	}
+}
+
+func apply(ro rope.Rope, ds Diffs) rope.Rope {
+	ro, _ = ds.Apply(ro)
+	return ro
+}
+
+// increasing returns whether the Diffs are in increasing order
+// and neither overlap nor touch.
+func increasing(ds Diffs) bool {
+	for i := 1; i < len(ds); i++ {
+		if ds[i].At[0] <= ds[i-1].At[0]+ds[i-1].TextLen() {
+			return false
+		}
+	}
+	return true
+}
+
+// randDiffs returns a few random, possibly overlapping, Diffs against ro.
+func randDiffs(ro rope.Rope) Diffs {
+	var ds Diffs
+	n := ro.Len()
+	for i := rand.Intn(4); i > 0; i-- {
+		at0 := rand.Int63n(n + 1)
+		at1 := at0 + rand.Int63n(n-at0+1)
+		d := Diff{At: [2]int64{at0, at1}}
+		if s := randText(rand.Intn(3)); s != "" {
+			d.Text = rope.New(s)
+		}
+		n += d.TextLen() - (at1 - at0)
+		ds = append(ds, d)
+	}
+	return ds
+}
@@ -0,0 +1,404 @@
+package edit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/eaburns/T/rope"
+)
+
+// UnifiedContext is the number of lines of context
+// around each change written by WriteUnified.
+const UnifiedContext = 3
+
+// A Patch is the change to a single file in a unified diff.
+type Patch struct {
+	// OldName and NewName are the names of the file
+	// on the --- and +++ lines, without any timestamp.
+	OldName, NewName string
+	// Hunks are the hunks of the change in increasing order.
+	Hunks []Hunk
+}
+
+// A Hunk is a contiguous block of changed lines
+// with surrounding context lines.
+type Hunk struct {
+	// OldLine and NewLine are the line numbers, from 1,
+	// of the first line of the hunk in the old and new text.
+	OldLine, NewLine int
+	// Lines are the lines of the hunk.
+	// Each begins with ' ' for context, '-' for deletion, or '+' for insertion,
+	// and ends with a newline unless the line ends the text without one.
+	Lines []string
+}
+
+// A ConflictError is returned when the context and deleted lines
+// of hunks of a Patch do not match the text.
+type ConflictError struct {
+	// Hunks are the indices of the conflicting hunks.
+	Hunks []int
+}
+
+func (err *ConflictError) Error() string {
+	if len(err.Hunks) == 1 {
+		return fmt.Sprintf("hunk %d does not match", err.Hunks[0]+1)
+	}
+	return fmt.Sprintf("%d hunks do not match", len(err.Hunks))
+}
+
+// WriteUnified writes a unified diff of applying the Diffs to a rope,
+// with UnifiedContext lines of context.
+// The changed lines are those changed by the Diffs,
+// expanded to whole lines.
+// Nothing is written if the Diffs do not change the text.
+func WriteUnified(w io.Writer, oldName, newName string, ro rope.Rope, ds Diffs) error {
+	newRo, _ := ds.Apply(ro)
+	a, b := ro.String(), newRo.String()
+	al, bl := splitLines(a), splitLines(b)
+	hs := lineHunks(a, b, al, bl, changedRegions(ds))
+	if len(hs) == 0 {
+		return nil
+	}
+	var s strings.Builder
+	s.WriteString("--- " + oldName + "\n")
+	s.WriteString("+++ " + newName + "\n")
+	for len(hs) > 0 {
+		// Group hunks with overlapping context.
+		n := 1
+		for n < len(hs) && hs[n][0]-hs[n-1][1] <= 2*UnifiedContext {
+			n++
+		}
+		i0 := hs[0][0] - UnifiedContext
+		if i0 < 0 {
+			i0 = 0
+		}
+		i1 := hs[n-1][1] + UnifiedContext
+		if i1 > len(al) {
+			i1 = len(al)
+		}
+		// Context lines are the same in the old and new text.
+		j0 := hs[0][2] - (hs[0][0] - i0)
+		j1 := hs[n-1][3] + (i1 - hs[n-1][1])
+		fmt.Fprintf(&s, "@@ -%s +%s @@\n", unifiedRange(i0, i1), unifiedRange(j0, j1))
+		i := i0
+		for _, h := range hs[:n] {
+			writeUnifiedLines(&s, ' ', al[i:h[0]])
+			writeUnifiedLines(&s, '-', al[h[0]:h[1]])
+			writeUnifiedLines(&s, '+', bl[h[2]:h[3]])
+			i = h[1]
+		}
+		writeUnifiedLines(&s, ' ', al[i:i1])
+		hs = hs[n:]
+	}
+	_, err := io.WriteString(w, s.String())
+	return err
+}
+
+// A region is a span of the old text, [a0, a1),
+// changed to a span of the new text, [b0, b1).
+type region struct{ a0, a1, b0, b1 int }
+
+// changedRegions returns the regions changed by the Diffs
+// in increasing order, with overlapping or adjacent regions merged.
+func changedRegions(ds Diffs) []region {
+	var cs []region
+	for _, d := range ds {
+		s, e := int(d.At[0]), int(d.At[1])
+		// cs[i:j] overlap or touch [s, e) in the text before d.
+		i := 0
+		for i < len(cs) && cs[i].b1 < s {
+			i++
+		}
+		j := i
+		for j < len(cs) && cs[j].b0 <= e {
+			j++
+		}
+		c := region{a0: s, a1: e, b0: s, b1: e}
+		if i > 0 {
+			c.a0 -= cs[i-1].b1 - cs[i-1].a1
+		}
+		if j > 0 {
+			c.a1 -= cs[j-1].b1 - cs[j-1].a1
+		}
+		if i < j && cs[i].b0 < s {
+			c.a0, c.b0 = cs[i].a0, cs[i].b0
+		}
+		if i < j && cs[j-1].b1 > e {
+			c.a1, c.b1 = cs[j-1].a1, cs[j-1].b1
+		}
+		delta := int(d.TextLen()) - (e - s)
+		c.b1 += delta
+		for k := j; k < len(cs); k++ {
+			cs[k].b0 += delta
+			cs[k].b1 += delta
+		}
+		cs = append(cs[:i], append([]region{c}, cs[j:]...)...)
+	}
+	return cs
+}
+
+// lineHunks returns the changed regions expanded to whole lines,
+// as [4]int{a0, a1, b0, b1} changing lines al[a0:a1] to bl[b0:b1].
+// Regions that do not change the lines are omitted.
+func lineHunks(a, b string, al, bl []string, cs []region) [][4]int {
+	aoff, boff := lineOffsets(al), lineOffsets(bl)
+	var ls []region
+	for _, c := range cs {
+		// Expand to the start of the line.
+		i := sort.SearchInts(aoff, c.a0+1) - 1
+		if i == len(al) && i > 0 && !strings.HasSuffix(al[i-1], "\n") {
+			i-- // the last line does not end with newline
+		}
+		k := c.a0 - aoff[i]
+		c.a0, c.b0 = c.a0-k, c.b0-k
+		if n := len(ls); n > 0 && ls[n-1].a1 > c.a0 {
+			c.a0, c.b0 = ls[n-1].a0, ls[n-1].b0
+			ls = ls[:n-1]
+		}
+		// Expand to the end of the line.
+		if !atLineStart(a, c.a1) || !atLineStart(b, c.b1) {
+			end := len(a)
+			if i := sort.SearchInts(aoff, c.a1+1) - 1; i < len(al) {
+				end = aoff[i+1]
+			}
+			c.a1, c.b1 = end, c.b1+end-c.a1
+		}
+		ls = append(ls, c)
+	}
+	var hs [][4]int
+	for _, c := range ls {
+		h := [4]int{
+			sort.SearchInts(aoff, c.a0),
+			sort.SearchInts(aoff, c.a1),
+			sort.SearchInts(boff, c.b0),
+			sort.SearchInts(boff, c.b1),
+		}
+		if a[c.a0:c.a1] != b[c.b0:c.b1] {
+			hs = append(hs, h)
+		}
+	}
+	return hs
+}
+
+func atLineStart(s string, i int) bool { return i == 0 || s[i-1] == '\n' }
+
+// unifiedRange returns the range of a hunk header
+// for the lines [i0, i1) numbered from 0.
+func unifiedRange(i0, i1 int) string {
+	switch i1 - i0 {
+	case 0:
+		// An empty range is the line before it.
+		return strconv.Itoa(i0) + ",0"
+	case 1:
+		return strconv.Itoa(i0 + 1)
+	default:
+		return strconv.Itoa(i0+1) + "," + strconv.Itoa(i1-i0)
+	}
+}
+
+func writeUnifiedLines(s *strings.Builder, op byte, lines []string) {
+	for _, l := range lines {
+		s.WriteByte(op)
+		s.WriteString(l)
+		if !strings.HasSuffix(l, "\n") {
+			s.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// ParseUnified parses a unified diff,
+// such as the output of diff -u or git diff,
+// returning a Patch for each file in the diff.
+// Text outside of the file headers and hunks is ignored.
+func ParseUnified(text string) ([]Patch, error) {
+	var ps []Patch
+	lines := splitLines(text)
+	for i := 0; i < len(lines); {
+		l := lines[i]
+		switch {
+		case strings.HasPrefix(l, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			ps = append(ps, Patch{
+				OldName: unifiedName(l[len("--- "):]),
+				NewName: unifiedName(lines[i+1][len("+++ "):]),
+			})
+			i += 2
+		case strings.HasPrefix(l, "@@ "):
+			if len(ps) == 0 {
+				return nil, errors.New("line " + strconv.Itoa(i+1) + ": hunk with no file header")
+			}
+			h, n, err := parseHunk(lines[i:])
+			if err != nil {
+				return nil, errors.New("line " + strconv.Itoa(i+n+1) + ": " + err.Error())
+			}
+			p := &ps[len(ps)-1]
+			p.Hunks = append(p.Hunks, h)
+			i += n
+		default:
+			i++
+		}
+	}
+	return ps, nil
+}
+
+// unifiedName returns the file name of a --- or +++ line,
+// without the timestamp that may follow a tab.
+func unifiedName(s string) string {
+	s = strings.TrimSuffix(s, "\n")
+	if i := strings.IndexByte(s, '\t'); i >= 0 {
+		s = s[:i]
+	}
+	return s
+}
+
+// parseHunk parses a hunk beginning with its header,
+// and returns the hunk and the number of lines parsed.
+// On error, the number is that of the lines preceding the error.
+func parseHunk(lines []string) (Hunk, int, error) {
+	var h Hunk
+	var oldN, newN int
+	hdr := strings.TrimSuffix(lines[0], "\n")
+	f := strings.Fields(hdr)
+	if len(f) < 4 || f[3] != "@@" || !strings.HasPrefix(f[1], "-") || !strings.HasPrefix(f[2], "+") {
+		return Hunk{}, 0, errors.New("malformed hunk header")
+	}
+	var err1, err2 error
+	h.OldLine, oldN, err1 = parseUnifiedRange(f[1][1:])
+	h.NewLine, newN, err2 = parseUnifiedRange(f[2][1:])
+	if err1 != nil || err2 != nil {
+		return Hunk{}, 0, errors.New("malformed hunk header")
+	}
+	if oldN == 0 {
+		h.OldLine++ // an empty range is the line before it
+	}
+	if newN == 0 {
+		h.NewLine++
+	}
+	n := 1
+	for oldN > 0 || newN > 0 {
+		if n == len(lines) {
+			return Hunk{}, n, errors.New("unexpected end of hunk")
+		}
+		l := lines[n]
+		if l == "\n" {
+			// Some tools strip the space of empty context lines.
+			l = " \n"
+		}
+		switch l[0] {
+		case ' ':
+			oldN--
+			newN--
+		case '-':
+			oldN--
+		case '+':
+			newN--
+		default:
+			return Hunk{}, n, errors.New("unexpected end of hunk")
+		}
+		if oldN < 0 || newN < 0 {
+			return Hunk{}, n, errors.New("hunk is longer than its header")
+		}
+		h.Lines = append(h.Lines, l)
+		n++
+		if n < len(lines) && strings.HasPrefix(lines[n], "\\") {
+			// \ No newline at end of file
+			h.Lines[len(h.Lines)-1] = strings.TrimSuffix(l, "\n")
+			n++
+		}
+	}
+	return h, n, nil
+}
+
+// parseUnifiedRange parses a hunk header range l[,s],
+// returning the line and the number of lines.
+func parseUnifiedRange(s string) (int, int, error) {
+	n := 1
+	if i := strings.IndexByte(s, ','); i >= 0 {
+		var err error
+		if n, err = strconv.Atoi(s[i+1:]); err != nil || n < 0 {
+			return 0, 0, errors.New("bad range")
+		}
+		s = s[:i]
+	}
+	l, err := strconv.Atoi(s)
+	if err != nil || l < 0 {
+		return 0, 0, errors.New("bad range")
+	}
+	return l, n, nil
+}
+
+// Diffs returns the Diffs that apply the patch to a rope.
+//
+// Each hunk applies where its context and deleted lines match the text.
+// If they do not match at the hunk's line, the nearest match is used,
+// as long as it follows the previous hunk.
+// If any hunk does not match, Diffs returns a *ConflictError.
+func (p Patch) Diffs(ro rope.Rope) (Diffs, error) {
+	lines := splitLines(ro.String())
+	offs := lineOffsets(lines)
+	var conflicts []int
+	var ds Diffs
+	var adj int64
+	var start, delta int // delta is the offset of the previous hunk's match
+	for i, h := range p.Hunks {
+		var oldText, newText strings.Builder
+		var oldLines []string
+		for _, l := range h.Lines {
+			if l[0] != '+' {
+				oldText.WriteString(l[1:])
+				oldLines = append(oldLines, l[1:])
+			}
+			if l[0] != '-' {
+				newText.WriteString(l[1:])
+			}
+		}
+		at := matchLines(lines, oldLines, start, h.OldLine-1+delta)
+		if at < 0 {
+			conflicts = append(conflicts, i)
+			continue
+		}
+		delta = at - (h.OldLine - 1)
+		start = at + len(oldLines)
+		// The Diffs of the hunk are already relative to each other,
+		// so they are all offset by the adjustment of the previous hunks.
+		base := int64(offs[at]) + adj
+		for _, d := range DiffRopes(rope.New(oldText.String()), rope.New(newText.String())) {
+			adj += d.TextLen() - (d.At[1] - d.At[0])
+			d.At[0] += base
+			d.At[1] += base
+			ds = append(ds, d)
+		}
+	}
+	if len(conflicts) > 0 {
+		return nil, &ConflictError{Hunks: conflicts}
+	}
+	return ds, nil
+}
+
+// matchLines returns the index of the occurrence of want in lines
+// at or after start that is nearest to near, or -1 if there is none.
+func matchLines(lines, want []string, start, near int) int {
+	match := func(i int) bool {
+		if i < start || i+len(want) > len(lines) {
+			return false
+		}
+		for j, l := range want {
+			if lines[i+j] != l {
+				return false
+			}
+		}
+		return true
+	}
+	for d := 0; near-d >= start || near+d+len(want) <= len(lines); d++ {
+		if match(near - d) {
+			return near - d
+		}
+		if match(near + d) {
+			return near + d
+		}
+	}
+	return -1
+}
@@ -0,0 +1,418 @@
+package edit
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/eaburns/T/rope"
+)
+
+func TestWriteUnified(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{
+			name: "no change",
+			a:    "a\nb\n",
+			b:    "a\nb\n",
+			want: "",
+		},
+		{
+			name: "change",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n",
+			b:    "1\n2\n3\n4\nfive\n6\n7\n8\n",
+			want: "--- a\n+++ b\n" +
+				"@@ -2,7 +2,7 @@\n" +
+				" 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "insert at start",
+			a:    "1\n2\n",
+			b:    "0\n1\n2\n",
+			want: "--- a\n+++ b\n" +
+				"@@ -1,2 +1,3 @@\n" +
+				"+0\n 1\n 2\n",
+		},
+		{
+			name: "insert into empty",
+			a:    "",
+			b:    "1\n",
+			want: "--- a\n+++ b\n" +
+				"@@ -0,0 +1 @@\n" +
+				"+1\n",
+		},
+		{
+			name: "delete all",
+			a:    "1\n2\n",
+			b:    "",
+			want: "--- a\n+++ b\n" +
+				"@@ -1,2 +0,0 @@\n" +
+				"-1\n-2\n",
+		},
+		{
+			name: "separate hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			b:    "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			want: "--- a\n+++ b\n" +
+				"@@ -1,4 +1,4 @@\n" +
+				"-1\n+one\n 2\n 3\n 4\n" +
+				"@@ -7,4 +7,4 @@\n" +
+				" 7\n 8\n 9\n-10\n+ten\n",
+		},
+		{
+			name: "merged hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n",
+			b:    "one\n2\n3\n4\n5\n6\n7\neight\n",
+			want: "--- a\n+++ b\n" +
+				"@@ -1,8 +1,8 @@\n" +
+				"-1\n+one\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+eight\n",
+		},
+		{
+			name: "no newline at end of file",
+			a:    "1\n2",
+			b:    "1\n2\n",
+			want: "--- a\n+++ b\n" +
+				"@@ -1,2 +1,2 @@\n" +
+				" 1\n-2\n\\ No newline at end of file\n+2\n",
+		},
+	}
+	for _, test := range tests {
+		a := rope.New(test.a)
+		ds := DiffRopes(a, rope.New(test.b))
+		var s strings.Builder
+		if err := WriteUnified(&s, "a", "b", a, ds); err != nil {
+			t.Errorf("%s: WriteUnified(...)=%v", test.name, err)
+			continue
+		}
+		if s.String() != test.want {
+			t.Errorf("%s: WriteUnified(...) wrote\n%s\nwant\n%s", test.name, s.String(), test.want)
+		}
+	}
+}
+
+func TestWriteUnified_diffs(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		ds   Diffs
+		want string
+	}{
+		{
+			name: "delete first of equal lines",
+			a:    "x\nx\nx\n",
+			ds:   Diffs{{At: [2]int64{0, 2}}},
+			want: "@@ -1,3 +1,2 @@\n-x\n x\n x\n",
+		},
+		{
+			name: "delete last of equal lines",
+			a:    "x\nx\nx\n",
+			ds:   Diffs{{At: [2]int64{4, 6}}},
+			want: "@@ -1,3 +1,2 @@\n x\n x\n-x\n",
+		},
+		{
+			name: "changes within a line",
+			a:    "abc\ndef\n",
+			ds: Diffs{
+				{At: [2]int64{0, 1}, Text: rope.New("A")},
+				{At: [2]int64{2, 3}, Text: rope.New("C")},
+			},
+			want: "@@ -1,2 +1,2 @@\n-abc\n+AbC\n def\n",
+		},
+		{
+			name: "out of order",
+			a:    "1\n2\n3\n",
+			ds: Diffs{
+				{At: [2]int64{4, 5}, Text: rope.New("three")},
+				{At: [2]int64{0, 1}, Text: rope.New("one")},
+			},
+			want: "@@ -1,3 +1,3 @@\n-1\n+one\n 2\n-3\n+three\n",
+		},
+		{
+			name: "overlapping",
+			a:    "abc\ndef\n",
+			ds: Diffs{
+				{At: [2]int64{1, 1}, Text: rope.New("XY")},
+				{At: [2]int64{2, 4}},
+			},
+			want: "@@ -1,2 +1,2 @@\n-abc\n+aXc\n def\n",
+		},
+		{
+			name: "insert a line",
+			a:    "1\n2\n",
+			ds:   Diffs{{At: [2]int64{2, 2}, Text: rope.New("x\n")}},
+			want: "@@ -1,2 +1,3 @@\n 1\n+x\n 2\n",
+		},
+		{
+			name: "insert at the end of a line",
+			a:    "1\n2\n",
+			ds:   Diffs{{At: [2]int64{1, 1}, Text: rope.New("x")}},
+			want: "@@ -1,2 +1,2 @@\n-1\n+1x\n 2\n",
+		},
+		{
+			name: "no change",
+			a:    "abc\n",
+			ds:   Diffs{{At: [2]int64{0, 1}, Text: rope.New("a")}},
+			want: "",
+		},
+	}
+	for _, test := range tests {
+		var s strings.Builder
+		if err := WriteUnified(&s, "a", "b", rope.New(test.a), test.ds); err != nil {
+			t.Errorf("%s: WriteUnified(...)=%v", test.name, err)
+			continue
+		}
+		want := test.want
+		if want != "" {
+			want = "--- a\n+++ b\n" + want
+		}
+		if s.String() != want {
+			t.Errorf("%s: WriteUnified(...) wrote\n%s\nwant\n%s", test.name, s.String(), want)
+		}
+	}
+}
+
+func TestParseUnified(t *testing.T) {
+	const patch = `diff --git a/x.go b/x.go
+index 1234567..89abcde 100644
+--- a/x.go
++++ b/x.go
+@@ -1,3 +1,3 @@ package x
+ package x
+-var a = 1
++var a = 2
+
+@@ -10 +10,0 @@
+-// end
+\ No newline at end of file
+diff --git a/y.go b/y.go
+--- a/y.go	2020-01-01 00:00:00
++++ b/y.go	2020-01-02 00:00:00
+@@ -0,0 +1 @@
++package y
+`
+	want := []Patch{
+		{
+			OldName: "a/x.go",
+			NewName: "b/x.go",
+			Hunks: []Hunk{
+				{
+					OldLine: 1,
+					NewLine: 1,
+					Lines:   []string{" package x\n", "-var a = 1\n", "+var a = 2\n", " \n"},
+				},
+				{
+					OldLine: 10,
+					NewLine: 11,
+					Lines:   []string{"-// end"},
+				},
+			},
+		},
+		{
+			OldName: "a/y.go",
+			NewName: "b/y.go",
+			Hunks: []Hunk{
+				{OldLine: 1, NewLine: 1, Lines: []string{"+package y\n"}},
+			},
+		},
+	}
+	ps, err := ParseUnified(patch)
+	if err != nil || !reflect.DeepEqual(ps, want) {
+		t.Errorf("ParseUnified(...)=%#v,%v, want %#v,nil", ps, err, want)
+	}
+}
+
+func TestParseUnifiedError(t *testing.T) {
+	tests := []struct {
+		patch string
+		err   string
+	}{
+		{patch: "@@ -1 +1 @@\n-a\n+b\n", err: "line 1: hunk with no file header"},
+		{patch: "--- a\n+++ b\n@@ -1 @@\n", err: "line 3: malformed hunk header"},
+		{patch: "--- a\n+++ b\n@@ -x +1 @@\n", err: "line 3: malformed hunk header"},
+		{patch: "--- a\n+++ b\n@@ -1,2 +1 @@\n-a\n", err: "line 5: unexpected end of hunk"},
+		{patch: "--- a\n+++ b\n@@ -1 +1 @@\n-a\n-b\n", err: "line 5: hunk is longer than its header"},
+		{patch: "--- a\n+++ b\n@@ -1,2 +1,2 @@\n a\nxyz\n", err: "line 5: unexpected end of hunk"},
+	}
+	for _, test := range tests {
+		if _, err := ParseUnified(test.patch); err == nil || err.Error() != test.err {
+			t.Errorf("ParseUnified(%q)=_,%v, want %q", test.patch, err, test.err)
+		}
+	}
+}
+
+func TestPatchDiffs(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		patch     string
+		want      string
+		conflicts []int
+	}{
+		{
+			name:  "apply",
+			text:  "a\nb\nc\n",
+			patch: "--- x\n+++ x\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+			want:  "a\nB\nc\n",
+		},
+		{
+			name:  "offset",
+			text:  "0\n0\na\nb\nc\n",
+			patch: "--- x\n+++ x\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+			want:  "0\n0\na\nB\nc\n",
+		},
+		{
+			name:  "nearest match",
+			text:  "a\nb\nc\nx\na\nb\nc\n",
+			patch: "--- x\n+++ x\n@@ -5,3 +5,3 @@\n a\n-b\n+B\n c\n",
+			want:  "a\nb\nc\nx\na\nB\nc\n",
+		},
+		{
+			name: "two hunks",
+			text: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			patch: "--- x\n+++ x\n" +
+				"@@ -1,2 +1,3 @@\n 1\n+1.5\n 2\n" +
+				"@@ -9,2 +10,1 @@\n 9\n-10\n",
+			want: "1\n1.5\n2\n3\n4\n5\n6\n7\n8\n9\n",
+		},
+		{
+			name:  "no newline at end of file",
+			text:  "a\nb",
+			patch: "--- x\n+++ x\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+			want:  "a\nb\n",
+		},
+		{
+			name:      "conflict",
+			text:      "a\nX\nc\n",
+			patch:     "--- x\n+++ x\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+			conflicts: []int{0},
+		},
+		{
+			name: "conflict out of order",
+			text: "a\nb\nc\n",
+			patch: "--- x\n+++ x\n" +
+				"@@ -1,2 +1,2 @@\n a\n-b\n+B\n" +
+				"@@ -1,2 +1,2 @@\n a\n-b\n+B\n",
+			conflicts: []int{1},
+		},
+	}
+	for _, test := range tests {
+		ps, err := ParseUnified(test.patch)
+		if err != nil || len(ps) != 1 {
+			t.Fatalf("%s: ParseUnified(...)=%v,%v", test.name, ps, err)
+		}
+		ds, err := ps[0].Diffs(rope.New(test.text))
+		if test.conflicts != nil {
+			cerr, ok := err.(*ConflictError)
+			if !ok || !reflect.DeepEqual(cerr.Hunks, test.conflicts) {
+				t.Errorf("%s: Diffs(...)=_,%v, want conflicts %v", test.name, err, test.conflicts)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Diffs(...)=_,%v, want nil", test.name, err)
+			continue
+		}
+		if ro, _ := ds.Apply(rope.New(test.text)); ro.String() != test.want {
+			t.Errorf("%s: applied %q, want %q", test.name, ro.String(), test.want)
+		}
+	}
+}
+
+func TestUnifiedRoundTrip(t *testing.T) {
+	rand.Seed(1)
+	for i := 0; i < 500; i++ {
+		a := randLines(rand.Intn(30))
+		b := mutateLines(a)
+		ds := DiffRopes(rope.New(a), rope.New(b))
+		var s strings.Builder
+		if err := WriteUnified(&s, "a", "b", rope.New(a), ds); err != nil {
+			t.Fatalf("WriteUnified(...)=%v", err)
+		}
+		ps, err := ParseUnified(s.String())
+		if err != nil {
+			t.Fatalf("ParseUnified(%q)=_,%v", s.String(), err)
+		}
+		if len(ps) == 0 {
+			if a != b {
+				t.Fatalf("ParseUnified(%q)=[], want a patch", s.String())
+			}
+			continue
+		}
+		ds, err = ps[0].Diffs(rope.New(a))
+		if err != nil {
+			t.Fatalf("Diffs(%q) of %q=_,%v", a, s.String(), err)
+		}
+		if ro, _ := ds.Apply(rope.New(a)); ro.String() != b {
+			t.Fatalf("patch %q applied to %q=%q, want %q", s.String(), a, ro.String(), b)
+		}
+	}
+}
+
+// TestUnifiedRoundTrip_diffs is like TestUnifiedRoundTrip,
+// but with random Diffs that may overlap and be out of order.
+func TestUnifiedRoundTrip_diffs(t *testing.T) {
+	rand.Seed(1)
+	for i := 0; i < 500; i++ {
+		a := randLines(rand.Intn(30))
+		ro := rope.New(a)
+		var ds Diffs
+		for j := rand.Intn(5); j > 0; j-- {
+			at0 := rand.Int63n(ro.Len() + 1)
+			at1 := at0 + rand.Int63n(ro.Len()-at0+1)
+			d := Diff{At: [2]int64{at0, at1}, Text: rope.New(randLines(rand.Intn(3)))}
+			ro, _ = d.Apply(ro)
+			ds = append(ds, d)
+		}
+		b := ro.String()
+		var s strings.Builder
+		if err := WriteUnified(&s, "a", "b", rope.New(a), ds); err != nil {
+			t.Fatalf("WriteUnified(...)=%v", err)
+		}
+		ps, err := ParseUnified(s.String())
+		if err != nil {
+			t.Fatalf("ParseUnified(%q)=_,%v", s.String(), err)
+		}
+		if len(ps) == 0 {
+			if a != b {
+				t.Fatalf("ParseUnified(%q)=[], want a patch", s.String())
+			}
+			continue
+		}
+		pds, err := ps[0].Diffs(rope.New(a))
+		if err != nil {
+			t.Fatalf("Diffs(%q) of %q=_,%v", a, s.String(), err)
+		}
+		if ro, _ := pds.Apply(rope.New(a)); ro.String() != b {
+			t.Fatalf("patch %q applied to %q=%q, want %q", s.String(), a, ro.String(), b)
+		}
+	}
+}
+
+func randLines(n int) string {
+	var s strings.Builder
+	for i := 0; i < n; i++ {
+		s.WriteString(strings.Repeat("x", rand.Intn(3)) + "\n")
+	}
+	if n > 0 && rand.Intn(4) == 0 {
+		return strings.TrimSuffix(s.String(), "\n")
+	}
+	return s.String()
+}
+
+// mutateLines returns s with a few random lines inserted and deleted.
+func mutateLines(s string) string {
+	lines := splitLines(s)
+	for i := rand.Intn(4); i > 0; i-- {
+		j := rand.Intn(len(lines) + 1)
+		if rand.Intn(2) == 0 && j < len(lines) {
+			lines = append(lines[:j], lines[j+1:]...)
+		} else {
+			lines = append(lines[:j], append([]string{strings.Repeat("y", rand.Intn(3)) + "\n"}, lines[j:]...)...)
+		}
+	}
+	return strings.Join(lines, "")
+}
@@ -9,7 +9,10 @@ import (
 	"log"
 	"math"
 	"os"
+	"os/exec"
+	"runtime"
 	"runtime/pprof"
+	"strconv"
 	"time"
 
 	"github.com/eaburns/T/ui"
@@ -79,6 +82,9 @@ func newWindow(ctx context.Context, scr screen.Screen) *win {
 		Window: window,
 	}
 	w.win = ui.NewWin(w.dpi)
+	if warp := xdotoolWarp(); warp != nil {
+		w.win.SetWarp(warp)
+	}
 	w.win.Resize(w.size)
 
 	go tick(w)
@@ -165,6 +171,25 @@ func poll(scr screen.Screen, w *win) {
 	}
 }
 
+// xdotoolWarp returns a function that moves the mouse pointer
+// to a point in the active window, or nil if it cannot be moved.
+// The shiny driver cannot move the pointer, so it runs xdotool,
+// which must be installed and which only works on X11.
+func xdotoolWarp() func(image.Point) {
+	if runtime.GOOS == "darwin" || runtime.GOOS == "windows" || os.Getenv("DISPLAY") == "" {
+		return nil
+	}
+	xdotool, err := exec.LookPath("xdotool")
+	if err != nil {
+		return nil
+	}
+	return func(pt image.Point) {
+		x, y := strconv.Itoa(pt.X), strconv.Itoa(pt.Y)
+		cmd := exec.Command(xdotool, "getactivewindow", "mousemove", "--window", "%1", x, y)
+		go cmd.Run()
+	}
+}
+
 func mouseEvent(w *win, e mouse.Event) {
 	switch pt := image.Pt(int(e.X), int(e.Y)); {
 	case e.Button == mouse.ButtonWheelUp:
@@ -0,0 +1,352 @@
+package re1
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// A dfa is a lazily-built deterministic finite automaton
+// simulating a Regexp's program.
+// It finds the boundaries and ID of a match,
+// but not the submatches, without allocating per thread.
+//
+// A DFA state is a list of the NFA threads of the VM,
+// without their memory, in priority order.
+// The threads are grouped by the position at which they started,
+// so the DFA can compute the start of the match:
+// as it runs, it tracks the start position of each group.
+//
+// States and transitions are built as needed and cached.
+// When the cache grows beyond maxDFASize it is cleared.
+// If it is cleared too often during a single search,
+// the DFA gives up, and the search falls back to the NFA.
+type dfa struct {
+	mu     sync.Mutex
+	states map[string]*dstate
+	// size is the approximate number of bytes used by the cache.
+	size int
+	// begin are the initial states for each context.
+	begin [nctx]*dstate
+
+	// These are scratch space for computing transitions.
+	seen   []uint32 // gen at which each pc was last added.
+	gen    uint32
+	list   []int // pcs of consuming instructions.
+	ends   []int // end of each group in list.
+	cut    bool
+	match  bool
+	id     int
+	starts [2][]int64
+}
+
+const (
+	// maxDFASize is the approximate maximum size in bytes of a DFA's cache.
+	maxDFASize = 4 << 20
+	// maxDFAClears is the maximum number of times
+	// the DFA cache can be cleared during a single search.
+	maxDFAClears = 4
+)
+
+// Contexts are the classes of the previous rune
+// that are relevant to the empty-width assertions.
+const (
+	ctxEOF = iota
+	ctxNewline
+	ctxWord
+	ctxOther
+	nctx
+)
+
+// ctxOf returns the context following the rune r.
+func ctxOf(r rune) int {
+	switch {
+	case r == eof:
+		return ctxEOF
+	case r == '\n':
+		return ctxNewline
+	case isWord(r):
+		return ctxWord
+	default:
+		return ctxOther
+	}
+}
+
+type dstate struct {
+	key string
+	// pcs are the pcs of the VM's threads in priority order.
+	// Groups of threads with different start positions
+	// are separated by -1.
+	pcs []int
+	// ctx is the context of the previous rune.
+	ctx int
+	// search is whether new threads are started
+	// at each position; it is false once there is a match.
+	search bool
+
+	ascii *[128]*dtrans
+	other map[rune]*dtrans
+}
+
+// A dtrans is a transition from a state on a lookahead rune.
+// It computes the threads before the rune is consumed,
+// and then the threads after it is consumed.
+type dtrans struct {
+	// next is the state after consuming the rune,
+	// or nil if no threads remain.
+	next *dstate
+	// match is the index of the highest-priority group
+	// that matched before consuming the rune, or -1.
+	// If the state is searching, group len(groups)
+	// is the group started before consuming the rune.
+	match int
+	// id is the ID of the matching regexp.
+	id int
+	// keep[i] is the index of the group
+	// from which group i of next descends.
+	keep []int
+}
+
+// runDFA returns the match of the VM's regexp
+// with the start and end indices of only the full match
+// followed by the matching regexp ID,
+// or nil if there is no match.
+// The returned bool is false if the DFA gave up.
+// If the search is aborted, v.err is set.
+func runDFA(v *vm) ([]int64, bool) {
+	d := v.re.dfa
+	if d == nil {
+		return nil, false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.seen) < len(v.re.prog) {
+		d.seen = make([]uint32, len(v.re.prog))
+	}
+
+	var ms []int64
+	starts, next := d.starts[0][:0], d.starts[1][:0]
+	clears := 0
+	s := beginState(v, d)
+	for {
+		if aborted(v, len(s.pcs)) {
+			return nil, true
+		}
+		if s.search && len(s.pcs) == 0 && v.ro != nil {
+			if !seek(v) {
+				return nil, true
+			}
+			s = beginState(v, d)
+		}
+		if s.search {
+			starts = append(starts, v.at)
+		}
+		t := transition(v.re, d, s, v.n)
+		if d.size > maxDFASize {
+			if clears++; clears > maxDFAClears {
+				return nil, false
+			}
+			s = clearDFA(d, s)
+			t = transition(v.re, d, s, v.n)
+		}
+		if t.match >= 0 {
+			ms = append(ms[:0], starts[t.match], v.at, int64(t.id))
+		}
+		if v.lim >= 0 && v.at >= v.lim || v.n == eof || t.next == nil {
+			break
+		}
+		next = next[:0]
+		for _, k := range t.keep {
+			next = append(next, starts[k])
+		}
+		starts, next = next, starts
+		read(v)
+		s = t.next
+	}
+	d.starts[0], d.starts[1] = starts, next
+	return ms, true
+}
+
+func beginState(v *vm, d *dfa) *dstate {
+	ctx := ctxOf(v.c)
+	if d.begin[ctx] == nil {
+		d.begin[ctx] = intern(d, nil, ctx, true)
+	}
+	return d.begin[ctx]
+}
+
+// clearDFA clears the cache, and returns a new copy of s.
+func clearDFA(d *dfa, s *dstate) *dstate {
+	d.states = nil
+	d.size = 0
+	d.begin = [nctx]*dstate{}
+	return intern(d, s.pcs, s.ctx, s.search)
+}
+
+func intern(d *dfa, pcs []int, ctx int, search bool) *dstate {
+	key := make([]byte, 2, 2+len(pcs)*binary.MaxVarintLen64)
+	key[0] = byte(ctx)
+	if search {
+		key[1] = 1
+	}
+	var buf [binary.MaxVarintLen64]byte
+	for _, pc := range pcs {
+		n := binary.PutUvarint(buf[:], uint64(pc+1))
+		key = append(key, buf[:n]...)
+	}
+	if s, ok := d.states[string(key)]; ok {
+		return s
+	}
+	s := &dstate{
+		key:    string(key),
+		pcs:    append([]int{}, pcs...),
+		ctx:    ctx,
+		search: search,
+	}
+	if d.states == nil {
+		d.states = make(map[string]*dstate)
+	}
+	d.states[s.key] = s
+	d.size += 64 + len(s.key) + 8*len(s.pcs)
+	return s
+}
+
+// transition returns the transition from s on lookahead rune n.
+func transition(re *Regexp, d *dfa, s *dstate, n rune) *dtrans {
+	if n >= 0 && n < 128 {
+		if s.ascii != nil && s.ascii[n] != nil {
+			return s.ascii[n]
+		}
+	} else if t, ok := s.other[n]; ok {
+		return t
+	}
+
+	t := newTransition(re, d, s, n)
+	d.size += 64 + 8*len(t.keep)
+	if n >= 0 && n < 128 {
+		if s.ascii == nil {
+			s.ascii = new([128]*dtrans)
+			d.size += 128 * 8
+		}
+		s.ascii[n] = t
+	} else {
+		if s.other == nil {
+			s.other = make(map[rune]*dtrans)
+		}
+		s.other[n] = t
+		d.size += 32
+	}
+	return t
+}
+
+// newTransition computes a transition the same way that the VM
+// adds and steps threads at a position; see run.
+func newTransition(re *Regexp, d *dfa, s *dstate, n rune) *dtrans {
+	if d.gen++; d.gen == 0 {
+		for i := range d.seen {
+			d.seen[i] = 0
+		}
+		d.gen = 1
+	}
+	d.list, d.ends, d.cut = d.list[:0], d.ends[:0], false
+	groups := splitGroups(s.pcs)
+	if s.search {
+		groups = append(groups, []int{0})
+	}
+	t := &dtrans{match: -1}
+	for _, g := range groups {
+		d.match = false
+		for _, pc := range g {
+			closure(re, d, pc, s.ctx, n)
+		}
+		d.ends = append(d.ends, len(d.list))
+		if d.match {
+			// Later groups started after this match,
+			// so they cannot be part of the final match.
+			t.match, t.id = len(d.ends)-1, d.id
+			break
+		}
+	}
+	if n == eof {
+		return t
+	}
+
+	var next []int
+	var start int
+	for i, end := range d.ends {
+		nonempty := false
+		for _, pc := range d.list[start:end] {
+			if !accepts(re, re.prog[pc], n) {
+				continue
+			}
+			if !nonempty && len(next) > 0 {
+				next = append(next, -1)
+			}
+			nonempty = true
+			next = append(next, pc+1)
+		}
+		if nonempty {
+			t.keep = append(t.keep, i)
+		}
+		start = end
+	}
+	search := s.search && t.match < 0
+	if len(next) > 0 || search {
+		t.next = intern(d, next, ctxOf(n), search)
+	}
+	return t
+}
+
+func splitGroups(pcs []int) [][]int {
+	var groups [][]int
+	start := 0
+	for i, pc := range pcs {
+		if pc < 0 {
+			groups = append(groups, pcs[start:i])
+			start = i + 1
+		}
+	}
+	if start < len(pcs) {
+		groups = append(groups, pcs[start:])
+	}
+	return groups
+}
+
+// closure adds the consuming instructions reachable from pc
+// to d.list in priority order, like _add of the VM.
+func closure(re *Regexp, d *dfa, pc int, ctx int, n rune) {
+	if d.cut || d.seen[pc] == d.gen {
+		return
+	}
+	d.seen[pc] = d.gen
+	switch instr := re.prog[pc]; instr.op {
+	default:
+		d.list = append(d.list, pc)
+	case jmp:
+		closure(re, d, pc+instr.arg, ctx, n)
+	case fork:
+		closure(re, d, pc+1, ctx, n)
+		closure(re, d, pc+instr.arg, ctx, n)
+	case rfork:
+		closure(re, d, pc+instr.arg, ctx, n)
+		closure(re, d, pc+1, ctx, n)
+	case save:
+		closure(re, d, pc+1, ctx, n)
+	case bol:
+		if ctx == ctxEOF || ctx == ctxNewline {
+			closure(re, d, pc+1, ctx, n)
+		}
+	case eol:
+		if n == eof || n == '\n' {
+			closure(re, d, pc+1, ctx, n)
+		}
+	case wordb, nwordb:
+		if (ctx == ctxWord != isWord(n)) == (instr.op == wordb) {
+			closure(re, d, pc+1, ctx, n)
+		}
+	case match:
+		if !d.match {
+			d.match, d.id = true, instr.arg
+		}
+		d.cut = re.first
+	}
+}
@@ -0,0 +1,129 @@
+package re1
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/eaburns/T/rope"
+)
+
+// TestDFAGiveUp tests a regexp with exponentially many DFA states,
+// which fills the DFA cache, making it give up.
+func TestDFAGiveUp(t *testing.T) {
+	re, _, err := New("(a|b)*a(a|b){14}c", Opts{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	rand.Seed(0)
+	var s strings.Builder
+	for i := 0; i < 100000; i++ {
+		s.WriteByte("ab"[rand.Intn(2)])
+	}
+	s.WriteByte('c')
+	str := s.String()
+
+	ro := rope.New(str)
+	v := newVM(re, rope.NewReader(ro))
+	v.lim = ro.Len()
+	if _, ok := runDFA(v); ok {
+		t.Errorf("runDFA(%q)=_,true, want false", re.source)
+	}
+	want := re.Find(strings.NewReader(str))
+	if got := re.FindInRope(ro, 0, ro.Len()); !reflect.DeepEqual(got, want) {
+		t.Errorf("FindInRope(%q)=%v, want %v", re.source, got, want)
+	}
+}
+
+func TestDFAReverse(t *testing.T) {
+	re, _, err := New(`(\w+)\s+(\w+)`, Opts{Reverse: true})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ro := rope.New("abc def ghi")
+	want := []int64{4, 11, 4, 7, 8, 11, 0}
+	for i := 0; i < 2; i++ { // The second time uses the cached states.
+		if got := re.FindReverseInRope(ro, 0, ro.Len()); !reflect.DeepEqual(got, want) {
+			t.Errorf("FindReverseInRope(%q)=%v, want %v", re.source, got, want)
+		}
+	}
+}
+
+var benchRegexps = []string{
+	`\b(break|default|func|interface|select|case|defer|go|map|struct|chan|else|goto|package|switch|const|fallthrough|if|range|type|continue|for|import|return|var)\b`,
+	`/[*]([^*]|[*][^/])*[*]/|//.*`,
+	`"([^"\\]|\\.)*"`,
+	`'[^']'|'\\t'|'\\n'|'\\\\'|'\\''`,
+}
+
+func benchText() string {
+	const src = `// Package main is a benchmark.
+package main
+
+import "fmt"
+
+/* main prints stuff. */
+func main() {
+	for i := 0; i < 10; i++ {
+		if i%2 == 0 {
+			fmt.Println("even", i, '\n')
+		}
+	}
+}
+`
+	return strings.Repeat(src, 1000)
+}
+
+func BenchmarkFindInRopeDFA(b *testing.B) {
+	var res []*Regexp
+	for i, src := range benchRegexps {
+		re, _, err := New(src, Opts{ID: i})
+		if err != nil {
+			b.Fatalf("New failed: %v", err)
+		}
+		res = append(res, re)
+	}
+	re := Union(res...)
+	ro := rope.New(benchText())
+	b.SetBytes(ro.Len())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for at := int64(0); ; {
+			ms := re.FindInRope(ro, at, ro.Len())
+			if ms == nil {
+				break
+			}
+			if at = ms[1]; ms[0] == ms[1] {
+				at++
+			}
+		}
+	}
+}
+
+func BenchmarkFindInRopeNFA(b *testing.B) {
+	var res []*Regexp
+	for i, src := range benchRegexps {
+		re, _, err := New(src, Opts{ID: i})
+		if err != nil {
+			b.Fatalf("New failed: %v", err)
+		}
+		res = append(res, re)
+	}
+	re := Union(res...)
+	re.dfa = nil
+	ro := rope.New(benchText())
+	b.SetBytes(ro.Len())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for at := int64(0); ; {
+			ms := re.FindInRope(ro, at, ro.Len())
+			if ms == nil {
+				break
+			}
+			if at = ms[1]; ms[0] == ms[1] {
+				at++
+			}
+		}
+	}
+}
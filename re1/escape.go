@@ -6,7 +6,7 @@ import "strings"
 func Escape(t string) string {
 	var s strings.Builder
 	for _, r := range t {
-		if strings.ContainsRune(`|*+?.^$()[]\`, r) {
+		if strings.ContainsRune(`|*+?.^$()[]{}\`, r) {
 			s.WriteRune('\\')
 		}
 		s.WriteRune(r)
@@ -7,7 +7,7 @@ import (
 )
 
 func TestEscape(t *testing.T) {
-	const meta = `|*+?.^$()[]\`
+	const meta = `|*+?.^$()[]{}\`
 	str := meta + "abc" + meta + "abc"
 	re, residual, err := New(Escape(str), Opts{})
 	if err != nil || residual != "" {
@@ -0,0 +1,89 @@
+package re1
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/eaburns/T/rope"
+)
+
+const (
+	// maxPrefixRunes is the maximum number of runes in a literal prefix.
+	maxPrefixRunes = 8
+	// maxPrefixes is the maximum number of literal prefixes.
+	maxPrefixes = 16
+	// maxPrefixPaths bounds the work to find literal prefixes.
+	maxPrefixPaths = 256
+)
+
+// setPrefixes sets the literal prefixes of a regexp.
+func setPrefixes(re *Regexp) {
+	re.prefixes = literalPrefixes(re)
+	re.matcher = nil
+	if len(re.prefixes) > 1 {
+		re.matcher = rope.NewMatcher(re.prefixes...)
+	}
+}
+
+// literalPrefixes returns a set of non-empty literal strings,
+// one of which begins every match of the regexp.
+// If there is no such set, or it is too big to be useful,
+// literalPrefixes returns nil.
+func literalPrefixes(re *Regexp) []string {
+	type path struct {
+		pc     int
+		prefix string
+	}
+	seen := make(map[path]bool)
+	var prefixes []string
+	todo := []path{{pc: 0}}
+	for len(todo) > 0 {
+		p := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		if seen[p] {
+			continue
+		}
+		if seen[p] = true; len(seen) > maxPrefixPaths {
+			return nil
+		}
+		switch instr := re.prog[p.pc]; instr.op {
+		case jmp:
+			todo = append(todo, path{pc: p.pc + instr.arg, prefix: p.prefix})
+		case fork, rfork:
+			todo = append(todo,
+				path{pc: p.pc + 1, prefix: p.prefix},
+				path{pc: p.pc + instr.arg, prefix: p.prefix})
+		case save, bol, eol, wordb, nwordb:
+			// These consume no input,
+			// and the VM checks them when it runs.
+			todo = append(todo, path{pc: p.pc + 1, prefix: p.prefix})
+		case any, anynl, class, nclass, fold, match:
+			if p.prefix == "" {
+				return nil
+			}
+			prefixes = append(prefixes, p.prefix)
+		default:
+			prefix := p.prefix + string([]rune{rune(instr.op)})
+			if utf8.RuneCountInString(prefix) == maxPrefixRunes {
+				prefixes = append(prefixes, prefix)
+				break
+			}
+			todo = append(todo, path{pc: p.pc + 1, prefix: prefix})
+		}
+	}
+
+	// Remove prefixes that begin with another prefix.
+	sort.Strings(prefixes)
+	var short []string
+	for _, p := range prefixes {
+		if len(short) > 0 && strings.HasPrefix(p, short[len(short)-1]) {
+			continue
+		}
+		short = append(short, p)
+	}
+	if len(short) > maxPrefixes {
+		return nil
+	}
+	return short
+}
@@ -0,0 +1,72 @@
+package re1
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/eaburns/T/rope"
+)
+
+func TestLiteralPrefixes(t *testing.T) {
+	tests := []struct {
+		re   string
+		want []string
+	}{
+		{re: "", want: nil},
+		{re: ".", want: nil},
+		{re: "[abc]", want: nil},
+		{re: "a*", want: nil},
+		{re: "a?b", want: []string{"ab", "b"}},
+		{re: "abc", want: []string{"abc"}},
+		{re: "^abc$", want: []string{"abc"}},
+		{re: "(abc)", want: []string{"abc"}},
+		{re: "abc.*", want: []string{"abc"}},
+		{re: "ab[cd]", want: []string{"ab"}},
+		{re: "abc|xyz", want: []string{"abc", "xyz"}},
+		{re: "abc|ab", want: []string{"ab"}},
+		{re: "abc|.", want: nil},
+		{re: "a+", want: []string{"a"}},
+		{re: "☺☹", want: []string{"☺☹"}},
+		{re: "abcdefghijkl", want: []string{"abcdefgh"}},
+		{re: "a|b|c|d|e|f|g|h|i|j|k|l|m|n|o|p|q", want: nil},
+	}
+	for _, test := range tests {
+		re, _, err := New(test.re, Opts{})
+		if err != nil {
+			t.Errorf("New(%q, Opts{})=_,_,%v", test.re, err)
+			continue
+		}
+		if got := literalPrefixes(re); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("literalPrefixes(%q)=%q, want %q", test.re, got, test.want)
+		}
+	}
+}
+
+func BenchmarkFindInRopeLiteral(b *testing.B) {
+	ro := rope.New(strings.Repeat("Hello, 世界\n", 100000) + "needle")
+	re, _, err := New("needle", Opts{})
+	if err != nil {
+		b.Fatal(err.Error())
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if re.FindInRope(ro, 0, ro.Len()) == nil {
+			b.Fatal("no match")
+		}
+	}
+}
+
+func BenchmarkFindInRopeLiterals(b *testing.B) {
+	ro := rope.New(strings.Repeat("Hello, 世界\n", 100000) + "needle")
+	re, _, err := New("haystack|needle|pin", Opts{})
+	if err != nil {
+		b.Fatal(err.Error())
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if re.FindInRope(ro, 0, ro.Len()) == nil {
+			b.Fatal("no match")
+		}
+	}
+}
@@ -8,42 +8,104 @@
 // The grammar is:
 // 	regexp = choice.
 // 	choice = concat [ "|" choice ].
-// 	concat = repeat [ concat ].
-// 	repeat = term { "*" | "+" | "?" }.
-// 	term = "." | "^" | "$" | "(" regexp ")" | charclass | literal.
-// 	charclass = "[" [ "^" ] ( classlit [ "-" classlit ] ) { classlit [ "-" classlit ] } "]".
+// 	concat = { flags } repeat [ concat ].
+// 	flags = "(?" flagset ")".
+// 	flagset = { flag } [ "-" { flag } ].
+// 	repeat = term { ( "*" | "+" | "?" | count ) [ "?" ] }.
+// 	count = "{" num [ "," [ num ] ] "}".
+// 	term = "." | "^" | "$" | "(" [ "?" flagset ":" | "?P<" groupname ">" ] regexp ")" | charclass | classesc | literal.
+// 	charclass = "[" [ "^" ] classitem { classitem } "]".
+// 	classitem = classesc | classlit [ "-" classlit ].
+// 	classesc = "\w" | "\W" | "\d" | "\D" | "\s" | "\S" | "\p" name | "\P" name.
 // 	A literal is any non-meta rune or a rune preceded by \.
 // 	A classlit is any non-"]", non-"-" rune or a rune preceded by \.
+// 	A name is a single letter or a Unicode category or script name in {};
+// 	a name in {} beginning with ^ is negated, and {Any} is any rune.
+// 	A num is a decimal number no greater than 1000.
+// 	A flag is i or s.
+// 	A groupname is one or more letters, digits, or _.
+// 	A { that does not begin a valid count is a literal.
 //
 // The meta characters are:
 // 	| choice
 // 	* zero or more, greedy
 // 	+ one or more, greedy
 // 	? zero or one
+// 	{n} exactly n
+// 	{n,} n or more
+// 	{n,m} n to m inclusive
+// 	*?, +?, ??, {n,}?, {n,m}? non-greedy repetition
 // 	. any non-newline rune
 // 	^ beginning of file or line
 // 	$ end of file or line
 // 	() capturing group
+// 	(?P<name>) named capturing group
+// 	(?:) non-capturing group
+// 	(?flags) set flags until the end of the enclosing group;
+// 	   flags after a - are cleared
+// 	(?flags:) non-capturing group with flags set
 // 	[] character class (^ negates, - is a range)
 // 	\n newline
 // 	\t tab
+// 	\w word rune: a Unicode letter, digit, or _
+// 	\d Unicode decimal digit
+// 	\s Unicode white space
+// 	\W, \D, and \S are the negations of \w, \d, and \s
+// 	\pN, \p{Name} Unicode category or script (\P or \p{^Name} negates)
+// 	\b word boundary: between a \w rune and a non-\w rune,
+// 	   the beginning of file, or the end of file
+// 	\B not a word boundary
 // 	\ otherwise is the literal of the following rune
 // 	  or is \ itself if there is no following rune.
+//
+// The flags are:
+// 	i case-insensitive, using Unicode simple case folding (Opts.FoldCase)
+// 	s . matches newline (Opts.DotNewline)
+//
+// Matches are leftmost-longest:
+// of the matches beginning at the earliest position,
+// the longest is chosen.
+// However, an expression containing a non-greedy repetition
+// instead matches leftmost-first, as in Perl:
+// of the matches beginning at the earliest position,
+// the one preferred by the greedy or non-greedy repetitions
+// and by the left-most alternatives of choices is chosen.
 package re1
 
 import (
-	"errors"
+	"context"
 	"io"
 	"strings"
+	"unicode"
 	"unicode/utf8"
+
+	"github.com/eaburns/T/rope"
 )
 
 // Regexp is a compiled regular expression.
 type Regexp struct {
 	prog   []instr
 	ncap   int
-	class  [][][2]rune
+	class  []*charClass
 	source string
+	// names are the names of the capture groups, or nil if none are named.
+	// If non-nil, len(names) == ncap.
+	names []string
+
+	// first is whether the expression matches leftmost-first;
+	// it is set if there is a non-greedy repetition.
+	first bool
+
+	// prefixes are literal strings, one of which begins every match,
+	// or nil if there are none; see literalPrefixes.
+	// FindInRope uses them to skip to possible matches.
+	prefixes []string
+	// matcher, if non-nil, searches for the prefixes.
+	matcher *rope.Matcher
+
+	// dfa, if non-nil, is used by FindInRope and FindReverseInRope
+	// to find match boundaries before running the NFA.
+	dfa *dfa
 }
 
 // Opts are compile-time options. The zero value is default.
@@ -57,34 +119,98 @@ type Opts struct {
 	// This is used to distinguish which regexp matched
 	// when concatenating multiple regexps into one.
 	ID int
+	// FoldCase matches literals and character classes case-insensitively,
+	// as if the expression began with (?i).
+	FoldCase bool
+	// DotNewline makes . match newline,
+	// as if the expression began with (?s).
+	DotNewline bool
+}
+
+// ErrorKind is the kind of an Error.
+type ErrorKind int
+
+const (
+	// UnexpectedOp is a *, +, ?, or | missing its operand.
+	UnexpectedOp ErrorKind = iota + 1
+	// UnclosedGroup is a ( with no matching ).
+	UnclosedGroup
+	// UnopenedGroup is a ) with no matching (.
+	UnopenedGroup
+	// UnclosedClass is a [ with no matching ].
+	UnclosedClass
+	// EmptyClass is a character class with no runes.
+	EmptyClass
+	// BadRange is a malformed character class range.
+	BadRange
+	// BadUnicodeClass is a \p or \P with an unknown or malformed name.
+	BadUnicodeClass
+	// BadRepeat is a counted repetition with a bad or too-large count.
+	BadRepeat
+	// BadFlag is an unknown inline flag.
+	BadFlag
+	// BadGroupName is a missing or malformed capture group name.
+	BadGroupName
+)
+
+// An Error is an error parsing a regular expression.
+type Error struct {
+	// Kind is the kind of the error.
+	Kind ErrorKind
+	// Pos is the byte offset into the expression
+	// of the rune at which the error was detected.
+	Pos int
+	msg string
+}
+
+func (err Error) Error() string { return err.msg }
+
+// errorAt returns an Error for the rune n bytes from the end of the expression.
+// New converts this into an offset from the start of the expression.
+func errorAt(kind ErrorKind, n int, msg string) error {
+	return Error{Kind: kind, Pos: n, msg: msg}
 }
 
 // New compiles a regular expression.
 // The expression is terminated by the end of the string,
 // an un-escaped newline,
 // or an un-escaped delimiter (if set in opts).
+//
+// A parse error is returned as an Error.
 func New(t string, opts Opts) (*Regexp, string, error) {
 	src := t
 	switch re, t, err := choice(t, 0, opts); {
 	case err != nil:
+		if e, ok := err.(Error); ok {
+			e.Pos = len(src) - e.Pos
+			err = e
+		}
 		return nil, "", err
 	case re == nil:
 		re = &Regexp{}
 		fallthrough
 	default:
-		re = groupProg(re)
+		re = groupProg(re, "")
 		re.prog = append(re.prog, instr{op: match, arg: opts.ID})
 		re.source = src
+		if !opts.Reverse {
+			setPrefixes(re)
+		}
+		re.dfa = new(dfa)
 		return re, t, nil
 	}
 }
 
 const (
-	any = -iota
+	any   = -iota
+	anynl // any rune, including newline
 	bol
 	eol
+	wordb  // word boundary
+	nwordb // not a word boundary
 	class  // arg is class index
 	nclass // arg is class index
+	fold   // arg is a rune matched with case folding
 	jmp    // arg is jump offset
 	fork   // arg is low-priority fork offset (high-priority is 1)
 	rfork  // arg is high-priority fork offset (low-priority is 1)
@@ -98,13 +224,13 @@ type instr struct {
 }
 
 func choice(t0 string, depth int, opts Opts) (*Regexp, string, error) {
-	switch left, t, err := concat(t0, depth, opts); {
+	switch left, t, opts, err := concat(t0, depth, opts); {
 	case err != nil:
 		return nil, "", err
 	case peek(t) != '|':
 		return left, t, nil
 	case left == nil:
-		return nil, "", errors.New("unexpected |")
+		return nil, "", errorAt(UnexpectedOp, len(t), "unexpected |")
 	default:
 		_, t = next(t) // eat |
 		var right *Regexp
@@ -115,20 +241,61 @@ func choice(t0 string, depth int, opts Opts) (*Regexp, string, error) {
 	}
 }
 
-func concat(t string, depth int, opts Opts) (*Regexp, string, error) {
+// concat returns the opts as modified by any inline flags,
+// which remain set for the rest of the enclosing group.
+func concat(t string, depth int, opts Opts) (*Regexp, string, Opts, error) {
+	for strings.HasPrefix(t, "(?") && !strings.HasPrefix(t, "(?P") {
+		o, r, t1, err := flags(t[2:], opts)
+		switch {
+		case err != nil:
+			return nil, "", opts, err
+		case r == ')':
+			t, opts = t1, o
+			continue
+		}
+		break
+	}
 	left, t, err := repeat(t, depth, opts)
 	if left == nil || err != nil {
-		return left, t, err
+		return left, t, opts, err
 	}
 	var right *Regexp
-	switch right, t, err = concat(t, depth, opts); {
+	switch right, t, opts, err = concat(t, depth, opts); {
 	case err != nil:
-		return nil, "", err
+		return nil, "", opts, err
 	case right != nil:
 		left = catProg(left, right, opts.Reverse)
 		fallthrough
 	default:
-		return left, t, err
+		return left, t, opts, err
+	}
+}
+
+// flags parses a flagset following (?,
+// returning the modified opts and the rune following the flagset,
+// which is either ) or :.
+func flags(t0 string, opts Opts) (Opts, rune, string, error) {
+	neg := false
+	for t := t0; ; {
+		t1 := t
+		var r rune
+		switch r, t = next(t); r {
+		case 'i':
+			opts.FoldCase = !neg
+		case 's':
+			opts.DotNewline = !neg
+		case '-':
+			if neg {
+				return opts, 0, "", errorAt(BadFlag, len(t1), "bad flag -")
+			}
+			neg = true
+		case ')', ':':
+			return opts, r, t, nil
+		case eof:
+			return opts, 0, "", errorAt(UnclosedGroup, len(t0)+2, "unclosed (")
+		default:
+			return opts, 0, "", errorAt(BadFlag, len(t1), "bad flag "+string([]rune{r}))
+		}
 	}
 }
 
@@ -137,12 +304,91 @@ func repeat(t string, depth int, opts Opts) (*Regexp, string, error) {
 	if left == nil || err != nil {
 		return left, t, err
 	}
-	for strings.ContainsRune("*+?", peek(t)) {
-		var r rune
-		r, t = next(t)
-		left = repProg(left, r)
+	for {
+		t0 := t
+		var min, max int
+		switch r, t1 := next(t); {
+		case r == opts.Delimiter:
+			return left, t, nil
+		case r == '*':
+			min, max, t = 0, -1, t1
+		case r == '+':
+			min, max, t = 1, -1, t1
+		case r == '?':
+			min, max, t = 0, 1, t1
+		case r == '{':
+			var ok bool
+			if min, max, t1, ok = count(t1); !ok {
+				return left, t, nil
+			}
+			if min > maxRepeat || max > maxRepeat || max >= 0 && min > max {
+				return nil, "", errorAt(BadRepeat, len(t0), "bad repeat count")
+			}
+			t = t1
+		default:
+			return left, t, nil
+		}
+		lazy := false
+		if r := peek(t); r == '?' && r != opts.Delimiter {
+			_, t = next(t)
+			lazy = true
+		}
+		n := min
+		if max > min {
+			n = max
+		}
+		if n > 1 && n*len(left.prog) > maxProg {
+			return nil, "", errorAt(BadRepeat, len(t0), "repeat too large")
+		}
+		left = repProg(left, min, max, lazy)
+	}
+}
+
+const (
+	// maxRepeat is the maximum count of a counted repetition.
+	maxRepeat = 1000
+	// maxProg is the maximum number of instructions
+	// resulting from a counted repetition.
+	maxProg = 1 << 16
+)
+
+// count parses a counted repetition following its {,
+// returning the min and max counts;
+// max is -1 if there is no maximum.
+// The returned bool is false if t does not begin a count.
+func count(t string) (int, int, string, bool) {
+	min, t, ok := num(t)
+	if !ok {
+		return 0, 0, "", false
+	}
+	max := min
+	if peek(t) == ',' {
+		_, t = next(t)
+		max = -1
+		if peek(t) != '}' {
+			if max, t, ok = num(t); !ok {
+				return 0, 0, "", false
+			}
+		}
+	}
+	if peek(t) != '}' {
+		return 0, 0, "", false
+	}
+	_, t = next(t)
+	return min, max, t, true
+}
+
+// num parses a decimal number.
+// Numbers greater than maxRepeat are returned as maxRepeat+1.
+func num(t string) (int, string, bool) {
+	var n, i int
+	for i < len(t) && '0' <= t[i] && t[i] <= '9' {
+		if n = n*10 + int(t[i]-'0'); n > maxRepeat {
+			n = maxRepeat + 1
+		}
+		i++
 	}
-	return left, t, nil
+	return n, t[i:], i > 0
 }
 
 func term(t0 string, depth int, opts Opts) (*Regexp, string, error) {
@@ -150,11 +396,33 @@ func term(t0 string, depth int, opts Opts) (*Regexp, string, error) {
 	case eof, '\n', opts.Delimiter:
 		return nil, t, nil
 	case '\\':
+		switch e, t1 := next(t); e {
+		case 'b':
+			return opProg(wordb), t1, nil
+		case 'B':
+			return opProg(nwordb), t1, nil
+		case 'w', 'W', 'd', 'D', 's', 'S', 'p', 'P':
+			cl, neg, t, err := classEsc(t0, t)
+			if err != nil {
+				return nil, "", err
+			}
+			op := class
+			if neg {
+				op = nclass
+			}
+			return charClassProg(op, foldClass(cl, opts)), t, nil
+		}
 		r, t = esc(t)
 		fallthrough
 	default:
+		if opts.FoldCase && unicode.SimpleFold(r) != r {
+			return &Regexp{prog: []instr{{op: fold, arg: int(r)}}}, t, nil
+		}
 		return opProg(int(r)), t, nil
 	case '.':
+		if opts.DotNewline {
+			return opProg(anynl), t, nil
+		}
 		return opProg(any), t, nil
 	case '^':
 		if opts.Reverse {
@@ -169,78 +437,250 @@ func term(t0 string, depth int, opts Opts) (*Regexp, string, error) {
 	case '(':
 		return group(t, depth, opts)
 	case '[':
-		return charclass(t)
+		return charclass(t, opts)
 	case '|':
 		return nil, t0, nil
 	case ')':
 		if depth == 0 {
-			return nil, t, errors.New("unopened )")
+			return nil, t, errorAt(UnopenedGroup, len(t0), "unopened )")
 		}
 		return nil, t0, nil
 	case '*', '+', '?':
-		return nil, "", errors.New("unexpected " + string([]rune{r}))
+		return nil, "", errorAt(UnexpectedOp, len(t0), "unexpected "+string([]rune{r}))
 	}
 }
 
-func group(t string, depth int, opts Opts) (*Regexp, string, error) {
+func group(t0 string, depth int, opts Opts) (*Regexp, string, error) {
+	t, capture, name := t0, true, ""
+	if strings.HasPrefix(t, "?P") {
+		i := strings.IndexRune(t, '>')
+		if !strings.HasPrefix(t, "?P<") || i < 0 || !isGroupName(t[3:i]) {
+			return nil, "", errorAt(BadGroupName, len(t0)+1, "bad group name")
+		}
+		name, t = t[3:i], t[i+1:]
+	} else if strings.HasPrefix(t, "?") {
+		o, r, t1, err := flags(t[1:], opts)
+		if err != nil {
+			return nil, "", err
+		}
+		if r != ':' {
+			// Flags ending in ) are handled by concat.
+			panic("impossible")
+		}
+		t, opts, capture = t1, o, false
+	}
 	left, t, err := choice(t, depth+1, opts)
 	switch r, t := next(t); {
 	case err != nil:
 		return nil, "", err
 	case r != ')':
-		return nil, "", errors.New("unclosed (")
+		return nil, "", errorAt(UnclosedGroup, len(t0)+1, "unclosed (")
 	case left == nil:
 		left = &Regexp{}
 		fallthrough
+	case !capture:
+		return left, t, nil
 	default:
-		return groupProg(left), t, nil
+		return groupProg(left, name), t, nil
 	}
 }
 
-func charclass(t string) (*Regexp, string, error) {
+// isGroupName returns whether s is a valid capture group name:
+// one or more letters, digits, or _.
+func isGroupName(s string) bool {
+	for _, r := range s {
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func charclass(t string, opts Opts) (*Regexp, string, error) {
+	open := len(t) + 1 // the [
 	op := class
 	if peek(t) == '^' {
 		_, t = next(t) // eat ^
 		op = nclass
 	}
 	var r, p rune
-	var cl [][2]rune
+	cl := &charClass{}
 	for len(t) > 0 {
+		t0 := t
 		switch r, t = next(t); r {
 		case ']':
 			if p != 0 {
-				cl = append(cl, [2]rune{p, p})
+				cl.ranges = append(cl.ranges, [2]rune{p, p})
 			}
-			if len(cl) == 0 {
-				return nil, "", errors.New("empty charclass")
+			if len(cl.ranges) == 0 && len(cl.tables) == 0 && len(cl.not) == 0 {
+				return nil, "", errorAt(EmptyClass, open, "empty charclass")
 			}
-			return charClassProg(op, cl), t, nil
+			return charClassProg(op, foldClass(cl, opts)), t, nil
 		case '-':
+			dash := len(t) + 1
 			if p == 0 || peek(t) == ']' || peek(t) == '-' {
-				return nil, "", errors.New("bad range")
+				return nil, "", errorAt(BadRange, dash, "bad range")
 			}
 			r, t = next(t)
 			if r == '\\' {
+				if isClassEsc(peek(t)) {
+					return nil, "", errorAt(BadRange, dash, "bad range")
+				}
 				r, t = esc(t)
 			}
 			if p >= r {
-				return nil, "", errors.New("bad range")
+				return nil, "", errorAt(BadRange, dash, "bad range")
 			}
-			cl = append(cl, [2]rune{p, r})
+			cl.ranges = append(cl.ranges, [2]rune{p, r})
 			p = 0
 		case '\\':
+			if isClassEsc(peek(t)) {
+				if p != 0 {
+					cl.ranges = append(cl.ranges, [2]rune{p, p})
+					p = 0
+				}
+				var esc *charClass
+				var neg bool
+				var err error
+				if esc, neg, t, err = classEsc(t0, t); err != nil {
+					return nil, "", err
+				}
+				if neg {
+					cl.not = append(cl.not, esc)
+				} else {
+					cl.merge(esc)
+				}
+				continue
+			}
 			r, t = esc(t)
 			fallthrough
 		default:
 			if p != 0 {
-				cl = append(cl, [2]rune{p, p})
+				cl.ranges = append(cl.ranges, [2]rune{p, p})
 			}
 			p = r
 		}
 	}
-	return nil, "", errors.New("unclosed [")
+	return nil, "", errorAt(UnclosedClass, open, "unclosed [")
 }
 
+func isClassEsc(r rune) bool { return strings.ContainsRune("wWdDsSpP", r) }
+
+// classEsc parses a class escape;
+// t0 begins with the \, and t follows it.
+// The returned bool is whether the class is negated.
+func classEsc(t0, t string) (*charClass, bool, string, error) {
+	r, t := next(t)
+	switch r {
+	case 'w', 'W':
+		return wordClass, r == 'W', t, nil
+	case 'd', 'D':
+		return &charClass{tables: []*unicode.RangeTable{unicode.Nd}}, r == 'D', t, nil
+	case 's', 'S':
+		return &charClass{tables: []*unicode.RangeTable{unicode.White_Space}}, r == 'S', t, nil
+	}
+	neg := r == 'P'
+	var name string
+	switch c, t1 := next(t); {
+	case c == '{':
+		i := strings.IndexRune(t1, '}')
+		if i < 0 {
+			return nil, false, "", errorAt(BadUnicodeClass, len(t0), "bad Unicode class")
+		}
+		name, t = t1[:i], t1[i+1:]
+	case unicode.IsLetter(c):
+		name, t = string([]rune{c}), t1
+	default:
+		return nil, false, "", errorAt(BadUnicodeClass, len(t0), "bad Unicode class")
+	}
+	if strings.HasPrefix(name, "^") {
+		name = name[1:]
+		neg = !neg
+	}
+	if name == "Any" {
+		return &charClass{ranges: [][2]rune{{0, unicode.MaxRune}}}, neg, t, nil
+	}
+	tab, ok := unicode.Categories[name]
+	if !ok {
+		if tab, ok = unicode.Scripts[name]; !ok {
+			return nil, false, "", errorAt(BadUnicodeClass, len(t0), "unknown Unicode class "+name)
+		}
+	}
+	return &charClass{tables: []*unicode.RangeTable{tab}}, neg, t, nil
+}
+
+// A charClass is a set of runes.
+type charClass struct {
+	// ranges are inclusive ranges of runes in the class.
+	ranges [][2]rune
+	// tables are Unicode tables of runes in the class.
+	tables []*unicode.RangeTable
+	// not are classes with complements that are in the class.
+	not []*charClass
+	// fold is whether the class contains
+	// the simple case foldings of its runes.
+	fold bool
+}
+
+// foldClass returns the class with case folding
+// if opts.FoldCase is set, otherwise cl.
+func foldClass(cl *charClass, opts Opts) *charClass {
+	if !opts.FoldCase {
+		return cl
+	}
+	c := *cl
+	c.fold = true
+	return &c
+}
+
+// wordClass is the class of \w runes.
+var wordClass = &charClass{
+	ranges: [][2]rune{{'_', '_'}},
+	tables: []*unicode.RangeTable{unicode.Letter, unicode.Nd},
+}
+
+func (cl *charClass) merge(x *charClass) {
+	cl.ranges = append(cl.ranges, x.ranges...)
+	cl.tables = append(cl.tables, x.tables...)
+	cl.not = append(cl.not, x.not...)
+}
+
+func (cl *charClass) contains(r rune) bool {
+	if cl.has(r) {
+		return true
+	}
+	if cl.fold {
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if cl.has(f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (cl *charClass) has(r rune) bool {
+	for _, rng := range cl.ranges {
+		if rng[0] <= r && r <= rng[1] {
+			return true
+		}
+	}
+	for _, tab := range cl.tables {
+		if unicode.Is(tab, r) {
+			return true
+		}
+	}
+	for _, not := range cl.not {
+		if !not.contains(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// isWord returns whether r is a \w rune.
+func isWord(r rune) bool { return r != eof && wordClass.contains(r) }
+
 func choiceProg(left, right *Regexp) *Regexp {
 	prog := make([]instr, 0, 2+len(left.prog)+len(right.prog))
 	prog = append(prog, instr{op: fork, arg: len(left.prog) + 2})
@@ -265,29 +705,65 @@ func catProg(left, right *Regexp, rev bool) *Regexp {
 		}
 		left.prog = append(left.prog, instr)
 	}
+	if left.names != nil || right.names != nil {
+		left.names = append(groupNames(left), groupNames(right)...)
+	}
 	left.ncap += right.ncap
 	left.class = append(left.class, right.class...)
+	left.first = left.first || right.first
 	return left
 }
 
-func repProg(left *Regexp, op rune) *Regexp {
-	prog := make([]instr, 0, len(left.prog)+2)
-	switch op {
-	case '+':
-		prog = append(left.prog, instr{op: rfork, arg: -len(left.prog)})
-	case '*':
-		prog = []instr{{op: fork, arg: len(left.prog) + 2}}
-		prog = append(prog, left.prog...)
-		prog = append(prog, instr{op: rfork, arg: -len(prog) + 1})
-	case '?':
-		prog = []instr{{op: fork, arg: len(left.prog) + 1}}
-		prog = append(prog, left.prog...)
+// repProg returns left repeated from min to max times;
+// max is -1 for no maximum.
+// Each repetition uses the same capture groups.
+func repProg(left *Regexp, min, max int, lazy bool) *Regexp {
+	// enter is the op of a fork that prefers to enter
+	// another repetition over skipping to arg,
+	// and loop is the op of a fork that prefers to loop back to arg.
+	enter, loop := fork, rfork
+	if lazy {
+		enter, loop = rfork, fork
+		left.first = true
+	}
+	x := left.prog
+	n := min
+	if max < 0 && min > 0 {
+		n-- // the last is repeated with a loop
+	}
+	var prog []instr
+	for i := 0; i < n; i++ {
+		prog = append(prog, x...)
+	}
+	switch {
+	case max < 0 && min > 0:
+		prog = append(prog, x...)
+		prog = append(prog, instr{op: loop, arg: -len(x)})
+	case max < 0:
+		prog = append(prog, instr{op: enter, arg: len(x) + 2})
+		prog = append(prog, x...)
+		prog = append(prog, instr{op: loop, arg: -len(x)})
+	default:
+		end := len(prog) + (max-min)*(len(x)+1)
+		for i := min; i < max; i++ {
+			prog = append(prog, instr{op: enter, arg: end - len(prog)})
+			prog = append(prog, x...)
+		}
 	}
 	left.prog = prog
 	return left
 }
 
-func groupProg(left *Regexp) *Regexp {
+// groupNames returns the names of the capture groups,
+// with "" for each unnamed group.
+func groupNames(re *Regexp) []string {
+	if re.names != nil {
+		return re.names
+	}
+	return make([]string, re.ncap)
+}
+
+func groupProg(left *Regexp, name string) *Regexp {
 	prog := make([]instr, 0, len(left.prog)+2)
 	prog = append(prog, instr{op: save, arg: 0})
 	for _, instr := range left.prog {
@@ -297,12 +773,15 @@ func groupProg(left *Regexp) *Regexp {
 		prog = append(prog, instr)
 	}
 	left.prog = append(prog, instr{op: save, arg: 1})
+	if left.names != nil || name != "" {
+		left.names = append([]string{name}, groupNames(left)...)
+	}
 	left.ncap++
 	return left
 }
 
-func charClassProg(op int, cl [][2]rune) *Regexp {
-	return &Regexp{prog: []instr{{op: op}}, class: [][][2]rune{cl}}
+func charClassProg(op int, cl *charClass) *Regexp {
+	return &Regexp{prog: []instr{{op: op}}, class: []*charClass{cl}}
 }
 
 func opProg(op int) *Regexp { return &Regexp{prog: []instr{{op: op}}} }
@@ -335,18 +814,58 @@ func esc(t string) (rune, string) {
 	return r, t
 }
 
+// NumCap returns the number of capture groups,
+// including group 0, the full match.
+// A match has 2*NumCap()+1 elements:
+// the start and end of each group followed by the regexp ID.
+func (re *Regexp) NumCap() int { return re.ncap }
+
+// SubexpNames returns the names of the capture groups;
+// element i is the name of group i, or "" if group i is unnamed.
+// Group 0, the full match, is always unnamed.
+func (re *Regexp) SubexpNames() []string {
+	return append([]string{}, groupNames(re)...)
+}
+
+// SubexpIndex returns the number of the left-most capture group
+// with the given name, or -1 if there is none.
+func (re *Regexp) SubexpIndex(name string) int {
+	for i, n := range re.names {
+		if name != "" && n == name {
+			return i
+		}
+	}
+	return -1
+}
+
 // Find returns nil on no match or a slice with pairs of int64s for each sub-expression match (0 is the full match) and the last element is the matching regexp ID.
 func (re *Regexp) Find(rr io.RuneReader) []int64 { return run(newVM(re, rr)) }
 
 type vm struct {
 	re        *Regexp
 	rr        io.RuneReader
+	ro        rope.Rope // if non-nil, the rope read by rr; see seek
 	at, lim   int64
 	c, n      rune    // cur and next rune.
 	seen      []int64 // at for which each pc was last add()ed.
 	cur, next []thread
 	free      [][]int64
 	match     []int64
+
+	// anchor is whether threads are only started
+	// at the initial position.
+	anchor bool
+
+	// cut is set when a leftmost-first match is found
+	// to drop the lower-priority threads of the current step.
+	cut bool
+
+	// ctx, if non-nil, aborts the search when it is done;
+	// steps counts down the work until it is next checked,
+	// and err is set to ErrAborted if the search was aborted.
+	ctx   context.Context
+	steps int
+	err   error
 }
 
 type thread struct {
@@ -381,8 +900,14 @@ func newMem(v *vm, init []int64) (m []int64) {
 }
 
 func run(v *vm) []int64 {
-	for {
-		if v.match == nil {
+	for start := true; ; start = false {
+		if aborted(v, len(v.next)) {
+			return nil
+		}
+		if v.match == nil && (start || !v.anchor) {
+			if len(v.next) == 0 && v.ro != nil && !seek(v) {
+				return nil
+			}
 			add(v, 0, newMem(v, nil))
 		}
 		if v.lim >= 0 && v.at >= v.lim {
@@ -391,6 +916,7 @@ func run(v *vm) []int64 {
 		}
 		read(v)
 		v.cur, v.next = v.next, v.cur[:0]
+		v.cut = false
 		for _, t := range v.cur {
 			step(v, t.pc, t.mem)
 		}
@@ -400,6 +926,26 @@ func run(v *vm) []int64 {
 	}
 }
 
+// abortSteps is the number of steps between checks of a VM's context.
+const abortSteps = 1 << 12
+
+// aborted returns whether the VM's search is aborted,
+// counting n+1 steps of work since the previous call.
+// The context is checked on the first call
+// and then after every abortSteps steps.
+func aborted(v *vm, n int) bool {
+	if v.ctx == nil || v.err != nil {
+		return v.err != nil
+	}
+	if v.steps -= n + 1; v.steps <= 0 {
+		v.steps = abortSteps
+		if v.ctx.Err() != nil {
+			v.err = ErrAborted
+		}
+	}
+	return v.err != nil
+}
+
 func read(v *vm) {
 	if v.n != eof {
 		v.at += int64(utf8.RuneLen(v.n))
@@ -412,39 +958,43 @@ func read(v *vm) {
 }
 
 func step(v *vm, pc int, mem []int64) {
-	if !accepts(v, v.re.prog[pc]) {
+	if !accepts(v.re, v.re.prog[pc], v.c) {
 		v.free = append(v.free, mem)
 		return
 	}
 	add(v, pc+1, mem)
 }
 
-func accepts(v *vm, instr instr) bool {
+func accepts(re *Regexp, instr instr, c rune) bool {
 	switch instr.op {
 	case any:
-		return v.c != '\n' && v.c != eof
+		return c != '\n' && c != eof
+	case anynl:
+		return c != eof
 	case class, nclass:
-		cl := v.re.class[instr.arg]
-		return classAccepts(v.c, cl, instr.op == nclass)
+		return c != eof && re.class[instr.arg].contains(c) == (instr.op == class)
+	case fold:
+		return equalFold(c, rune(instr.arg))
 	default:
-		return int(v.c) == instr.op
+		return int(c) == instr.op
 	}
 }
 
-func classAccepts(r rune, class [][2]rune, neg bool) bool {
-	if r == eof {
-		return false
+// equalFold returns whether r and s are equal under simple case folding.
+func equalFold(r, s rune) bool {
+	if r == s {
+		return true
 	}
-	for _, c := range class {
-		if c[0] <= r && r <= c[1] {
-			return !neg
+	for f := unicode.SimpleFold(s); f != s; f = unicode.SimpleFold(f) {
+		if f == r {
+			return true
 		}
 	}
-	return neg
+	return false
 }
 
 func add(v *vm, pc int, mem []int64) {
-	if v.seen[pc] == v.at {
+	if v.cut || v.seen[pc] == v.at {
 		v.free = append(v.free, mem)
 		return
 	}
@@ -481,6 +1031,12 @@ func _add(v *vm, pc int, mem []int64) {
 			return
 		}
 		add(v, pc+1, mem)
+	case wordb, nwordb:
+		if (isWord(v.c) != isWord(v.n)) != (instr.op == wordb) {
+			v.free = append(v.free, mem)
+			return
+		}
+		add(v, pc+1, mem)
 	case match:
 		mem[len(mem)-1] = int64(instr.arg)
 		setMatch(v, mem)
@@ -491,6 +1047,14 @@ func setMatch(v *vm, mem []int64) {
 	switch {
 	case v.match == nil:
 		v.match = mem
+		v.cut = v.re.first
+	case v.re.first:
+		// Threads are added in priority order,
+		// and lower-priority threads are cut,
+		// so the new match has higher priority.
+		v.free = append(v.free, v.match)
+		v.match = mem
+		v.cut = true
 	case mem[0] <= v.match[0] && mem[1] > v.match[1]:
 		v.free = append(v.free, v.match)
 		v.match = mem
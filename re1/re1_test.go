@@ -30,6 +30,16 @@ func TestParse(t *testing.T) {
 			re:    "ab\\/c",
 			delim: '/',
 		},
+		{
+			re:      "ab?c",
+			delim:   '?',
+			residue: "c",
+		},
+		{
+			re:      "ab*c",
+			delim:   '*',
+			residue: "c",
+		},
 		{
 			re:      "ab\nc", // literal newline
 			residue: "c",
@@ -138,8 +148,112 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		re   string
+		want Error
+	}{
+		{re: "*", want: Error{Kind: UnexpectedOp, Pos: 0}},
+		{re: "a|b(+)", want: Error{Kind: UnexpectedOp, Pos: 4}},
+		{re: "ab||c", want: Error{Kind: UnexpectedOp, Pos: 3}},
+		{re: "a(b(c)", want: Error{Kind: UnclosedGroup, Pos: 1}},
+		{re: "a(b)c)", want: Error{Kind: UnopenedGroup, Pos: 5}},
+		{re: "xyz[abc", want: Error{Kind: UnclosedClass, Pos: 3}},
+		{re: "☺[]", want: Error{Kind: EmptyClass, Pos: 3}},
+		{re: "[-z]", want: Error{Kind: BadRange, Pos: 1}},
+		{re: "[a-z-Z]", want: Error{Kind: BadRange, Pos: 4}},
+		{re: "[z-a]", want: Error{Kind: BadRange, Pos: 2}},
+		{re: "[a-\\w]", want: Error{Kind: BadRange, Pos: 2}},
+		{re: "ab\\p", want: Error{Kind: BadUnicodeClass, Pos: 2}},
+		{re: "ab\\p{L", want: Error{Kind: BadUnicodeClass, Pos: 2}},
+		{re: "ab[x\\P{NoSuchClass}]", want: Error{Kind: BadUnicodeClass, Pos: 4}},
+		{re: "ab{2,1}", want: Error{Kind: BadRepeat, Pos: 2}},
+		{re: "ab{1001}", want: Error{Kind: BadRepeat, Pos: 2}},
+		{re: "ab{1,99999999999999999999}", want: Error{Kind: BadRepeat, Pos: 2}},
+		{re: "(a{1000}){1000}", want: Error{Kind: BadRepeat, Pos: 9}},
+		{re: "a(?:b", want: Error{Kind: UnclosedGroup, Pos: 1}},
+		{re: "a(?i", want: Error{Kind: UnclosedGroup, Pos: 1}},
+		{re: "a(?x)", want: Error{Kind: BadFlag, Pos: 3}},
+		{re: "a(?i-s-i)", want: Error{Kind: BadFlag, Pos: 6}},
+		{re: "a(?i)*", want: Error{Kind: UnexpectedOp, Pos: 5}},
+		{re: "a(?P<>b)", want: Error{Kind: BadGroupName, Pos: 1}},
+		{re: "a(?P<x", want: Error{Kind: BadGroupName, Pos: 1}},
+		{re: "a(?Px>b)", want: Error{Kind: BadGroupName, Pos: 1}},
+		{re: "a(?P<x y>b)", want: Error{Kind: BadGroupName, Pos: 1}},
+		{re: "a(?P<x>b", want: Error{Kind: UnclosedGroup, Pos: 1}},
+	}
+	for _, test := range tests {
+		_, _, err := New(test.re, Opts{})
+		e, ok := err.(Error)
+		if !ok {
+			t.Errorf("New(%q, Opts{})=_,_,%#v, want an Error", test.re, err)
+			continue
+		}
+		if e.Kind != test.want.Kind || e.Pos != test.want.Pos {
+			t.Errorf("New(%q, Opts{})=_,_,{Kind: %d, Pos: %d}, want {Kind: %d, Pos: %d}",
+				test.re, e.Kind, e.Pos, test.want.Kind, test.want.Pos)
+		}
+	}
+}
+
+func TestSubexpNames(t *testing.T) {
+	tests := []struct {
+		res   []string
+		names []string
+	}{
+		{res: []string{""}, names: []string{""}},
+		{res: []string{"(a)(b)"}, names: []string{"", "", ""}},
+		{res: []string{"(?P<x>a)"}, names: []string{"", "x"}},
+		{res: []string{"(?P<x>a)(b)(?P<y_1>c)"}, names: []string{"", "x", "", "y_1"}},
+		{res: []string{"(?P<x>a(?P<y>b))|(c)"}, names: []string{"", "x", "y", ""}},
+		{res: []string{"(?P<x>a)*(?:(?P<y>b))+"}, names: []string{"", "x", "y"}},
+		{res: []string{"(?P<名前>a)"}, names: []string{"", "名前"}},
+		{res: []string{"(a)", "(?P<x>b)(?P<y>c)"}, names: []string{"", "x", "y"}},
+		{res: []string{"(?P<x>a)", "(?P<y>b)(c)"}, names: []string{"", "x", ""}},
+	}
+	for _, test := range tests {
+		var res []*Regexp
+		for _, r := range test.res {
+			re, residual, err := New(r, Opts{})
+			if err != nil || residual != "" {
+				t.Fatalf("New(%q)=_,%q,%v", r, residual, err)
+			}
+			res = append(res, re)
+		}
+		re := Union(res...)
+		if n := re.NumCap(); n != len(test.names) {
+			t.Errorf("Union(%q).NumCap()=%d, want %d", test.res, n, len(test.names))
+		}
+		if names := re.SubexpNames(); !reflect.DeepEqual(names, test.names) {
+			t.Errorf("Union(%q).SubexpNames()=%q, want %q", test.res, names, test.names)
+		}
+		for i, name := range test.names {
+			if name == "" {
+				continue
+			}
+			if j := re.SubexpIndex(name); j != i {
+				t.Errorf("Union(%q).SubexpIndex(%q)=%d, want %d", test.res, name, j, i)
+			}
+		}
+		if j := re.SubexpIndex(""); j != -1 {
+			t.Errorf("Union(%q).SubexpIndex(\"\")=%d, want -1", test.res, j)
+		}
+		if j := re.SubexpIndex("none"); j != -1 {
+			t.Errorf("Union(%q).SubexpIndex(\"none\")=%d, want -1", test.res, j)
+		}
+	}
+}
+
 // These tests are pretty incomplete, because we rely on the RE2 suite instead.
 var findTests = []findTest{
+	{
+		re: "(?P<first>a+)(?P<last>b+)",
+		cases: []findTestCase{
+			{str: "aabbb", want: []string{"aabbb", "aa", "bbb"}},
+			{str: "xab", want: []string{"ab", "a", "b"}},
+			{str: "bba", want: nil},
+		},
+	},
 	{
 		re: "",
 		cases: []findTestCase{
@@ -185,6 +299,324 @@ var findTests = []findTest{
 			{str: "***", want: []string{"*"}},
 		},
 	},
+	{
+		re: `\w+`,
+		cases: []findTestCase{
+			{str: "", want: nil},
+			{str: " \t\n", want: nil},
+			{str: "  abc_123  ", want: []string{"abc_123"}},
+			{str: "--αβγ٣--", want: []string{"αβγ٣"}},
+		},
+	},
+	{
+		re: `\W+`,
+		cases: []findTestCase{
+			{str: "abc", want: nil},
+			{str: "abc -+ xyz", want: []string{" -+ "}},
+		},
+	},
+	{
+		re: `\d+`,
+		cases: []findTestCase{
+			{str: "abc", want: nil},
+			{str: "abc123xyz", want: []string{"123"}},
+			{str: "abc١٢٣xyz", want: []string{"١٢٣"}},
+		},
+	},
+	{
+		re: `\D+`,
+		cases: []findTestCase{
+			{str: "123", want: nil},
+			{str: "123abc456", want: []string{"abc"}},
+		},
+	},
+	{
+		re: `\s+`,
+		cases: []findTestCase{
+			{str: "abc", want: nil},
+			{str: "abc \t\n\u00a0xyz", want: []string{" \t\n\u00a0"}},
+		},
+	},
+	{
+		re: `\S+`,
+		cases: []findTestCase{
+			{str: "  ", want: nil},
+			{str: "  abc  ", want: []string{"abc"}},
+		},
+	},
+	{
+		re: `[\d\s]+`,
+		cases: []findTestCase{
+			{str: "abc1 2 3xyz", want: []string{"1 2 3"}},
+		},
+	},
+	{
+		re: `[^\d\s]+`,
+		cases: []findTestCase{
+			{str: "1 abc 2", want: []string{"abc"}},
+		},
+	},
+	{
+		re: `[x\D]+`,
+		cases: []findTestCase{
+			{str: "123abc456", want: []string{"abc"}},
+		},
+	},
+	{
+		re: `[^\S\n]+`,
+		cases: []findTestCase{
+			{str: "abc\n \txyz", want: []string{" \t"}},
+		},
+	},
+	{
+		re: `\pL+`,
+		cases: []findTestCase{
+			{str: "123", want: nil},
+			{str: "123αβγ456", want: []string{"αβγ"}},
+		},
+	},
+	{
+		re: `\p{Lu}+`,
+		cases: []findTestCase{
+			{str: "abcXYZ", want: []string{"XYZ"}},
+		},
+	},
+	{
+		re: `\P{Lu}+`,
+		cases: []findTestCase{
+			{str: "ABCxyzABC", want: []string{"xyz"}},
+		},
+	},
+	{
+		re: `\p{Greek}+`,
+		cases: []findTestCase{
+			{str: "abcαβγxyz", want: []string{"αβγ"}},
+		},
+	},
+	{
+		re: `[\p{Greek}\p{Nd}]+`,
+		cases: []findTestCase{
+			{str: "abcαβγ123xyz", want: []string{"αβγ123"}},
+		},
+	},
+	{
+		re: `a{3}`,
+		cases: []findTestCase{
+			{str: "aa", want: nil},
+			{str: "aaa", want: []string{"aaa"}},
+			{str: "aaaaa", want: []string{"aaa"}},
+		},
+	},
+	{
+		re: `a{2,}`,
+		cases: []findTestCase{
+			{str: "a", want: nil},
+			{str: "aa", want: []string{"aa"}},
+			{str: "aaaaa", want: []string{"aaaaa"}},
+		},
+	},
+	{
+		re: `a{0,}`,
+		cases: []findTestCase{
+			{str: "", want: []string{""}},
+			{str: "aaa", want: []string{"aaa"}},
+		},
+	},
+	{
+		re: `a{1,3}`,
+		cases: []findTestCase{
+			{str: "", want: nil},
+			{str: "a", want: []string{"a"}},
+			{str: "aaaaa", want: []string{"aaa"}},
+		},
+	},
+	{
+		re: `a{0}b`,
+		cases: []findTestCase{
+			{str: "aab", want: []string{"b"}},
+		},
+	},
+	{
+		re: `(ab){2}`,
+		cases: []findTestCase{
+			{str: "ababab", want: []string{"abab", "ab"}},
+		},
+	},
+	{
+		re: `[0-9]{3}-[0-9]{4}`,
+		cases: []findTestCase{
+			{str: "call 555-1234 now", want: []string{"555-1234"}},
+			{str: "call 55-1234 now", want: nil},
+		},
+	},
+	{
+		// A { that doesn't begin a count is a literal.
+		re: `a{,2}|b{x}|c{`,
+		cases: []findTestCase{
+			{str: "a{,2}", want: []string{"a{,2}"}},
+			{str: "b{x}", want: []string{"b{x}"}},
+			{str: "c{", want: []string{"c{"}},
+		},
+	},
+	{
+		re: `a*?`,
+		cases: []findTestCase{
+			{str: "aaa", want: []string{""}},
+		},
+	},
+	{
+		re: `a+?`,
+		cases: []findTestCase{
+			{str: "aaa", want: []string{"a"}},
+		},
+	},
+	{
+		re: `a??`,
+		cases: []findTestCase{
+			{str: "aaa", want: []string{""}},
+		},
+	},
+	{
+		re: `a{2,}?`,
+		cases: []findTestCase{
+			{str: "aaaa", want: []string{"aa"}},
+		},
+	},
+	{
+		re: `a{1,3}?b`,
+		cases: []findTestCase{
+			{str: "aaaab", want: []string{"aaab"}},
+		},
+	},
+	{
+		re: `<.*?>`,
+		cases: []findTestCase{
+			{str: "<a><b>", want: []string{"<a>"}},
+			{str: "x<a", want: nil},
+		},
+	},
+	{
+		re: `<.*>`,
+		cases: []findTestCase{
+			{str: "<a><b>", want: []string{"<a><b>"}},
+		},
+	},
+	{
+		// A non-greedy expression prefers the left-most alternative.
+		re: `a|ab|x*?`,
+		cases: []findTestCase{
+			{str: "ab", want: []string{"a"}},
+		},
+	},
+	{
+		re: `(?:ab)+(c)`,
+		cases: []findTestCase{
+			{str: "ababc", want: []string{"ababc", "c"}},
+		},
+	},
+	{
+		re: `(?:)`,
+		cases: []findTestCase{
+			{str: "abc", want: []string{""}},
+		},
+	},
+	{
+		re: `(?i)straße`,
+		cases: []findTestCase{
+			{str: "STRASSE", want: nil},
+			{str: "STRAßE", want: []string{"STRAßE"}},
+			{str: "ſtraße", want: []string{"ſtraße"}},
+		},
+	},
+	{
+		re: `(?i)ΣΑΣ`,
+		cases: []findTestCase{
+			{str: "σας", want: []string{"σας"}},
+			{str: "σαΣ", want: []string{"σαΣ"}},
+		},
+	},
+	{
+		re: `a(?i)b|c`,
+		cases: []findTestCase{
+			{str: "AB", want: nil},
+			{str: "aB", want: []string{"aB"}},
+			{str: "C", want: []string{"C"}},
+		},
+	},
+	{
+		re: `a((?i)b)c`,
+		cases: []findTestCase{
+			{str: "aBc", want: []string{"aBc", "B"}},
+			{str: "aBC", want: nil},
+		},
+	},
+	{
+		re: `a(?i:b)c`,
+		cases: []findTestCase{
+			{str: "aBc", want: []string{"aBc"}},
+			{str: "aBC", want: nil},
+		},
+	},
+	{
+		re: `(?i)[^a-c]+`,
+		cases: []findTestCase{
+			{str: "ABCxyzABC", want: []string{"xyz"}},
+		},
+	},
+	{
+		re: `(?i)\p{Lu}+`,
+		cases: []findTestCase{
+			{str: "123abc123", want: []string{"abc"}},
+		},
+	},
+	{
+		re: `a.c`,
+		cases: []findTestCase{
+			{str: "a\nc", want: nil},
+		},
+	},
+	{
+		re: `(?s)a.c`,
+		cases: []findTestCase{
+			{str: "a\nc", want: []string{"a\nc"}},
+		},
+	},
+	{
+		re: `(?is)a.c|(?-s)x.z`,
+		cases: []findTestCase{
+			{str: "A\nC", want: []string{"A\nC"}},
+			{str: "X\nZ", want: nil},
+			{str: "X.Z", want: []string{"X.Z"}},
+		},
+	},
+	{
+		re: `\bfor\b`,
+		cases: []findTestCase{
+			{str: "for", want: []string{"for"}},
+			{str: "fork", want: nil},
+			{str: "_for", want: nil},
+			{str: "before", want: nil},
+			{str: "fork for", want: []string{"for"}},
+			{str: "(for)", want: []string{"for"}},
+			{str: "éfor", want: nil},
+		},
+	},
+	{
+		re: `\Bor\B`,
+		cases: []findTestCase{
+			{str: "or", want: nil},
+			{str: "for", want: nil},
+			{str: "fork", want: []string{"or"}},
+		},
+	},
+	{
+		re: `\b`,
+		cases: []findTestCase{
+			{str: "", want: nil},
+			{str: "  ", want: nil},
+			{str: "  a", want: []string{""}},
+		},
+	},
 }
 
 func TestFind(t *testing.T) {
@@ -193,6 +625,55 @@ func TestFind(t *testing.T) {
 	}
 }
 
+func TestFindOpts(t *testing.T) {
+	tests := []struct {
+		opts Opts
+		findTest
+	}{
+		{
+			opts: Opts{FoldCase: true},
+			findTest: findTest{
+				re: "foo[a-c]+",
+				cases: []findTestCase{
+					{str: "FOOabc", want: []string{"FOOabc"}},
+					{str: "fOoCbA", want: []string{"fOoCbA"}},
+					{str: "foxABC", want: nil},
+				},
+			},
+		},
+		{
+			opts: Opts{FoldCase: true},
+			findTest: findTest{
+				re: "(?-i)foo",
+				cases: []findTestCase{
+					{str: "FOO", want: nil},
+				},
+			},
+		},
+		{
+			opts: Opts{DotNewline: true},
+			findTest: findTest{
+				re: "a.b",
+				cases: []findTestCase{
+					{str: "a\nb", want: []string{"a\nb"}},
+				},
+			},
+		},
+		{
+			opts: Opts{FoldCase: true, Reverse: true},
+			findTest: findTest{
+				re: "foo",
+				cases: []findTestCase{
+					{str: "foo FOO", want: []string{"FOO"}},
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		runTest(t, test.findTest, test.opts)
+	}
+}
+
 var findReverseTests = []findTest{
 	{
 		re: "",
@@ -328,6 +809,32 @@ var findReverseTests = []findTest{
 			{str: "foobar", want: []string{"foobar", "foo", "bar"}},
 		},
 	},
+	{
+		re: `\bfor\b`,
+		cases: []findTestCase{
+			{str: "fork for", want: []string{"for"}},
+			{str: "for fork", want: []string{"for"}},
+			{str: "fork", want: nil},
+		},
+	},
+	{
+		re: `\w+`,
+		cases: []findTestCase{
+			{str: "abc xyz", want: []string{"xyz"}},
+		},
+	},
+	{
+		re: `a{2}b`,
+		cases: []findTestCase{
+			{str: "aaabaab", want: []string{"aab"}},
+		},
+	},
+	{
+		re: `a+?b`,
+		cases: []findTestCase{
+			{str: "aaab", want: []string{"ab"}},
+		},
+	},
 }
 
 func TestReverseFind(t *testing.T) {
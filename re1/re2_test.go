@@ -4,9 +4,11 @@ package re1
 // filtering out syntax re1 doesn't support.
 // We ignore the match info in the RE2 test suite,
 // because it's for single-line mode and first match.
-// re1 is always multi-line mode and longest match.
+// re1 is always multi-line mode and longest match,
+// unless the expression has a non-greedy repetition.
 // Instead, we test against the Go regexp package with
-// both multi-line mode and longest matching enabled.
+// multi-line mode enabled and, unless there is
+// a non-greedy repetition, longest matching enabled.
 // We ignore substring matches;
 // regexp and RE2 compute different submatches than re1.
 
@@ -71,17 +73,19 @@ func runRE2Tests(t *testing.T, path string) {
 }
 
 func runRE2TestCase(t *testing.T, reStr string, strs []string) {
-	reStr = strings.Replace(reStr, "(?:", "(", -1)
 	goRegexp := regexp.MustCompile("(?m:" + reStr + ")")
-	goRegexp.Longest()
 	r1Regexp, residue, err := New(reStr, Opts{})
 	if residue != "" || err != nil {
 		t.Errorf("New(%q, '/')=_,%q,%v", reStr, residue, err)
 		return
 	}
+	if !r1Regexp.first {
+		goRegexp.Longest()
+	}
 	for _, str := range strs {
 		want := match64(goRegexp, str)
-		got := r1Regexp.Find(strings.NewReader(str))
+		nfa := r1Regexp.Find(strings.NewReader(str))
+		got := nfa
 		// We only consider the full match,
 		// because we disagree with regexp and re2
 		// on what the submatches are.
@@ -92,13 +96,11 @@ func runRE2TestCase(t *testing.T, reStr string, strs []string) {
 			t.Errorf("%q: Find(%q)=%v, want %v", reStr, str, got, want)
 		}
 
+		// FindInRope uses the DFA, and only runs the NFA for submatches,
+		// so its submatches must agree with the NFA's.
 		ro := rope.New(str)
-		got = r1Regexp.FindInRope(ro, 0, ro.Len())
-		if got != nil {
-			got = got[:2]
-		}
-		if !reflect.DeepEqual(want, got) {
-			t.Errorf("%q: FindInRope(%q)=%v, want %v", reStr, str, got, want)
+		if got := r1Regexp.FindInRope(ro, 0, ro.Len()); !reflect.DeepEqual(nfa, got) {
+			t.Errorf("%q: FindInRope(%q)=%v, want %v", reStr, str, got, nfa)
 		}
 	}
 
@@ -114,41 +116,35 @@ func match64(re *regexp.Regexp, str string) []int64 {
 
 var (
 	exclude = []string{
-		// We don't support flags.
-		`(?i`,
+		// We don't support these flags.
 		`(?m`,
-		`(?s`,
 		`(?u`,
-
-		// We don't support non-greedy repetition.
-		`*?`,
-		`+?`,
-		`??`,
+		`(?U`,
 
 		// We don't support [[:space:]] and friends.
 		`[[`,
 
-		// We don't support these Perl character classes.
+		// We don't support these Perl escapes.
 		`\A`,
-		`\B`,
 		`\C`,
-		`\D`,
-		`\P`,
-		`\S`,
-		`\W`,
 		`\a`,
-		`\b`,
-		`\d`,
 		`\f`,
-		`\p`,
 		`\r`,
-		`\s`,
-		`\S`,
 		`\v`,
-		`\w`,
 		`\x`,
 		`\z`,
 
+		// Our Perl character classes and word boundaries
+		// are Unicode-aware, but RE2's are ASCII-only.
+		`\B`,
+		`\b`,
+		`\D`,
+		`\S`,
+		`\W`,
+		`\d`,
+		`\s`,
+		`\w`,
+
 		// We have a less-permissive charclass grammar.
 		`[]a]`,
 		`[-a]`,
@@ -163,7 +159,6 @@ var (
 	}
 
 	octal = regexp.MustCompile(`[\][0-7][0-7][0-7]`)
-	repN  = regexp.MustCompile(`\{[0-9,]+\}`)
 )
 
 func unsupported(re string) bool {
@@ -172,7 +167,7 @@ func unsupported(re string) bool {
 			return true
 		}
 	}
-	return octal.MatchString(re) || repN.MatchString(re)
+	return octal.MatchString(re)
 }
 
 func parseMatches(line string) [][]int64 {
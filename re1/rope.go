@@ -1,24 +1,152 @@
 package re1
 
 import (
+	"context"
+	"errors"
+
 	"github.com/eaburns/T/rope"
 )
 
+// ErrAborted is returned by a search
+// that is aborted because its context is done.
+var ErrAborted = errors.New("search aborted")
+
 // FindInRope returns the left-most, longest match of a regulax expression
 // between byte offsets s (inclusive) and e (exclusive) in a rope.
 func (re *Regexp) FindInRope(ro rope.Rope, s, e int64) []int64 {
-	sl := rope.Slice(ro, s, ro.Len())
-	rr := rope.NewReader(sl)
-	v := newVM(re, rr)
-	v.c = prevRune(ro, s)
-	v.at, v.lim = s, e
-	return run(v)
+	ms, _ := re.FindInRopeContext(context.Background(), ro, s, e)
+	return ms
+}
+
+// FindInRopeContext is like FindInRope,
+// but it returns ErrAborted if the context is done before the search completes.
+func (re *Regexp) FindInRopeContext(ctx context.Context, ro rope.Rope, s, e int64) ([]int64, error) {
+	v := newRopeVM(ctx, re)
+	open := func(at, lim int64) *vm { return reset(v, ro, at, lim) }
+	return find(open(s, e), open)
+}
+
+// FindAllInRope calls f with each successive, non-overlapping match
+// of a regular expression between byte offsets s (inclusive) and e (exclusive)
+// in a rope, until there are no more matches or f returns false.
+//
+// Like the regexp package, after an empty match
+// the search continues one rune past the match,
+// and an empty match immediately following
+// the previous match is ignored.
+func (re *Regexp) FindAllInRope(ro rope.Rope, s, e int64, f func(ms []int64) bool) {
+	re.FindAllInRopeContext(context.Background(), ro, s, e, f)
+}
+
+// FindAllInRopeContext is like FindAllInRope,
+// but it returns ErrAborted if the context is done before the search completes.
+func (re *Regexp) FindAllInRopeContext(ctx context.Context, ro rope.Rope, s, e int64, f func(ms []int64) bool) error {
+	v := newRopeVM(ctx, re)
+	open := func(at, lim int64) *vm { return reset(v, ro, at, lim) }
+	prev := int64(-1)
+	for at := s; at <= e; {
+		ms, err := find(open(at, e), open)
+		if err != nil || ms == nil {
+			return err
+		}
+		if ms[0] < ms[1] || ms[0] != prev {
+			if !f(ms) {
+				return nil
+			}
+			prev = ms[1]
+		}
+		at = ms[1]
+		if ms[0] == ms[1] {
+			if at >= e {
+				return nil
+			}
+			_, w, err := rope.NewReaderAt(ro, at).ReadRune()
+			if err != nil {
+				return nil
+			}
+			at += int64(w)
+		}
+	}
+	return nil
+}
+
+// newRopeVM returns a VM with no reader; see reset.
+// The VM is aborted when ctx is done.
+func newRopeVM(ctx context.Context, re *Regexp) *vm {
+	v := &vm{re: re, seen: make([]int64, len(re.prog))}
+	if ctx.Done() != nil {
+		v.ctx = ctx
+	}
+	return v
+}
+
+// reset resets a VM to read forward in the rope from at up to lim,
+// reusing the VM's memory, except for its last match.
+func reset(v *vm, ro rope.Rope, at, lim int64) *vm {
+	for _, t := range v.next {
+		v.free = append(v.free, t.mem)
+	}
+	v.cur, v.next, v.match = v.cur[:0], v.next[:0], nil
+	for i := range v.seen {
+		v.seen[i] = -1
+	}
+	v.rr, v.ro = rope.NewReaderAt(ro, at), nil
+	if v.re.prefixes != nil {
+		v.ro = ro
+	}
+	v.anchor, v.cut = false, false
+	v.n = eof
+	read(v)
+	v.c, v.at, v.lim = prevRune(ro, at), at, lim
+	return v
+}
+
+// find returns the match of a VM.
+// It first finds the match boundaries with the DFA.
+// If there are submatches, it then runs the NFA,
+// using a VM from open, anchored at the start of the match.
+// If the DFA gives up, find runs the NFA from the beginning.
+func find(v *vm, open func(at, lim int64) *vm) ([]int64, error) {
+	at, lim := v.at, v.lim
+	switch ms, ok := runDFA(v); {
+	case v.err != nil:
+		return nil, v.err
+	case !ok:
+		v = open(at, lim)
+	case ms == nil || v.re.ncap == 1:
+		return ms, nil
+	default:
+		v = open(ms[0], ms[1])
+		v.anchor, v.ro = true, nil
+	}
+	ms := run(v)
+	return ms, v.err
+}
+
+// seek advances the VM to the next index
+// at which one of the regexp's literal prefixes begins.
+// seek returns false if there is no such index before the limit.
+func seek(v *vm) bool {
+	var at int64
+	if v.re.matcher != nil {
+		at, _ = v.re.matcher.Index(v.ro, v.at)
+	} else {
+		at = rope.Index(v.ro, v.re.prefixes[0], v.at)
+	}
+	switch {
+	case at < 0 || at >= v.lim:
+		return false
+	case at > v.at:
+		v.rr = rope.NewReaderAt(v.ro, at)
+		v.n = eof
+		read(v)
+		v.c, v.at = prevRune(v.ro, at), at
+	}
+	return true
 }
 
 func prevRune(ro rope.Rope, i int64) rune {
-	sl := rope.Slice(ro, 0, i)
-	rr := rope.NewReverseReader(sl)
-	r, _, err := rr.ReadRune()
+	r, _, err := rope.NewReverseReaderAt(ro, i).ReadRune()
 	if err != nil {
 		return eof
 	}
@@ -31,25 +159,38 @@ func prevRune(ro rope.Rope, i int64) rune {
 //
 // The receiver is assumed to be compiled for a reverse match.
 func (re *Regexp) FindReverseInRope(ro rope.Rope, s, e int64) []int64 {
-	sl := rope.Slice(ro, 0, e)
-	rr := rope.NewReverseReader(sl)
-	v := newVM(re, rr)
-	v.c = nextRune(ro, e)
-	v.lim = e - s
-	ms := run(v)
+	ms, _ := re.FindReverseInRopeContext(context.Background(), ro, s, e)
+	return ms
+}
+
+// FindReverseInRopeContext is like FindReverseInRope,
+// but it returns ErrAborted if the context is done before the search completes.
+func (re *Regexp) FindReverseInRopeContext(ctx context.Context, ro rope.Rope, s, e int64) ([]int64, error) {
+	// The VM indices are the number of bytes before e.
+	open := func(at, lim int64) *vm {
+		v := newVM(re, rope.NewReverseReaderAt(ro, e-at))
+		if ctx.Done() != nil {
+			v.ctx = ctx
+		}
+		v.c = nextRune(ro, e-at)
+		v.at, v.lim = at, lim
+		return v
+	}
+	ms, err := find(open(0, e-s), open)
+	if err != nil {
+		return nil, err
+	}
 	// Only reverse to len(ms)-1, because the last is the regexp ID.
 	for i := 0; i < len(ms)-1; i += 2 {
 		if ms[i] >= 0 {
 			ms[i], ms[i+1] = e-ms[i+1], e-ms[i]
 		}
 	}
-	return ms
+	return ms, nil
 }
 
 func nextRune(ro rope.Rope, i int64) rune {
-	sl := rope.Slice(ro, i, ro.Len())
-	rr := rope.NewReader(sl)
-	r, _, err := rr.ReadRune()
+	r, _, err := rope.NewReaderAt(ro, i).ReadRune()
 	if err != nil {
 		return eof
 	}
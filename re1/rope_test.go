@@ -1,9 +1,10 @@
 package re1
 
 import (
+	"context"
 	"reflect"
+	"strings"
 	"testing"
-	"unicode/utf8"
 
 	"github.com/eaburns/T/rope"
 )
@@ -182,6 +183,85 @@ func TestFindReverseInRope(t *testing.T) {
 
 }
 
+func TestFindAllInRope(t *testing.T) {
+	tests := []struct {
+		re   string
+		str  string
+		s, e int64
+		// n is the maximum number of matches, or 0 for all.
+		n    int
+		want [][2]int64
+	}{
+		{re: "a", str: "", want: nil},
+		{re: "a", str: "xyz", e: 3, want: nil},
+		{re: "a", str: "aba", e: 3, want: [][2]int64{{0, 1}, {2, 3}}},
+		{re: "a", str: "aba", s: 1, e: 3, want: [][2]int64{{2, 3}}},
+		{re: "a", str: "aba", e: 2, want: [][2]int64{{0, 1}}},
+		{re: "a", str: "aaa", e: 3, n: 2, want: [][2]int64{{0, 1}, {1, 2}}},
+		{re: "a+", str: "aabaa", e: 5, want: [][2]int64{{0, 2}, {3, 5}}},
+		{re: "", str: "", want: [][2]int64{{0, 0}}},
+		{re: "", str: "ab", e: 2, want: [][2]int64{{0, 0}, {1, 1}, {2, 2}}},
+		{re: "", str: "ab", s: 1, e: 1, want: [][2]int64{{1, 1}}},
+		{re: "", str: "世界", e: 6, want: [][2]int64{{0, 0}, {3, 3}, {6, 6}}},
+		{re: "a*", str: "baaac", e: 5, want: [][2]int64{{0, 0}, {1, 4}, {5, 5}}},
+		{re: "a*", str: "aa", e: 2, want: [][2]int64{{0, 2}}},
+		{re: "^", str: "a\nb\n", e: 4, want: [][2]int64{{0, 0}, {2, 2}, {4, 4}}},
+		{re: "$", str: "a\nb", e: 3, want: [][2]int64{{1, 1}, {3, 3}}},
+		{re: "^a", str: "aaa\na", e: 5, want: [][2]int64{{0, 1}, {4, 5}}},
+		{re: `\bx`, str: "xx x", e: 4, want: [][2]int64{{0, 1}, {3, 4}}},
+		{re: "(a)(b)?", str: "abaab", e: 5, want: [][2]int64{{0, 2}, {2, 3}, {3, 5}}},
+		{re: "abc", str: "xxabcxxabcabc", e: 13, want: [][2]int64{{2, 5}, {7, 10}, {10, 13}}},
+	}
+	for _, test := range tests {
+		re, residual, err := New(test.re, Opts{})
+		if err != nil || residual != "" {
+			t.Fatalf("New(%q)=_,%q,%v", test.re, residual, err)
+		}
+		var got [][2]int64
+		re.FindAllInRope(rope.New(test.str), test.s, test.e, func(ms []int64) bool {
+			got = append(got, [2]int64{ms[0], ms[1]})
+			return test.n == 0 || len(got) < test.n
+		})
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("FindAllInRope(%q, %q, %d, %d)=%v, want %v",
+				test.re, test.str, test.s, test.e, got, test.want)
+		}
+	}
+}
+
+func TestFindInRopeContext(t *testing.T) {
+	ro := rope.New(strings.Repeat("a", 1<<16) + "b")
+	for _, str := range []string{"b", "(a|aa)*b", "x*(a)b"} {
+		re, residual, err := New(str, Opts{})
+		if err != nil || residual != "" {
+			t.Fatalf("New(%q)=_,%q,%v", str, residual, err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		ms, err := re.FindInRopeContext(ctx, ro, 0, ro.Len())
+		if err != nil || ms == nil || ms[1] != ro.Len() {
+			t.Errorf("FindInRopeContext(%q)=%v,%v, want a match, nil", str, ms, err)
+		}
+		cancel()
+		if ms, err := re.FindInRopeContext(ctx, ro, 0, ro.Len()); ms != nil || err != ErrAborted {
+			t.Errorf("FindInRopeContext(%q) canceled=%v,%v, want nil,%v", str, ms, err, ErrAborted)
+		}
+		err = re.FindAllInRopeContext(ctx, ro, 0, ro.Len(), func([]int64) bool { return true })
+		if err != ErrAborted {
+			t.Errorf("FindAllInRopeContext(%q) canceled=%v, want %v", str, err, ErrAborted)
+		}
+	}
+
+	re, _, err := New("a*", Opts{Reverse: true})
+	if err != nil {
+		t.Fatalf("New(\"a*\")=_,_,%v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if ms, err := re.FindReverseInRopeContext(ctx, ro, 0, ro.Len()); ms != nil || err != ErrAborted {
+		t.Errorf("FindReverseInRopeContext canceled=%v,%v, want nil,%v", ms, err, ErrAborted)
+	}
+}
+
 type ropeTest struct {
 	re    string
 	cases []ropeTestCase
@@ -198,7 +278,7 @@ func ropeFromFindTests(ftests []findTest) []ropeTest {
 	for i, ftest := range ftests {
 		rtests[i] = ropeTest{re: ftest.re}
 		for _, fc := range ftest.cases {
-			e := int64(utf8.RuneCountInString(fc.str))
+			e := int64(len(fc.str))
 			rc := ropeTestCase{str: fc.str, s: 0, e: e, want: fc.want}
 			rtests[i].cases = append(rtests[i].cases, rc)
 		}
@@ -8,6 +8,10 @@ package re1
 // The capture groups are numbered with respect to their corresponding numbers for the matched component regexp.
 // For example, Union("(a)bc", "(d)ef") will return a match for "a" as capture group 1 if component expression "(a)bc" matches.
 // However it will return a match for "d" as capture group 1 if component expression "(d)ef" matches.
+// The name of each capture group is its name in the first component that names it.
+//
+// If any component contains a non-greedy repetition,
+// the Union matches leftmost-first.
 func Union(res ...*Regexp) *Regexp {
 	switch len(res) {
 	case 0:
@@ -19,17 +23,20 @@ func Union(res ...*Regexp) *Regexp {
 	left := new(Regexp)
 	*left = *res[0]
 	left.prog = append([]instr{}, left.prog...)
-	left.class = append([][][2]rune{}, left.class...)
-	// We use non-capturing group syntax here
-	// even though it's not actually supported by re1.
-	// But source is only used for debugging,
-	// and using a capturing group would be incorrectly,
-	// because the capture numbers would be wrong.
+	left.class = append([]*charClass{}, left.class...)
+	if left.names != nil {
+		left.names = append([]string{}, left.names...)
+	}
+	// Use non-capturing groups,
+	// since the capture groups of each component
+	// are numbered from 1.
 	left.source = "(?:" + left.source + ")"
 	for _, right := range res[1:] {
 		left = union2(left, right)
 		left.source += "|(?:" + right.source + ")"
 	}
+	setPrefixes(left)
+	left.dfa = new(dfa)
 	return left
 }
 
@@ -49,6 +56,19 @@ func union2(left, right *Regexp) *Regexp {
 		left.prog = append(left.prog, instr)
 	}
 	left.class = append(left.class, right.class...)
+	left.first = left.first || right.first
+	if left.names != nil || right.names != nil {
+		names := groupNames(left)
+		for i, name := range groupNames(right) {
+			switch {
+			case i >= len(names):
+				names = append(names, name)
+			case names[i] == "":
+				names[i] = name
+			}
+		}
+		left.names = names
+	}
 	if right.ncap > left.ncap {
 		left.ncap = right.ncap
 	}
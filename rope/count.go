@@ -0,0 +1,179 @@
+package rope
+
+import "strings"
+
+// NewlineCount returns the number of newlines in the rope.
+func NewlineCount(ro Rope) int64 {
+	nl, _ := counts(ro)
+	return nl
+}
+
+// RuneCount returns the number of runes in the rope.
+//
+// Runes are counted by the bytes that begin a UTF-8 encoding,
+// so for invalid UTF-8 the count may differ from
+// the number of runes returned by a Reader.
+func RuneCount(ro Rope) int64 {
+	_, nr := counts(ro)
+	return nr
+}
+
+// LineIndex returns the byte index
+// of the start of the nth line of the rope,
+// counting from 0.
+// Line n begins just after the nth newline.
+// If the rope has fewer than n newlines,
+// LineIndex returns -1.
+func LineIndex(ro Rope, n int64) int64 {
+	if n == 0 {
+		return 0
+	}
+	i := index(ro, n-1, NewlineCount, nthNewline)
+	if i < 0 {
+		return -1
+	}
+	return i + 1
+}
+
+// RuneIndex returns the byte index
+// of the nth rune of the rope,
+// counting from 0.
+// If n is the number of runes in the rope,
+// RuneIndex returns the length of the rope.
+// If n is negative or greater than the number of runes,
+// RuneIndex returns -1.
+func RuneIndex(ro Rope, n int64) int64 {
+	if n == RuneCount(ro) {
+		return ro.Len()
+	}
+	return index(ro, n, RuneCount, nthRune)
+}
+
+// LineNumber returns the number of newlines
+// before the byte index i of the rope;
+// the line of i, counting from 0.
+// LineNumber panics if i < 0 or i > ro.Len().
+func LineNumber(ro Rope, i int64) int64 {
+	nl, _ := countsBefore(ro, i)
+	return nl
+}
+
+// RuneNumber returns the number of runes
+// before the byte index i of the rope.
+// RuneNumber panics if i < 0 or i > ro.Len().
+func RuneNumber(ro Rope, i int64) int64 {
+	_, nr := countsBefore(ro, i)
+	return nr
+}
+
+// index returns the byte index of the nth counted item of the rope
+// or -1 if there is none.
+// The count function returns the count of a rope,
+// and the nth function returns the byte index
+// of the nth counted item of a string.
+func index(ro Rope, n int64, count func(Rope) int64, nth func(string, int64) int64) int64 {
+	if n < 0 {
+		return -1
+	}
+	var i int64
+	for {
+		switch r := ro.(type) {
+		case *leaf:
+			j := nth(r.text, n)
+			if j < 0 {
+				return -1
+			}
+			return i + j
+		case *node:
+			if c := count(r.left); n >= c {
+				n -= c
+				i += r.left.Len()
+				ro = r.right
+			} else {
+				ro = r.left
+			}
+		default:
+			panic("impossible")
+		}
+	}
+}
+
+func nthNewline(text string, n int64) int64 {
+	var i int
+	for {
+		j := strings.IndexByte(text[i:], '\n')
+		if j < 0 {
+			return -1
+		}
+		if n == 0 {
+			return int64(i + j)
+		}
+		n--
+		i += j + 1
+	}
+}
+
+func nthRune(text string, n int64) int64 {
+	for i := 0; i < len(text); i++ {
+		if !runeStart(text[i]) {
+			continue
+		}
+		if n == 0 {
+			return int64(i)
+		}
+		n--
+	}
+	return -1
+}
+
+func countsBefore(ro Rope, i int64) (nlines, nrunes int64) {
+	if i < 0 || i > ro.Len() {
+		panic("index out of bounds")
+	}
+	for {
+		switch r := ro.(type) {
+		case *leaf:
+			nl, nr := countText(r.text[:i])
+			return nlines + nl, nrunes + nr
+		case *node:
+			if i <= r.left.Len() {
+				ro = r.left
+				continue
+			}
+			nl, nr := counts(r.left)
+			nlines += nl
+			nrunes += nr
+			i -= r.left.Len()
+			ro = r.right
+		default:
+			panic("impossible")
+		}
+	}
+}
+
+func counts(ro Rope) (nlines, nrunes int64) {
+	switch r := ro.(type) {
+	case *leaf:
+		return r.nlines, r.nrunes
+	case *node:
+		return r.nlines, r.nrunes
+	default:
+		panic("impossible")
+	}
+}
+
+func countText(text string) (nlines, nrunes int64) {
+	for i := 0; i < len(text); i++ {
+		switch b := text[i]; {
+		case b == '\n':
+			nlines++
+			nrunes++
+		case runeStart(b):
+			nrunes++
+		}
+	}
+	return nlines, nrunes
+}
+
+// runeStart returns whether the byte is not a UTF-8 continuation byte.
+func runeStart(b byte) bool { return b&0xC0 != 0x80 }
@@ -0,0 +1,108 @@
+package rope
+
+import "testing"
+
+func TestCounts(t *testing.T) {
+	tests := []struct {
+		str            string
+		nlines, nrunes int64
+	}{
+		{"", 0, 0},
+		{"abc", 0, 3},
+		{"\n", 1, 1},
+		{"abc\ndef\n", 2, 8},
+		{"☺\n世界\n\n", 3, 6},
+	}
+	for _, test := range tests {
+		for _, ro := range []Rope{New(test.str), byteLeaves(test.str)} {
+			if n := NewlineCount(ro); n != test.nlines {
+				t.Errorf("NewlineCount(%q)=%d, want %d", test.str, n, test.nlines)
+			}
+			if n := RuneCount(ro); n != test.nrunes {
+				t.Errorf("RuneCount(%q)=%d, want %d", test.str, n, test.nrunes)
+			}
+		}
+	}
+}
+
+func TestLineIndex(t *testing.T) {
+	const str = "abc\n☺\n\nxyz"
+	tests := []struct {
+		n    int64
+		want int64
+	}{
+		{-1, -1},
+		{0, 0},
+		{1, 4},
+		{2, int64(len("abc\n☺\n"))},
+		{3, int64(len("abc\n☺\n\n"))},
+		{4, -1},
+	}
+	for _, ro := range []Rope{New(str), byteLeaves(str)} {
+		for _, test := range tests {
+			if got := LineIndex(ro, test.n); got != test.want {
+				t.Errorf("LineIndex(%q, %d)=%d, want %d", str, test.n, got, test.want)
+			}
+		}
+	}
+}
+
+func TestRuneIndex(t *testing.T) {
+	const str = "a☺\n世界"
+	tests := []struct {
+		n    int64
+		want int64
+	}{
+		{-1, -1},
+		{0, 0},
+		{1, 1},
+		{2, int64(len("a☺"))},
+		{3, int64(len("a☺\n"))},
+		{4, int64(len("a☺\n世"))},
+		{5, int64(len(str))},
+		{6, -1},
+	}
+	for _, ro := range []Rope{New(str), byteLeaves(str)} {
+		for _, test := range tests {
+			if got := RuneIndex(ro, test.n); got != test.want {
+				t.Errorf("RuneIndex(%q, %d)=%d, want %d", str, test.n, got, test.want)
+			}
+		}
+	}
+}
+
+func TestLineNumberRuneNumber(t *testing.T) {
+	const str = "a☺\n世\n\nb"
+	for _, ro := range []Rope{New(str), byteLeaves(str)} {
+		var nlines, nrunes int64
+		for i := range str {
+			if got := LineNumber(ro, int64(i)); got != nlines {
+				t.Errorf("LineNumber(%q, %d)=%d, want %d", str, i, got, nlines)
+			}
+			if got := RuneNumber(ro, int64(i)); got != nrunes {
+				t.Errorf("RuneNumber(%q, %d)=%d, want %d", str, i, got, nrunes)
+			}
+			if str[i] == '\n' {
+				nlines++
+			}
+			nrunes++
+		}
+		if got := LineNumber(ro, int64(len(str))); got != nlines {
+			t.Errorf("LineNumber(%q, %d)=%d, want %d", str, len(str), got, nlines)
+		}
+		if got := RuneNumber(ro, int64(len(str))); got != nrunes {
+			t.Errorf("RuneNumber(%q, %d)=%d, want %d", str, len(str), got, nrunes)
+		}
+	}
+}
+
+// byteLeaves returns a rope with a separate leaf for each byte of the string,
+// so multi-byte runes span multiple leaves.
+func byteLeaves(str string) Rope {
+	switch len(str) {
+	case 0, 1:
+		return New(str)
+	default:
+		return newNode(byteLeaves(str[:len(str)/2]), byteLeaves(str[len(str)/2:]))
+	}
+}
@@ -1,5 +1,10 @@
 package rope
 
+import (
+	"strings"
+	"unicode/utf8"
+)
+
 // IndexFunc returns the byte index
 // of the first rune in the rope
 // for which a function returns true.
@@ -49,3 +54,112 @@ func LastIndexFunc(ro Rope, f func(r rune) bool) int64 {
 		}
 	}
 }
+
+// Index returns the byte index
+// of the first occurrence of s in the rope
+// at or after the byte index start.
+// If s does not occur, Index returns -1.
+// Index panics if start < 0 or start > ro.Len().
+//
+// Each leaf of the rope is searched with strings.Index,
+// and occurrences spanning leaves are found
+// by searching the bytes near each boundary.
+func Index(ro Rope, s string, start int64) int64 {
+	if s == "" {
+		return start
+	}
+	it := iter{todo: []Rope{Slice(ro, start, ro.Len())}}
+	at := start // the index of it.text
+	var tail string
+	for next(&it, false) {
+		text := it.text
+		if tail != "" {
+			if i := strings.Index(tail+prefix(text, len(s)-1), s); i >= 0 {
+				return at - int64(len(tail)) + int64(i)
+			}
+		}
+		if i := strings.Index(text, s); i >= 0 {
+			return at + int64(i)
+		}
+		tail = suffix(tail+suffix(text, len(s)-1), len(s)-1)
+		at += int64(len(text))
+	}
+	return -1
+}
+
+// LastIndex returns the byte index
+// of the last occurrence of s in the rope
+// that ends at or before the byte index end.
+// If s does not occur, LastIndex returns -1.
+// LastIndex panics if end < 0 or end > ro.Len().
+//
+// LastIndex traverses the rope from end to beginning.
+func LastIndex(ro Rope, s string, end int64) int64 {
+	if s == "" {
+		return end
+	}
+	it := iter{todo: []Rope{Slice(ro, 0, end)}}
+	at := end // the index of the end of it.text
+	var head string
+	for next(&it, true) {
+		text := it.text
+		at -= int64(len(text))
+		if head != "" {
+			t := suffix(text, len(s)-1)
+			if i := strings.LastIndex(t+head, s); i >= 0 {
+				return at + int64(len(text)-len(t)) + int64(i)
+			}
+		}
+		if i := strings.LastIndex(text, s); i >= 0 {
+			return at + int64(i)
+		}
+		head = prefix(prefix(text, len(s)-1)+head, len(s)-1)
+	}
+	return -1
+}
+
+// IndexAny returns the byte index
+// of the first rune in the rope at or after the byte index start
+// that is contained in chars.
+// If no rune of chars occurs, IndexAny returns -1.
+// IndexAny panics if start < 0 or start > ro.Len().
+func IndexAny(ro Rope, chars string, start int64) int64 {
+	sl := Slice(ro, start, ro.Len())
+	for i := 0; i < len(chars); i++ {
+		if chars[i] >= utf8.RuneSelf {
+			// A multi-byte rune may span leaves.
+			i := IndexFunc(sl, func(r rune) bool { return strings.ContainsRune(chars, r) })
+			if i < 0 {
+				return -1
+			}
+			return start + i
+		}
+	}
+	// ASCII bytes are never part of a multi-byte rune,
+	// so it is safe to search each leaf separately.
+	it := iter{todo: []Rope{sl}}
+	at := start
+	for next(&it, false) {
+		if i := strings.IndexAny(it.text, chars); i >= 0 {
+			return at + int64(i)
+		}
+		at += int64(len(it.text))
+	}
+	return -1
+}
+
+// prefix returns at most the first n bytes of s.
+func prefix(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// suffix returns at most the last n bytes of s.
+func suffix(s string, n int) string {
+	if len(s) > n {
+		return s[len(s)-n:]
+	}
+	return s
+}
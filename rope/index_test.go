@@ -73,3 +73,84 @@ func TestLastIndexFunc(t *testing.T) {
 		}
 	}
 }
+
+func TestIndex(t *testing.T) {
+	tests := []struct {
+		str, sub string
+		start    int64
+		want     int64
+	}{
+		{"", "", 0, 0},
+		{"", "x", 0, -1},
+		{"abc", "", 2, 2},
+		{"abc", "abc", 0, 0},
+		{"abc", "bc", 0, 1},
+		{"abc", "c", 0, 2},
+		{"abc", "abcd", 0, -1},
+		{"abcabc", "abc", 1, 3},
+		{"abcabc", "abc", 4, -1},
+		{"☺☹☺", "☹", 0, int64(len("☺"))},
+		{"aaaaab", "aab", 0, 3},
+	}
+	for _, test := range tests {
+		for _, ro := range []Rope{New(test.str), byteLeaves(test.str)} {
+			if got := Index(ro, test.sub, test.start); got != test.want {
+				t.Errorf("Index(%q, %q, %d)=%d, want %d",
+					test.str, test.sub, test.start, got, test.want)
+			}
+		}
+	}
+}
+
+func TestLastIndex(t *testing.T) {
+	tests := []struct {
+		str, sub string
+		end      int64
+		want     int64
+	}{
+		{"", "", 0, 0},
+		{"", "x", 0, -1},
+		{"abc", "", 2, 2},
+		{"abc", "abc", 3, 0},
+		{"abc", "ab", 3, 0},
+		{"abc", "a", 3, 0},
+		{"abc", "abc", 2, -1},
+		{"abcabc", "abc", 6, 3},
+		{"abcabc", "abc", 5, 0},
+		{"☺☹☺", "☺", int64(len("☺☹☺")), int64(len("☺☹"))},
+		{"baaaaa", "baa", 6, 0},
+	}
+	for _, test := range tests {
+		for _, ro := range []Rope{New(test.str), byteLeaves(test.str)} {
+			if got := LastIndex(ro, test.sub, test.end); got != test.want {
+				t.Errorf("LastIndex(%q, %q, %d)=%d, want %d",
+					test.str, test.sub, test.end, got, test.want)
+			}
+		}
+	}
+}
+
+func TestIndexAny(t *testing.T) {
+	tests := []struct {
+		str, chars string
+		start      int64
+		want       int64
+	}{
+		{"", "", 0, -1},
+		{"abc", "", 0, -1},
+		{"abc", "xyz", 0, -1},
+		{"abc", "cb", 0, 1},
+		{"abc", "cb", 2, 2},
+		{"☺☹☺", "☹", 0, int64(len("☺"))},
+		{"☺☹☺", "x☺", 1, int64(len("☺☹"))},
+		{"☺☹a", "a", 0, int64(len("☺☹"))},
+	}
+	for _, test := range tests {
+		for _, ro := range []Rope{New(test.str), byteLeaves(test.str)} {
+			if got := IndexAny(ro, test.chars, test.start); got != test.want {
+				t.Errorf("IndexAny(%q, %q, %d)=%d, want %d",
+					test.str, test.chars, test.start, got, test.want)
+			}
+		}
+	}
+}
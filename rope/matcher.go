@@ -0,0 +1,151 @@
+package rope
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// A Matcher searches a rope for any of a set of strings.
+// It uses the Aho-Corasick algorithm,
+// which finds the strings in a single pass over the rope
+// regardless of the number of strings.
+type Matcher struct {
+	// delta is the transition function of the automaton:
+	// delta[s][b] is the state following state s on byte b.
+	delta [][256]int32
+	// out[s] is the index of the longest string
+	// that is a suffix of the input read to reach state s,
+	// or -1 if there is none.
+	out []int
+	// lens are the lengths of the strings.
+	lens []int
+	// max is the length of the longest string.
+	max int
+	// empty is the index of an empty string, or -1.
+	empty int
+	// first are the first bytes of the strings if they are all ASCII,
+	// otherwise first is the empty string.
+	// Index skips to these bytes from the initial state.
+	first string
+}
+
+// NewMatcher returns a new Matcher for the strings.
+//
+// The Matcher has a state for each unique prefix of the strings,
+// and each state uses 1KB of memory,
+// so it is best suited to sets of short strings.
+func NewMatcher(strs ...string) *Matcher {
+	m := &Matcher{
+		delta: make([][256]int32, 1),
+		out:   []int{-1},
+		lens:  make([]int, len(strs)),
+		empty: -1,
+	}
+	// Build the trie; transitions of 0 are missing.
+	for i, str := range strs {
+		m.lens[i] = len(str)
+		if len(str) > m.max {
+			m.max = len(str)
+		}
+		if str == "" {
+			if m.empty < 0 {
+				m.empty = i
+			}
+			continue
+		}
+		if strings.IndexByte(m.first, str[0]) < 0 {
+			m.first += str[:1]
+		}
+		var s int32
+		for j := 0; j < len(str); j++ {
+			if m.delta[s][str[j]] == 0 {
+				m.delta = append(m.delta, [256]int32{})
+				m.out = append(m.out, -1)
+				m.delta[s][str[j]] = int32(len(m.delta) - 1)
+			}
+			s = m.delta[s][str[j]]
+		}
+		if m.out[s] < 0 {
+			m.out[s] = i
+		}
+	}
+
+	// Fill in the missing transitions from the failure links,
+	// breadth-first, so the failure state of each state
+	// is complete before the state itself.
+	for i := 0; i < len(m.first); i++ {
+		if m.first[i] >= utf8.RuneSelf {
+			m.first = ""
+			break
+		}
+	}
+
+	fail := make([]int32, len(m.delta))
+	var q []int32
+	for b := range m.delta[0] {
+		if s := m.delta[0][b]; s != 0 {
+			q = append(q, s)
+		}
+	}
+	for len(q) > 0 {
+		s := q[0]
+		q = q[1:]
+		if m.out[s] < 0 {
+			m.out[s] = m.out[fail[s]]
+		}
+		for b := range m.delta[s] {
+			t := m.delta[s][b]
+			if t == 0 {
+				m.delta[s][b] = m.delta[fail[s]][b]
+				continue
+			}
+			fail[t] = m.delta[fail[s]][b]
+			q = append(q, t)
+		}
+	}
+	return m
+}
+
+// Index returns the byte index of the left-most occurrence
+// of any of the Matcher's strings in the rope
+// at or after the byte index start,
+// and the index of the string that occurs there.
+// If several strings occur at the left-most index,
+// the longest is returned.
+// If none of the strings occur, Index returns -1, -1.
+// Index panics if start < 0 or start > ro.Len().
+func (m *Matcher) Index(ro Rope, start int64) (int64, int) {
+	at, str := int64(-1), -1
+	if m.empty >= 0 {
+		at, str = start, m.empty
+	}
+	it := iter{todo: []Rope{Slice(ro, start, ro.Len())}}
+	i := start // the index of it.text
+	var s int32
+	for next(&it, false) {
+		for j := 0; j < len(it.text); j++ {
+			if s == 0 && m.first != "" {
+				k := strings.IndexAny(it.text[j:], m.first)
+				if k < 0 {
+					break
+				}
+				j += k
+			}
+			end := i + int64(j) + 1
+			if at >= 0 && end-int64(m.max) > at {
+				// Any later match begins after at.
+				return at, str
+			}
+			s = m.delta[s][it.text[j]]
+			o := m.out[s]
+			if o < 0 {
+				continue
+			}
+			if k := end - int64(m.lens[o]); at < 0 || k < at || k == at && m.lens[o] > m.lens[str] {
+				at, str = k, o
+			}
+		}
+		i += int64(len(it.text))
+	}
+	return at, str
+}
@@ -0,0 +1,37 @@
+package rope
+
+import "testing"
+
+func TestMatcher(t *testing.T) {
+	tests := []struct {
+		strs  []string
+		text  string
+		start int64
+		at    int64
+		str   int
+	}{
+		{strs: nil, text: "abc", at: -1, str: -1},
+		{strs: []string{"x"}, text: "", at: -1, str: -1},
+		{strs: []string{""}, text: "abc", start: 1, at: 1, str: 0},
+		{strs: []string{"abc"}, text: "xxabcxx", at: 2, str: 0},
+		{strs: []string{"abc", "bc"}, text: "xxabcxx", at: 2, str: 0},
+		{strs: []string{"bc", "abc"}, text: "xxabcxx", at: 2, str: 1},
+		{strs: []string{"bcd", "c"}, text: "abcd", at: 1, str: 0},
+		{strs: []string{"a", "ab", "abc"}, text: "xabc", at: 1, str: 2},
+		{strs: []string{"he", "she", "his", "hers"}, text: "ushers", at: 1, str: 1},
+		{strs: []string{"he", "she", "his", "hers"}, text: "ushers", start: 2, at: 2, str: 3},
+		{strs: []string{"☺", "☹"}, text: "abc☹☺", at: 3, str: 1},
+		{strs: []string{"aab"}, text: "aaab", at: 1, str: 0},
+		{strs: []string{"xyz"}, text: "xyxyxy", at: -1, str: -1},
+	}
+	for _, test := range tests {
+		m := NewMatcher(test.strs...)
+		for _, ro := range []Rope{New(test.text), byteLeaves(test.text)} {
+			at, str := m.Index(ro, test.start)
+			if at != test.at || str != test.str {
+				t.Errorf("NewMatcher(%q).Index(%q, %d)=%d,%d, want %d,%d",
+					test.strs, test.text, test.start, at, str, test.at, test.str)
+			}
+		}
+	}
+}
@@ -19,6 +19,35 @@ type Rope interface {
 type node struct {
 	left, right Rope
 	len         int64
+	// nlines and nrunes are the number of newlines and runes.
+	// See NewlineCount and RuneCount.
+	nlines, nrunes int64
+	// depth is the height of the node; leaves have depth 0.
+	depth int
+}
+
+func newNode(left, right Rope) *node {
+	nl0, nr0 := counts(left)
+	nl1, nr1 := counts(right)
+	d := depth(left)
+	if dr := depth(right); dr > d {
+		d = dr
+	}
+	return &node{
+		left:   left,
+		right:  right,
+		len:    left.Len() + right.Len(),
+		nlines: nl0 + nl1,
+		nrunes: nr0 + nr1,
+		depth:  d + 1,
+	}
+}
+
+func depth(ro Rope) int {
+	if n, ok := ro.(*node); ok {
+		return n.depth
+	}
+	return 0
 }
 
 func (n *node) Len() int64 { return n.len }
@@ -40,6 +69,13 @@ func (n *node) WriteTo(w io.Writer) (int64, error) {
 
 type leaf struct {
 	text string
+	// nlines and nrunes are the number of newlines and runes.
+	nlines, nrunes int64
+}
+
+func newLeaf(text string) *leaf {
+	nl, nr := countText(text)
+	return &leaf{text: text, nlines: nl, nrunes: nr}
 }
 
 func (l *leaf) Len() int64     { return int64(len(l.text)) }
@@ -55,7 +91,18 @@ func (l *leaf) WriteTo(w io.Writer) (int64, error) {
 func Empty() Rope { return New("") }
 
 // New returns a new Rope of the given string.
-func New(text string) Rope { return &leaf{text: text} }
+//
+// Long text is split into a balanced tree of leaves,
+// since splitting a leaf counts the runes and lines of its shorter half.
+func New(text string) Rope {
+	if len(text) <= maxLeafSize {
+		return newLeaf(text)
+	}
+	m := len(text) / 2
+	return newNode(New(text[:m]), New(text[m:]))
+}
+
+const maxLeafSize = 4096
 
 // ReadFrom returns a new Rope containing
 // all of the bytes read from a reader until io.EOF.
@@ -80,6 +127,10 @@ func ReadFrom(r io.Reader) (Rope, error) {
 const smallSize = 32
 
 // Append returns the concatenation of l and then r.
+//
+// The returned Rope is balanced:
+// the depths of the children of each node differ by at most one,
+// so the depth of a Rope is logarithmic in its number of leaves.
 func Append(l, r Rope) Rope {
 	switch {
 	case l.Len() == 0:
@@ -87,16 +138,53 @@ func Append(l, r Rope) Rope {
 	case r.Len() == 0:
 		return l
 	case l.Len()+r.Len() <= smallSize:
-		return &leaf{text: l.String() + r.String()}
+		return newLeaf(l.String() + r.String())
 	}
 	if l, ok := l.(*node); ok && l.right.Len()+r.Len() <= smallSize {
-		return &node{
-			left:  l.left,
-			right: &leaf{text: l.right.String() + r.String()},
-			len:   l.Len() + r.Len(),
+		// The merged leaf may be shallower than l.right,
+		// so it is joined to rebalance.
+		return join(l.left, newLeaf(l.right.String()+r.String()))
+	}
+	return join(l, r)
+}
+
+// join returns the concatenation of l and r,
+// descending into the deeper of the two
+// to join them at nodes of similar depth.
+func join(l, r Rope) Rope {
+	switch dl, dr := depth(l), depth(r); {
+	case dl > dr+1:
+		n := l.(*node)
+		return balance(n.left, join(n.right, r))
+	case dr > dl+1:
+		n := r.(*node)
+		return balance(join(l, n.left), n.right)
+	default:
+		return newNode(l, r)
+	}
+}
+
+// balance returns a node with the given children,
+// rotating if their depths differ by more than one.
+func balance(l, r Rope) Rope {
+	switch dl, dr := depth(l), depth(r); {
+	case dl > dr+1:
+		n := l.(*node)
+		if depth(n.left) >= depth(n.right) {
+			return newNode(n.left, newNode(n.right, r))
 		}
+		m := n.right.(*node)
+		return newNode(newNode(n.left, m.left), newNode(m.right, r))
+	case dr > dl+1:
+		n := r.(*node)
+		if depth(n.right) >= depth(n.left) {
+			return newNode(newNode(l, n.left), n.right)
+		}
+		m := n.left.(*node)
+		return newNode(newNode(l, m.left), newNode(m.right, n.right))
+	default:
+		return newNode(l, r)
 	}
-	return &node{left: l, right: r, len: l.Len() + r.Len()}
 }
 
 // Split returns two new Ropes, the first contains the first i bytes,
@@ -136,7 +224,7 @@ func split(rope Rope, i int64) (left, right Rope) {
 	}
 	switch rope := rope.(type) {
 	case *leaf:
-		return New(rope.text[:i]), New(rope.text[i:])
+		return splitLeaf(rope, i)
 	case *node:
 		switch {
 		case i <= rope.left.Len():
@@ -151,6 +239,26 @@ func split(rope Rope, i int64) (left, right Rope) {
 	}
 }
 
+// splitLeaf splits a leaf, counting only the shorter half
+// and computing the counts of the longer from those of the leaf.
+func splitLeaf(l *leaf, i int64) (Rope, Rope) {
+	if i <= int64(len(l.text))/2 {
+		left := newLeaf(l.text[:i])
+		return left, &leaf{
+			text:   l.text[i:],
+			nlines: l.nlines - left.nlines,
+			nrunes: l.nrunes - left.nrunes,
+		}
+	}
+	right := newLeaf(l.text[i:])
+	left := &leaf{
+		text:   l.text[:i],
+		nlines: l.nlines - right.nlines,
+		nrunes: l.nrunes - right.nrunes,
+	}
+	return left, right
+}
+
 // Reader implements io.Reader, io.ByteReader, and io.RuneReader,
 // reading from the contents of a Rope.
 type Reader struct {
@@ -165,6 +273,34 @@ func NewReader(rope Rope) *Reader {
 	return &Reader{iter: iter{todo: []Rope{rope}}}
 }
 
+// NewReaderAt returns a new *Reader
+// that reads the contents of the Rope beginning at byte index i.
+// It is like NewReader(Slice(rope, i, rope.Len())),
+// but it doesn't build a new Rope.
+func NewReaderAt(rope Rope, i int64) *Reader {
+	if i < 0 || i > rope.Len() {
+		panic("index out of bounds")
+	}
+	var todo []Rope
+	for {
+		switch n := rope.(type) {
+		case *leaf:
+			todo = append(todo, &leaf{text: n.text[i:]})
+			return &Reader{iter: iter{todo: todo}}
+		case *node:
+			if i < n.left.Len() {
+				todo = append(todo, n.right)
+				rope = n.left
+			} else {
+				i -= n.left.Len()
+				rope = n.right
+			}
+		default:
+			panic("impossible")
+		}
+	}
+}
+
 // Read reads into p and returns the number of bytes read.
 // If there is nothing left to read, Read returns 0 and io.EOF.
 // Read does not return errors other than io.EOF.
@@ -234,6 +370,34 @@ func NewReverseReader(rope Rope) *ReverseReader {
 	return &ReverseReader{iter: iter{todo: []Rope{rope}}}
 }
 
+// NewReverseReaderAt returns a new *ReverseReader
+// that reads the contents of the Rope before byte index i in reverse.
+// It is like NewReverseReader(Slice(rope, 0, i)),
+// but it doesn't build a new Rope.
+func NewReverseReaderAt(rope Rope, i int64) *ReverseReader {
+	if i < 0 || i > rope.Len() {
+		panic("index out of bounds")
+	}
+	var todo []Rope
+	for {
+		switch n := rope.(type) {
+		case *leaf:
+			todo = append(todo, &leaf{text: n.text[:i]})
+			return &ReverseReader{iter: iter{todo: todo}}
+		case *node:
+			if i <= n.left.Len() {
+				rope = n.left
+			} else {
+				todo = append(todo, n.left)
+				i -= n.left.Len()
+				rope = n.right
+			}
+		default:
+			panic("impossible")
+		}
+	}
+}
+
 // Read reads into p and returns the number of bytes read.
 // If there is nothing left to read, Read returns 0 and io.EOF.
 // Read does not return errors other than io.EOF.
@@ -9,6 +9,7 @@ import (
 	"testing"
 	"testing/quick"
 	"time"
+	"unicode/utf8"
 )
 
 var quickConfig *quick.Config
@@ -205,6 +206,93 @@ func TestQuickReverseReadRune(t *testing.T) {
 	}
 }
 
+func TestQuickRuneIndex(t *testing.T) {
+	err := quick.CheckEqual(
+		func(ss []string, n int) int64 {
+			str := strings.Join(ss, "")
+			n = randLen(n, utf8.RuneCountInString(str)+1)
+			for i := range str {
+				if n == 0 {
+					return int64(i)
+				}
+				n--
+			}
+			return int64(len(str))
+		},
+		func(ss []string, n int) int64 {
+			accum := Empty()
+			for _, s := range ss {
+				accum = Append(accum, New(s))
+			}
+			n = randLen(n, int(RuneCount(accum))+1)
+			return RuneIndex(accum, int64(n))
+		},
+		quickConfig)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQuickLineIndex(t *testing.T) {
+	err := quick.CheckEqual(
+		func(ss []string, n int) int64 {
+			str := strings.Join(ss, "\n")
+			n = randLen(n, strings.Count(str, "\n")+1)
+			var i int
+			for ; n > 0; n-- {
+				i += strings.IndexByte(str[i:], '\n') + 1
+			}
+			return int64(i)
+		},
+		func(ss []string, n int) int64 {
+			accum := Empty()
+			for i, s := range ss {
+				if i > 0 {
+					accum = Append(accum, New("\n"))
+				}
+				accum = Append(accum, New(s))
+			}
+			n = randLen(n, int(NewlineCount(accum))+1)
+			return LineIndex(accum, int64(n))
+		},
+		quickConfig)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQuickIndex(t *testing.T) {
+	err := quick.CheckEqual(
+		func(ss []string, sub string, i int) int64 {
+			str := strings.Join(ss, "")
+			i = randLen(i, len(str)+1)
+			// Search for a substring that is likely to occur.
+			if n := len(str) - i; n > 0 && len(sub)%2 == 0 {
+				sub = str[i+n/2 : i+n/2+n/4]
+			}
+			if j := strings.Index(str[i:], sub); j >= 0 {
+				return int64(i + j)
+			}
+			return -1
+		},
+		func(ss []string, sub string, i int) int64 {
+			accum := Empty()
+			for _, s := range ss {
+				accum = Append(accum, New(s))
+			}
+			str := accum.String()
+			i = randLen(i, len(str)+1)
+			if n := len(str) - i; n > 0 && len(sub)%2 == 0 {
+				sub = str[i+n/2 : i+n/2+n/4]
+			}
+			return Index(accum, sub, int64(i))
+		},
+		quickConfig)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
 func randLen(i, max int) int {
 	if max == 0 {
 		return 0
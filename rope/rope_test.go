@@ -3,6 +3,7 @@ package rope
 import (
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"reflect"
 	"strings"
 	"testing"
@@ -96,15 +97,17 @@ func TestAppendSmallNiece(t *testing.T) {
 
 	xs := strings.Repeat("x", smallSize-1)
 	niece := &node{
-		left:  &leaf{text: "w"},
-		right: &leaf{text: xs},
+		left:  newLeaf("w"),
+		right: newLeaf(xs),
 		len:   smallSize,
 	}
 
 	expect := &node{
-		left:  &leaf{text: "w"},
-		right: &leaf{text: xs + "y"},
-		len:   smallSize + 1,
+		left:   newLeaf("w"),
+		right:  newLeaf(xs + "y"),
+		len:    smallSize + 1,
+		nrunes: smallSize + 1,
+		depth:  1,
 	}
 	if r := Append(niece, New("y")); !reflect.DeepEqual(r, expect) {
 		t.Errorf("got %#v (%s), want %#v", r, r, expect)
@@ -258,6 +261,26 @@ func TestReadRune(t *testing.T) {
 	}
 }
 
+func TestNewReaderAt(t *testing.T) {
+	for _, test := range []Rope{New(""), New("Hello, 世界"), smallRope, byteLeaves("Hello, 世界!")} {
+		for i := int64(0); i <= test.Len(); i++ {
+			str := test.String()
+			got, err := readAllRune(NewReaderAt(test, i))
+			want, _ := readAllRune(NewReader(Slice(test, i, test.Len())))
+			if err != nil || got != want {
+				t.Errorf("NewReaderAt(%q, %d).ReadRune()=%q,%v, want %q,nil",
+					str, i, got, err, want)
+			}
+			got, err = readAllRune(NewReverseReaderAt(test, i))
+			want, _ = readAllRune(NewReverseReader(Slice(test, 0, i)))
+			if err != nil || got != want {
+				t.Errorf("NewReverseReaderAt(%q, %d).ReadRune()=%q,%v, want %q,nil",
+					str, i, got, err, want)
+			}
+		}
+	}
+}
+
 func TestReadRuneThenRead(t *testing.T) {
 	// Contains the first two bytes of a 3-byte UTF8 rune.
 	rope := New("\xE2\x98abc")
@@ -552,3 +575,148 @@ func TestAppendSplit(t *testing.T) {
 		}
 	}
 }
+
+func TestBalance(t *testing.T) {
+	rnd := rand.New(rand.NewSource(0))
+	ro := Empty()
+	var str []byte
+	for i := 0; i < 10000; i++ {
+		at := rnd.Intn(len(str) + 1)
+		if rnd.Intn(4) > 0 || len(str) == at {
+			ro = Insert(ro, int64(at), New("x"))
+			str = append(str[:at], append([]byte{'x'}, str[at:]...)...)
+		} else {
+			ro = Delete(ro, int64(at), 1)
+			str = append(str[:at], str[at+1:]...)
+		}
+	}
+	if ro.String() != string(str) {
+		t.Fatalf("ro.String()=%q, want %q", ro.String(), str)
+	}
+	checkBalance(t, ro)
+
+	// Split and Append ropes with leaves of varied sizes,
+	// some merged by Append into small leaves.
+	randRope := func() (Rope, string) {
+		ro := Empty()
+		var str string
+		for n := rnd.Intn(20); n > 0; n-- {
+			s := strings.Repeat("x", rnd.Intn(2*smallSize))
+			ro = Append(ro, New(s))
+			str += s
+		}
+		return ro, str
+	}
+	ro, text := randRope()
+	for i := 0; i < 10000; i++ {
+		at := rnd.Intn(len(text) + 1)
+		switch rnd.Intn(3) {
+		case 0:
+			l, r := Split(ro, int64(at))
+			if l.String() != text[:at] || r.String() != text[at:] {
+				t.Fatalf("Split(%q, %d)=%q,%q", text, at, l.String(), r.String())
+			}
+			checkBalance(t, l)
+			checkBalance(t, r)
+			ro = Append(r, l)
+			text = text[at:] + text[:at]
+		case 1:
+			o, s := randRope()
+			ro = Append(ro, o)
+			text += s
+		case 2:
+			o, s := randRope()
+			ro = Insert(ro, int64(at), o)
+			text = text[:at] + s + text[at:]
+		}
+		if len(text) > 100*smallSize {
+			ro, text = Slice(ro, 0, int64(len(text)/2)), text[:len(text)/2]
+		}
+		if ro.String() != text {
+			t.Fatalf("ro.String()=%q, want %q", ro.String(), text)
+		}
+		checkBalance(t, ro)
+	}
+}
+
+func checkBalance(t *testing.T, ro Rope) {
+	t.Helper()
+	n, ok := ro.(*node)
+	if !ok {
+		return
+	}
+	dl, dr := depth(n.left), depth(n.right)
+	if dl > dr+1 || dr > dl+1 {
+		t.Fatalf("unbalanced node: left depth %d, right depth %d", dl, dr)
+	}
+	if n.depth != dl+1 && n.depth != dr+1 {
+		t.Fatalf("node depth %d, child depths %d and %d", n.depth, dl, dr)
+	}
+	checkBalance(t, n.left)
+	checkBalance(t, n.right)
+}
+
+// tenMB returns a 10 MB rope read in the same way as a file.
+func tenMB() Rope {
+	line := strings.Repeat("Hello, 世界 ", 8) + "\n"
+	text := strings.Repeat(line, 10*1024*1024/len(line))
+	ro, err := ReadFrom(strings.NewReader(text))
+	if err != nil {
+		panic(err.Error())
+	}
+	return ro
+}
+
+// BenchmarkTyping inserts one rune at a time into a 10 MB rope,
+// in the way that a TextBox does when typing.
+func BenchmarkTyping(b *testing.B) {
+	ro := tenMB()
+	at := ro.Len() / 2
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ro = Insert(ro, at, New("x"))
+		at++
+	}
+}
+
+// BenchmarkTypingBackspace alternates inserting and deleting a rune.
+func BenchmarkTypingBackspace(b *testing.B) {
+	ro := tenMB()
+	at := ro.Len() / 2
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%2 == 0 {
+			ro = Insert(ro, at, New("x"))
+			at++
+		} else {
+			at--
+			ro = Delete(ro, at, 1)
+		}
+	}
+}
+
+// BenchmarkTypingRead reads the rune after the cursor
+// after typing each rune, as the TextBox does to redraw.
+func BenchmarkTypingRead(b *testing.B) {
+	ro := tenMB()
+	at := ro.Len() / 2
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ro = Insert(ro, at, New("x"))
+		at++
+		NewReader(Slice(ro, at, ro.Len())).ReadRune()
+	}
+}
+
+// BenchmarkTypingLineIndex finds the start of the cursor's line
+// after typing each rune.
+func BenchmarkTypingLineIndex(b *testing.B) {
+	ro := tenMB()
+	at := ro.Len() / 2
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ro = Insert(ro, at, New("x"))
+		at++
+		LineIndex(ro, LineNumber(ro, at))
+	}
+}
@@ -19,10 +19,7 @@ func NewTokenizer(dpi float32) syntax.Tokenizer {
 	)
 	tok, err := syntax.NewRegexpTokenizer(
 		syntax.Regexp{
-			// TODO: Go highlighting for keywords doesn't handle non-ASCII word boundaries.
-			// Ideally, instead of suing [^a-ZA-Z0-9_] for word bourdaries, re1 would have a unicode-aware \b.
-			Regexp: `(^|[^a-zA-Z0-9_])(break|default|func|interface|select|case|defer|go|map|struct|chan|else|goto|package|switch|const|fallthrough|if|range|type|continue|for|import|return|var)([^a-zA-Z0-9_]|$)`,
-			Group:  2,
+			Regexp: `\b(break|default|func|interface|select|case|defer|go|map|struct|chan|else|goto|package|switch|const|fallthrough|if|range|type|continue|for|import|return|var)\b`,
 			Style: text.Style{
 				Face: text.Face(gomedium.TTF, dpi, 11 /* pt */),
 			},
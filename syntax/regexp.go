@@ -1,6 +1,7 @@
 package syntax
 
 import (
+	"context"
 	"errors"
 
 	"github.com/eaburns/T/re1"
@@ -43,16 +44,13 @@ func NewRegexpTokenizer(regexps ...Regexp) (Tokenizer, error) {
 	return &reTokenizer{regexps: regexps, re: re}, nil
 }
 
-func (t *reTokenizer) NextToken(txt rope.Rope) (Highlight, bool) {
-	ms := t.re.FindInRope(txt, 0, txt.Len())
-	if ms == nil {
-		return Highlight{}, false
-	}
-	i := int(ms[len(ms)-1])
-	j := 2 * t.regexps[i].Group
-	h := Highlight{
-		At:    [2]int64{ms[j], ms[j+1]},
-		Style: t.regexps[i].Style,
-	}
-	return h, true
+func (t *reTokenizer) Tokens(ctx context.Context, txt rope.Rope, at int64, f func(Highlight) bool) error {
+	return t.re.FindAllInRopeContext(ctx, txt, at, txt.Len(), func(ms []int64) bool {
+		i := int(ms[len(ms)-1])
+		j := 2 * t.regexps[i].Group
+		return f(Highlight{
+			At:    [2]int64{ms[j], ms[j+1]},
+			Style: t.regexps[i].Style,
+		})
+	})
 }
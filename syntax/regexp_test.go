@@ -1,22 +1,24 @@
 package syntax
 
 import (
+	"context"
 	"image/color"
+	"reflect"
 	"testing"
 
 	"github.com/eaburns/T/rope"
 	"github.com/eaburns/T/text"
 )
 
-func TestRegexpNextToken(t *testing.T) {
+func TestRegexpTokens(t *testing.T) {
 	style0 := text.Style{FG: color.White}
 	style1 := text.Style{FG: color.Black}
 	tests := []struct {
-		name   string
-		res    []Regexp
-		text   string
-		want   Highlight
-		wantOK bool
+		name string
+		res  []Regexp
+		text string
+		at   int64
+		want []Highlight
 	}{
 		{
 			name: "no match",
@@ -30,8 +32,7 @@ func TestRegexpNextToken(t *testing.T) {
 					Style:  style1,
 				},
 			},
-			text:   "xyz",
-			wantOK: false,
+			text: "xyz",
 		},
 		{
 			name: "first match",
@@ -45,9 +46,8 @@ func TestRegexpNextToken(t *testing.T) {
 					Style:  style1,
 				},
 			},
-			text:   "abc",
-			want:   Highlight{At: [2]int64{0, 3}, Style: style0},
-			wantOK: true,
+			text: "abc",
+			want: []Highlight{{At: [2]int64{0, 3}, Style: style0}},
 		},
 		{
 			name: "second match",
@@ -61,9 +61,8 @@ func TestRegexpNextToken(t *testing.T) {
 					Style:  style1,
 				},
 			},
-			text:   "def",
-			want:   Highlight{At: [2]int64{0, 3}, Style: style1},
-			wantOK: true,
+			text: "def",
+			want: []Highlight{{At: [2]int64{0, 3}, Style: style1}},
 		},
 		{
 			name: "skip prefix match",
@@ -77,9 +76,8 @@ func TestRegexpNextToken(t *testing.T) {
 					Style:  style1,
 				},
 			},
-			text:   "XXXXdef",
-			want:   Highlight{At: [2]int64{4, 7}, Style: style1},
-			wantOK: true,
+			text: "XXXXdef",
+			want: []Highlight{{At: [2]int64{4, 7}, Style: style1}},
 		},
 		{
 			name: "sub-group match",
@@ -94,9 +92,66 @@ func TestRegexpNextToken(t *testing.T) {
 					Style:  style1,
 				},
 			},
-			text:   "XXXXabbbc",
-			want:   Highlight{At: [2]int64{5, 8}, Style: style0},
-			wantOK: true,
+			text: "XXXXabbbc",
+			want: []Highlight{{At: [2]int64{5, 8}, Style: style0}},
+		},
+		{
+			name: "multiple matches",
+			res: []Regexp{
+				{
+					Regexp: "abc",
+					Style:  style0,
+				},
+				{
+					Regexp: "def",
+					Style:  style1,
+				},
+			},
+			text: "abc def abc",
+			want: []Highlight{
+				{At: [2]int64{0, 3}, Style: style0},
+				{At: [2]int64{4, 7}, Style: style1},
+				{At: [2]int64{8, 11}, Style: style0},
+			},
+		},
+		{
+			name: "start at",
+			res: []Regexp{
+				{
+					Regexp: "abc",
+					Style:  style0,
+				},
+			},
+			text: "abc abc",
+			at:   1,
+			want: []Highlight{{At: [2]int64{4, 7}, Style: style0}},
+		},
+		{
+			name: "context before start",
+			res: []Regexp{
+				{
+					Regexp: `\babc`,
+					Style:  style0,
+				},
+			},
+			text: "xabc abc",
+			at:   1,
+			want: []Highlight{{At: [2]int64{5, 8}, Style: style0}},
+		},
+		{
+			name: "empty matches",
+			res: []Regexp{
+				{
+					Regexp: "a*",
+					Style:  style0,
+				},
+			},
+			text: "baaac",
+			want: []Highlight{
+				{At: [2]int64{0, 0}, Style: style0},
+				{At: [2]int64{1, 4}, Style: style0},
+				{At: [2]int64{5, 5}, Style: style0},
+			},
 		},
 	}
 	for _, test := range tests {
@@ -107,10 +162,14 @@ func TestRegexpNextToken(t *testing.T) {
 			if err != nil {
 				t.Fatalf("NewRegexpTokenizer(...)=_,%v, want nil", err)
 			}
-			got, ok := tok.NextToken(rope.New(test.text))
-			if got != test.want || ok != test.wantOK {
-				t.Errorf("NextToken(%q)=%v,%v, want %v,%v",
-					test.text, got, ok, test.want, test.wantOK)
+			var got []Highlight
+			err = tok.Tokens(context.Background(), rope.New(test.text), test.at, func(h Highlight) bool {
+				got = append(got, h)
+				return true
+			})
+			if err != nil || !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Tokens(%q, %d)=%v,%v, want %v,nil",
+					test.text, test.at, got, err, test.want)
 			}
 		})
 	}
@@ -1,6 +1,8 @@
 package syntax
 
 import (
+	"context"
+
 	"github.com/eaburns/T/rope"
 	"github.com/eaburns/T/text"
 )
@@ -15,6 +17,9 @@ type Highlight struct {
 
 // Tokenizer returns tokens from a rope.
 type Tokenizer interface {
-	// NextToken next token or false.
-	NextToken(rope.Rope) (Highlight, bool)
+	// Tokens calls f with each successive token
+	// at or after byte index at in the rope,
+	// until there are no more tokens or f returns false.
+	// If the context is done before then, Tokens returns an error.
+	Tokens(ctx context.Context, txt rope.Rope, at int64, f func(Highlight) bool) error
 }
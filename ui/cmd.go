@@ -1,25 +1,25 @@
 package ui
 
 import (
-	"io"
+	"context"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"sync"
 	"unicode"
 	"unicode/utf8"
 
 	"github.com/eaburns/T/edit"
 	"github.com/eaburns/T/re1"
 	"github.com/eaburns/T/rope"
+	"github.com/eaburns/T/syntax"
 )
 
 // execCmd handles 2-click text.
 // c is non-nil
 // s may be nil
 func execCmd(c *Col, s *Sheet, text string) error {
-	switch cmd, _ := splitCmd(text); cmd {
+	switch cmd, arg := splitCmd(text); cmd {
 	case "Del":
 		if s == nil {
 			c.win.Del(c)
@@ -62,6 +62,28 @@ func execCmd(c *Col, s *Sheet, text string) error {
 			return s.body.Paste()
 		}
 
+	case "Edit":
+		if s != nil {
+			return editCmd(c.win, s, arg)
+		}
+
+	case "Look":
+		if arg == "" && s != nil {
+			dot := s.body.dots[1].At
+			arg = rope.Slice(s.body.text, dot[0], dot[1]).String()
+		}
+		return lookText(c, s, arg)
+
+	case "Undo":
+		if s != nil {
+			s.body.Undo()
+		}
+
+	case "Redo":
+		if s != nil {
+			s.body.Redo()
+		}
+
 	default:
 		if text == "" {
 			return nil
@@ -69,9 +91,13 @@ func execCmd(c *Col, s *Sheet, text string) error {
 		if isDir, err := openDir(c, s, text); isDir {
 			return err
 		}
+		if t := strings.TrimSpace(text); s != nil && strings.IndexAny(t, "<>|") == 0 {
+			return pipeCmd(c.win, s, t)
+		}
+		r := shellRunner(s)
 		go func() {
-			if err := shellCmd(c.win, text); err != nil {
-				c.win.OutputString(err.Error())
+			if err := shellCmd(c.win, r, text); err != nil {
+				c.win.OutputString(r.Dir, err.Error()+"\n")
 			}
 		}()
 		return nil
@@ -79,44 +105,127 @@ func execCmd(c *Col, s *Sheet, text string) error {
 	return nil
 }
 
-func shellCmd(w *Win, text string) error {
-	// TODO: set 2-click shell command CWD to the sheet's directory.
-	// If executed from outside of a sheet, then don't set it specifically.
-	cmd := exec.Command("sh", "-c", text)
-	stderr, err := cmd.StderrPipe()
+// editCmd runs an edit on the window's sheets, beginning with the sheet body,
+// writing its output to the sheet directory's +Errors sheet.
+// Shell commands of the edit are run by the sheet's shellRunner.
+func editCmd(w *Win, s *Sheet, t string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), editTimeout)
+	defer cancel()
+	opts := edit.Options{
+		Context:      ctx,
+		ShellContext: context.Background(),
+		Runner:       shellRunner(s),
+	}
+	w.SetCurrent(sheetFile{s})
+	var print strings.Builder
+	fds, err := edit.EditFiles(w, t, &print, opts)
+	if print.Len() > 0 {
+		w.OutputString(sheetDir(s), print.String())
+	}
+	if e, ok := err.(edit.NoCommandError); ok {
+		// Like Sam, an address with no command sets dot.
+		setDot(s.body, 1, e.At[0], e.At[1])
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	stdout, err := cmd.StdoutPipe()
+	for _, fd := range fds {
+		b := fd.File.(sheetFile).body
+		b.Change(fd.Diffs)
+		setDot(b, 1, fd.Dot[0], fd.Dot[1])
+	}
+	return nil
+}
+
+// pipeCmd runs a shell command beginning with <, >, or |
+// on the sheet body's dot, like the corresponding edit commands.
+//
+// The command runs asynchronously.
+// When it finishes, its changes are rebased onto
+// any changes made to the body in the meantime,
+// and dot is set to the text written by the command, if any.
+func pipeCmd(w *Win, s *Sheet, t string) error {
+	p, err := edit.Parse(t)
 	if err != nil {
-		stderr.Close()
 		return err
 	}
-	if err := cmd.Start(); err != nil {
-		stderr.Close()
-		stdout.Close()
-		return err
+	b := s.body
+	dot, text, r := b.dots[1].At, b.text, shellRunner(s)
+	go func() {
+		var print strings.Builder
+		diffs, dot, err := p.ExecOptions(dot, text, &print, edit.Options{Runner: r})
+		w.call(func() {
+			if print.Len() > 0 {
+				w.OutputString(r.Dir, print.String())
+			}
+			if err != nil {
+				w.OutputString(r.Dir, err.Error()+"\n")
+				return
+			}
+			if len(diffs) == 0 {
+				return
+			}
+			// The diffs are of the text when the command started.
+			interim, diffs := edit.Transform(edit.DiffRopes(text, b.text), diffs)
+			b.Change(diffs)
+			dot = interim.Update(dot)
+			setDot(b, 1, dot[0], dot[1])
+		})
+	}()
+	return nil
+}
+
+// shellRunner returns an edit.ExecRunner for shell commands run from a sheet.
+// Commands are run by /bin/sh, regardless of $SHELL,
+// in the directory of the sheet's title,
+// with the title in $samfile and $%,
+// and the rune address of the body's dot in $dot, as #m,#n.
+// If the sheet is nil, commands run in the current directory.
+func shellRunner(s *Sheet) edit.ExecRunner {
+	if s == nil {
+		return edit.ExecRunner{Shell: "/bin/sh"}
+	}
+	title := s.Title()
+	dot := s.body.dots[1].At
+	m := rope.RuneNumber(s.body.text, dot[0])
+	n := rope.RuneNumber(s.body.text, dot[1])
+	return edit.ExecRunner{
+		Shell: "/bin/sh",
+		Dir:   sheetDir(s),
+		Env: []string{
+			"samfile=" + title,
+			"%=" + title,
+			"dot=#" + strconv.FormatInt(m, 10) + ",#" + strconv.FormatInt(n, 10),
+		},
 	}
-	var wg sync.WaitGroup
-	wg.Add(2)
-	go pipeOutput(&wg, w, stdout)
-	go pipeOutput(&wg, w, stderr)
-	wg.Wait()
-	return cmd.Wait()
 }
 
-func pipeOutput(wg *sync.WaitGroup, w *Win, pipe io.Reader) {
-	defer wg.Done()
-	var buf [4096]byte
-	for {
-		n, err := pipe.Read(buf[:])
-		if n > 0 {
-			w.OutputBytes(buf[:n])
-		}
-		if err != nil {
-			return
-		}
+// shellCmd runs a shell command,
+// writing its output to the +Errors sheet of the runner's directory.
+func shellCmd(w *Win, r edit.ExecRunner, text string) error {
+	out := outputWriter{w: w, dir: r.Dir}
+	return r.Run(context.Background(), text, nil, out, out)
+}
+
+// outputWriter is an io.Writer that writes to the +Errors sheet of a directory.
+type outputWriter struct {
+	w   *Win
+	dir string
+}
+
+func (o outputWriter) Write(data []byte) (int, error) {
+	o.w.OutputBytes(o.dir, data)
+	return len(data), nil
+}
+
+// sheetDir returns the directory of the sheet's title,
+// or the empty string, the current directory, if the sheet is nil.
+func sheetDir(s *Sheet) string {
+	if s == nil {
+		return ""
 	}
+	return filepath.Dir(s.Title())
 }
 
 func lookText(c *Col, s *Sheet, text string) error {
@@ -124,17 +233,18 @@ func lookText(c *Col, s *Sheet, text string) error {
 		return nil
 	}
 
-	path, err := abs(s, text)
+	file, addr, col := splitAddr(text)
+	path, err := abs(s, file)
 	if err != nil {
 		setLook(c, s, text)
 		return nil
 	}
 
-	if focusSheet(c.win, path) {
-		return nil
+	if s := focusSheet(c.win, path); s != nil {
+		return showSheetAddr(s, addr, col)
 	}
-	if focusSheet(c.win, ensureTrailingSlash(path)) {
-		return nil
+	if s := focusSheet(c.win, ensureTrailingSlash(path)); s != nil {
+		return showSheetAddr(s, addr, col)
 	}
 
 	f, err := os.Open(path)
@@ -148,26 +258,151 @@ func lookText(c *Col, s *Sheet, text string) error {
 		return err
 	}
 	c.Add(s)
+	return showSheetAddr(s, addr, col)
+}
+
+// splitAddr splits text of the form path:addr
+// into the path, an edit address, and a column.
+// The address is empty if there is none.
+//
+// A trailing colon is ignored, as in compiler output.
+// An address line:col is split into the address of the line
+// and the column, the byte offset into the line counting from 1,
+// also as in compiler output.
+// The column is 0 if there is none.
+func splitAddr(text string) (string, string, int) {
+	text = strings.TrimSuffix(text, ":")
+	i := strings.IndexRune(text, ':')
+	if i < 0 {
+		return text, "", 0
+	}
+	path, addr := text[:i], text[i+1:]
+	if j := strings.IndexRune(addr, ':'); j >= 0 {
+		_, err0 := strconv.Atoi(addr[:j])
+		col, err1 := strconv.Atoi(addr[j+1:])
+		if err0 == nil && err1 == nil && col > 0 {
+			return path, addr[:j], col
+		}
+	}
+	return path, addr, 0
+}
+
+// showSheetAddr selects and shows an address in the sheet body.
+// If col is greater than 0, the point before byte col of the address,
+// counting from 1, is selected instead; see splitAddr.
+// If the address is empty, it does nothing.
+func showSheetAddr(s *Sheet, addr string, col int) error {
+	if addr == "" {
+		return nil
+	}
+	a, err := edit.Addr([2]int64{}, addr, s.body.text)
+	if err != nil {
+		return err
+	}
+	if col > 0 {
+		line := strings.TrimSuffix(rope.Slice(s.body.text, a[0], a[1]).String(), "\n")
+		i := len(line)
+		if col-1 < i {
+			i = col - 1
+		}
+		// Round down to the start of a rune.
+		for i > 0 && i < len(line) && !utf8.RuneStart(line[i]) {
+			i--
+		}
+		a[0] += int64(i)
+		a[1] = a[0]
+	}
+	setDot(s.body, 1, a[0], a[1])
+	showAddr(s.body, a[0])
 	return nil
 }
 
-func focusSheet(w *Win, title string) bool {
+// focusSheet focuses and returns the sheet with the given title,
+// or returns nil if there is no such sheet.
+func focusSheet(w *Win, title string) *Sheet {
+	c, s := findSheet(w, title)
+	if s == nil {
+		return nil
+	}
+	setWinFocus(w, c)
+	setColFocus(c, s)
+	return s
+}
+
+// findSheet returns the sheet with the given title and its column,
+// or nil if there is no such sheet.
+func findSheet(w *Win, title string) (*Col, *Sheet) {
 	for _, c := range w.cols {
 		for _, r := range c.rows {
-			s, ok := r.(*Sheet)
-			if !ok || s.Title() != title {
-				continue
+			if s, ok := r.(*Sheet); ok && s.Title() == title {
+				return c, s
 			}
-			setWinFocus(w, c)
-			setColFocus(c, s)
-			return true
 		}
 	}
-	return false
+	return nil, nil
 }
 
+// setLook selects the next occurrence of text in the sheet body
+// after dot, wrapping around at the end,
+// moves the mouse pointer to it,
+// and highlights the other occurrences that are visible.
 func setLook(c *Col, s *Sheet, text string) {
-	// TODO: 3-clicking a non-file should highlight matches in the sheet.
+	if s == nil || text == "" {
+		return
+	}
+	b := s.body
+	n := int64(len(text))
+	at := rope.Index(b.text, text, b.dots[1].At[1])
+	if at < 0 {
+		at = rope.Index(b.text, text, 0)
+	}
+	if at < 0 {
+		b.highlight = nil
+		dirtyLines(b)
+		return
+	}
+	setDot(b, 1, at, at+n)
+	if dirtyDot(b, [2]int64{at, at}) {
+		showAddr(b, at)
+	}
+	warpToAddr(c.win, s, at)
+
+	var hi []syntax.Highlight
+	vis1 := b.at
+	for _, l := range b.lines() {
+		vis1 += l.n
+	}
+	vis := rope.Slice(b.text, b.at, vis1)
+	for i := rope.Index(vis, text, 0); i >= 0; i = rope.Index(vis, text, i+n) {
+		if i+b.at != at {
+			hi = append(hi, syntax.Highlight{
+				At:    [2]int64{i + b.at, i + b.at + n},
+				Style: b.dots[3].Style,
+			})
+		}
+	}
+	b.highlight = hi
+	dirtyLines(b)
+}
+
+// warpToAddr moves the mouse pointer to an address in the sheet body,
+// if the address is visible.
+func warpToAddr(w *Win, s *Sheet, at int64) {
+	if w.warp == nil {
+		return
+	}
+	pt, ok := addrPoint(s.body, at)
+	if !ok {
+		return
+	}
+	for i, c := range w.cols {
+		if j := rowIndex(c, s); j >= 0 {
+			pt.X += x0(w, i) + textPadPx
+			pt.Y += y0(c, j) + s.tagH
+			w.warp(pt)
+			return
+		}
+	}
 }
 
 func openDir(c *Col, s *Sheet, path string) (bool, error) {
@@ -1,10 +1,15 @@
 package ui
 
 import (
+	"image"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/eaburns/T/edit"
 	"github.com/eaburns/T/rope"
 )
 
@@ -178,6 +183,219 @@ sub/c
 	}
 }
 
+func TestCmd_edit(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		dot    [2]int64
+		exec   string
+		want   string
+		dot1   [2]int64
+		output string
+		err    bool
+	}{
+		{
+			name: "change",
+			body: "foo bar foo",
+			exec: "Edit ,x/foo/c/baz/",
+			want: "baz bar baz",
+			dot1: [2]int64{8, 11},
+		},
+		{
+			name: "uses dot",
+			body: "foo bar foo",
+			dot:  [2]int64{4, 7},
+			exec: "Edit d",
+			want: "foo  foo",
+			dot1: [2]int64{4, 4},
+		},
+		{
+			name: "address sets dot",
+			body: "foo bar foo",
+			exec: "Edit /bar/",
+			want: "foo bar foo",
+			dot1: [2]int64{4, 7},
+		},
+		{
+			name:   "print",
+			body:   "foo\nbar\n",
+			exec:   "Edit 2p",
+			want:   "foo\nbar\n",
+			dot1:   [2]int64{4, 8},
+			output: "bar\n",
+		},
+		{
+			name:   "loop without changes",
+			body:   "foo bar foo",
+			exec:   "Edit ,x/foo/p",
+			want:   "foo bar foo",
+			dot1:   [2]int64{8, 11},
+			output: "foofoo",
+		},
+		{
+			name:   "print address",
+			body:   "foo\nbar\n",
+			exec:   "Edit /bar/=",
+			want:   "foo\nbar\n",
+			dot1:   [2]int64{4, 7},
+			output: "2\n",
+		},
+		{
+			name: "error",
+			body: "foo",
+			exec: "Edit /bar/d",
+			want: "foo",
+			err:  true,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				w = newTestWin()
+				c = w.cols[0]
+				s = NewSheet(w, "")
+			)
+			s.SetText(rope.New(test.body))
+			s.body.dots[1].At = test.dot
+			c.Add(s)
+			switch err := execCmd(c, s, test.exec); {
+			case test.err && err == nil:
+				t.Fatalf("execCmd(.., %q)=nil, want an error", test.exec)
+			case !test.err && err != nil:
+				t.Fatalf("execCmd(.., %q)=%v, want nil", test.exec, err)
+			}
+			if str := s.body.text.String(); str != test.want {
+				t.Errorf("body=%q, want %q", str, test.want)
+			}
+			if !test.err && s.body.dots[1].At != test.dot1 {
+				t.Errorf("dot=%v, want %v", s.body.dots[1].At, test.dot1)
+			}
+			if out := pendingOutputText(w, s); out != test.output {
+				t.Errorf("output=%q, want %q", out, test.output)
+			}
+		})
+	}
+}
+
+func TestCmd_editFiles(t *testing.T) {
+	var (
+		w = newTestWin()
+		c = w.cols[0]
+		a = NewSheet(w, "/a.txt")
+		b = NewSheet(w, "/b.txt")
+	)
+	a.SetText(rope.New("foo a foo"))
+	b.SetText(rope.New("foo b"))
+	c.Add(a)
+	c.Add(b)
+	const exec = `Edit X/\.txt$/ ,x/foo/c/bar/`
+	if err := execCmd(c, a, exec); err != nil {
+		t.Fatalf("execCmd(.., %q)=%v, want nil", exec, err)
+	}
+	for _, test := range []struct {
+		s    *Sheet
+		want string
+		dot  [2]int64
+	}{
+		{s: a, want: "bar a bar", dot: [2]int64{6, 9}},
+		{s: b, want: "bar b", dot: [2]int64{0, 3}},
+	} {
+		if str := test.s.body.text.String(); str != test.want {
+			t.Errorf("%s body=%q, want %q", test.s.Title(), str, test.want)
+		}
+		if dot := test.s.body.dots[1].At; dot != test.dot {
+			t.Errorf("%s dot=%v, want %v", test.s.Title(), dot, test.dot)
+		}
+	}
+	if s := getSheet(w.Col.Row); s != a {
+		t.Errorf("focused sheet=%v, want %s", s, a.Title())
+	}
+}
+
+func TestLook_setLook(t *testing.T) {
+	var (
+		w = newTestWin()
+		c = w.cols[0]
+		s = NewSheet(w, "")
+	)
+	c.Add(s)
+	c.Resize(image.Pt(800, 600))
+	s.SetText(rope.New("foo bar foo baz foo"))
+
+	tests := []struct {
+		look string
+		dot  [2]int64
+		hi   [][2]int64
+	}{
+		{look: "foo", dot: [2]int64{0, 3}, hi: [][2]int64{{8, 11}, {16, 19}}},
+		{look: "foo", dot: [2]int64{8, 11}, hi: [][2]int64{{0, 3}, {16, 19}}},
+		{look: "foo", dot: [2]int64{16, 19}, hi: [][2]int64{{0, 3}, {8, 11}}},
+		{look: "foo", dot: [2]int64{0, 3}, hi: [][2]int64{{8, 11}, {16, 19}}},
+		{look: "baz", dot: [2]int64{12, 15}},
+		{look: "none", dot: [2]int64{12, 15}},
+	}
+	var warped []image.Point
+	w.SetWarp(func(pt image.Point) { warped = append(warped, pt) })
+	for _, test := range tests {
+		warped = nil
+		if err := lookText(c, s, test.look); err != nil {
+			t.Fatalf("lookText(.., %q) failed: %v", test.look, err)
+		}
+		var hi [][2]int64
+		for _, h := range s.body.highlight {
+			hi = append(hi, h.At)
+		}
+		if s.body.dots[1].At != test.dot || !reflect.DeepEqual(hi, test.hi) {
+			t.Errorf("lookText(.., %q): dot=%v, highlight=%v, want %v, %v",
+				test.look, s.body.dots[1].At, hi, test.dot, test.hi)
+		}
+		if test.look == "none" {
+			if len(warped) > 0 {
+				t.Errorf("lookText(.., %q): warped to %v, want no warp", test.look, warped)
+			}
+			continue
+		}
+		if len(warped) != 1 {
+			t.Fatalf("lookText(.., %q): warped to %v, want 1 warp", test.look, warped)
+		}
+		pt := warped[0].Sub(image.Pt(textPadPx, y0(c, rowIndex(c, s))+s.tagH))
+		if at, _ := atPoint(s.body, pt); at != test.dot[0] {
+			t.Errorf("lookText(.., %q): warped to %v, address %d, want %d",
+				test.look, warped[0], at, test.dot[0])
+		}
+	}
+}
+
+func TestLook_clearHighlight(t *testing.T) {
+	var (
+		w = newTestWin()
+		c = w.cols[0]
+		s = NewSheet(w, "")
+	)
+	c.Add(s)
+	c.Resize(image.Pt(800, 600))
+	s.SetText(rope.New(strings.Repeat("foo\n", 100)))
+
+	setLook(c, s, "foo")
+	if len(s.body.highlight) == 0 {
+		t.Fatalf("setLook(.., \"foo\"): no highlight")
+	}
+	s.body.Change(edit.Diffs{{At: [2]int64{0, 0}, Text: rope.New("x")}})
+	if len(s.body.highlight) != 0 {
+		t.Errorf("after a change, highlight=%v, want none", s.body.highlight)
+	}
+
+	setLook(c, s, "foo")
+	if len(s.body.highlight) == 0 {
+		t.Fatalf("setLook(.., \"foo\"): no highlight")
+	}
+	scrollDown(s.body, 1)
+	if len(s.body.highlight) != 0 {
+		t.Errorf("after a scroll, highlight=%v, want none", s.body.highlight)
+	}
+}
+
 func TestLook_empty(t *testing.T) {
 	var (
 		w = newTestWin()
@@ -309,3 +527,253 @@ func TestLook_focusExistingSheet(t *testing.T) {
 		t.Errorf("sheet not focused, wanted it to be focused")
 	}
 }
+
+func TestLook_addr(t *testing.T) {
+	dir := tmpdir()
+	defer os.RemoveAll(dir)
+	const text = "line 1\nline 2\nline 3\nαβγ 4\n"
+	path := filepath.Join(dir, "a")
+	write(path, text)
+
+	tests := []struct {
+		look string
+		dot  [2]int64
+		err  bool
+	}{
+		{look: path, dot: [2]int64{0, 0}},
+		{look: path + ":", dot: [2]int64{0, 0}},
+		{look: path + ":2", dot: [2]int64{7, 14}},
+		{look: path + ":2:", dot: [2]int64{7, 14}},
+		{look: path + ":3:6", dot: [2]int64{19, 19}},
+		{look: path + ":3:6:", dot: [2]int64{19, 19}},
+		{look: path + ":4:5", dot: [2]int64{25, 25}},
+		{look: path + ":4:6", dot: [2]int64{25, 25}},
+		{look: path + ":4:100", dot: [2]int64{29, 29}},
+		{look: path + ":#3", dot: [2]int64{3, 3}},
+		{look: path + ":/e 2/", dot: [2]int64{10, 13}},
+		{look: path + ":/none/", err: true},
+	}
+	for _, test := range tests {
+		for _, open := range []bool{false, true} {
+			var (
+				w = newTestWin()
+				c = w.cols[0]
+			)
+			if open {
+				// Look in an already-open sheet.
+				if err := lookText(c, nil, path); err != nil {
+					t.Fatalf("lookText(.., %q) failed: %v", path, err)
+				}
+			}
+			switch err := lookText(c, nil, test.look); {
+			case test.err && err == nil:
+				t.Errorf("lookText(.., %q)=nil, want an error", test.look)
+				continue
+			case test.err:
+				continue
+			case err != nil:
+				t.Errorf("lookText(.., %q) failed: %v", test.look, err)
+				continue
+			}
+			if len(c.rows) != 2 {
+				t.Fatalf("lookText(.., %q): %d rows, wanted 2", test.look, len(c.rows))
+			}
+			s := c.rows[1].(*Sheet)
+			if s.Title() != path || c.Row != s {
+				t.Errorf("lookText(.., %q): focused %q, want %q", test.look, s.Title(), path)
+			}
+			if s.body.dots[1].At != test.dot {
+				t.Errorf("lookText(.., %q): dot=%v, want %v", test.look, s.body.dots[1].At, test.dot)
+			}
+		}
+	}
+}
+
+func TestCmd_look(t *testing.T) {
+	var (
+		w = newTestWin()
+		c = w.cols[0]
+		s = NewSheet(w, "")
+	)
+	c.Add(s)
+	s.SetText(rope.New("foo bar foo"))
+	s.body.dots[1].At = [2]int64{0, 3}
+
+	if err := execCmd(c, s, "Look bar"); err != nil {
+		t.Fatalf("execCmd(.., Look bar) failed: %v", err)
+	}
+	if dot := s.body.dots[1].At; dot != [2]int64{4, 7} {
+		t.Errorf("Look bar: dot=%v, want [4 7]", dot)
+	}
+	s.body.dots[1].At = [2]int64{0, 3}
+	if err := execCmd(c, s, "Look"); err != nil {
+		t.Fatalf("execCmd(.., Look) failed: %v", err)
+	}
+	if dot := s.body.dots[1].At; dot != [2]int64{8, 11} {
+		t.Errorf("Look: dot=%v, want [8 11]", dot)
+	}
+}
+
+func TestCmd_pipe(t *testing.T) {
+	dir := tmpdir()
+	defer os.RemoveAll(dir)
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatalf("failed to evaluate symlinks: %v", err)
+	}
+	title := filepath.Join(dir, "file")
+
+	tests := []struct {
+		name string
+		body string
+		dot  [2]int64
+		exec string
+		// change, if non-nil, is applied to the body
+		// while the command is running.
+		change edit.Diffs
+		want   string
+		dot1   [2]int64
+		output string
+	}{
+		{
+			name: "pipe",
+			body: "x\nb\na\ny\n",
+			dot:  [2]int64{2, 6},
+			exec: "|sort",
+			want: "x\na\nb\ny\n",
+			dot1: [2]int64{2, 6},
+		},
+		{
+			name: "read",
+			body: "x\ny\n",
+			dot:  [2]int64{2, 4},
+			exec: "< echo hello",
+			want: "x\nhello\n",
+			dot1: [2]int64{2, 8},
+		},
+		{
+			name:   "write",
+			body:   "x\ny\n",
+			dot:    [2]int64{2, 4},
+			exec:   ">cat",
+			want:   "x\ny\n",
+			dot1:   [2]int64{2, 4},
+			output: "y\n",
+		},
+		{
+			name: "environment",
+			body: "αβγ",
+			dot:  [2]int64{2, 4},
+			exec: `<echo "$(pwd) $samfile $dot"`,
+			want: "α" + dir + " " + title + " #1,#2\nγ",
+			dot1: [2]int64{2, 2 + int64(len(dir)+len(title)+8)},
+		},
+		{
+			name:   "change before",
+			body:   "x\nb\na\ny\n",
+			dot:    [2]int64{2, 6},
+			exec:   "|sort",
+			change: edit.Diffs{{At: [2]int64{0, 1}, Text: rope.New("XYZ")}},
+			want:   "XYZ\na\nb\ny\n",
+			dot1:   [2]int64{4, 8},
+		},
+		{
+			name:   "change after",
+			body:   "x\ny\n",
+			dot:    [2]int64{0, 2},
+			exec:   "< echo hello",
+			change: edit.Diffs{{At: [2]int64{4, 4}, Text: rope.New("z\n")}},
+			want:   "hello\ny\nz\n",
+			dot1:   [2]int64{0, 6},
+		},
+		{
+			name:   "change inside",
+			body:   "x\nb\na\ny\n",
+			dot:    [2]int64{2, 6},
+			exec:   "|sort",
+			change: edit.Diffs{{At: [2]int64{0, 0}, Text: rope.New("0")}, {At: [2]int64{5, 5}, Text: rope.New("!")}},
+			want:   "0x\n!a\nb\ny\n",
+			dot1:   [2]int64{4, 8},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				w = newTestWin()
+				c = w.cols[0]
+				s = NewSheet(w, title)
+			)
+			s.SetText(rope.New(test.body))
+			s.body.dots[1].At = test.dot
+			c.Add(s)
+			if err := execCmd(c, s, test.exec); err != nil {
+				t.Fatalf("execCmd(.., %q) failed: %v", test.exec, err)
+			}
+			s.body.Change(test.change)
+			waitCalls(t, w)
+			if str := s.body.text.String(); str != test.want {
+				t.Errorf("body=%q, want %q", str, test.want)
+			}
+			if s.body.dots[1].At != test.dot1 {
+				t.Errorf("dot=%v, want %v", s.body.dots[1].At, test.dot1)
+			}
+			if out := pendingOutputText(w, s); out != test.output {
+				t.Errorf("output=%q, want %q", out, test.output)
+			}
+		})
+	}
+}
+
+func TestShellCmd(t *testing.T) {
+	dir := tmpdir()
+	defer os.RemoveAll(dir)
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatalf("failed to evaluate symlinks: %v", err)
+	}
+	// Commands use /bin/sh, not $SHELL.
+	defer os.Setenv("SHELL", os.Getenv("SHELL"))
+	os.Setenv("SHELL", "/bin/false")
+	var (
+		w = newTestWin()
+		s = NewSheet(w, filepath.Join(dir, "file"))
+	)
+	if err := shellCmd(w, shellRunner(s), `pwd; echo "$samfile" 1>&2`); err != nil {
+		t.Fatalf("shellCmd failed: %v", err)
+	}
+	want := dir + "\n" + s.Title() + "\n"
+	if out := pendingOutputText(w, s); out != want {
+		t.Errorf("output=%q, want %q", out, want)
+	}
+}
+
+// waitCalls waits for a call to be arranged on the window
+// and then makes the arranged calls.
+func waitCalls(t *testing.T, w *Win) {
+	for i := 0; ; i++ {
+		w.mu.Lock()
+		n := len(w.calls)
+		w.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		if i == 1000 {
+			t.Fatalf("timed out waiting for a call")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	runCalls(w)
+}
+
+// pendingOutputText returns the output not yet added
+// to the +Errors sheet of the sheet's directory.
+func pendingOutputText(w *Win, s *Sheet) string {
+	title := errorsTitle(sheetDir(s))
+	for _, o := range w.output {
+		if o.title == title {
+			return string(o.data)
+		}
+	}
+	return ""
+}
@@ -342,7 +342,7 @@ func (c *Col) Click(pt image.Point, button int) {
 			err = lookText(c, s, txt)
 		}
 		if err != nil {
-			c.win.OutputString(err.Error() + "\n")
+			c.win.OutputString(sheetDir(s), err.Error()+"\n")
 		}
 	}
 }
@@ -1,6 +1,7 @@
 package ui
 
 import (
+	"context"
 	"fmt"
 	"sort"
 	"time"
@@ -14,7 +15,14 @@ type highlighter struct {
 	syntax.Tokenizer
 }
 
+// highlightTimeout is the maximum time spent tokenizing for an Update.
+// After the timeout, the text following the tokenized portion
+// keeps its previous highlights.
+const highlightTimeout = time.Second
+
 func (h *highlighter) Update(hi []syntax.Highlight, diffs edit.Diffs, txt rope.Rope) (res []syntax.Highlight) {
+	ctx, cancel := context.WithTimeout(context.Background(), highlightTimeout)
+	defer cancel()
 	t0 := time.Now()
 	defer func() {
 		dur := time.Since(t0)
@@ -23,7 +31,7 @@ func (h *highlighter) Update(hi []syntax.Highlight, diffs edit.Diffs, txt rope.R
 		}
 	}()
 	if len(diffs) == 0 {
-		hi = update(h.Tokenizer, hi, nil, txt)
+		hi = update(ctx, h.Tokenizer, hi, nil, txt)
 		return hi
 	}
 	for _, diff := range diffs {
@@ -40,30 +48,27 @@ func (h *highlighter) Update(hi []syntax.Highlight, diffs edit.Diffs, txt rope.R
 		for len(tail) > 0 && tail[0].At[0] == tail[0].At[1] {
 			tail = tail[1:]
 		}
-		hi = update(h.Tokenizer, hi, tail, txt)
+		hi = update(ctx, h.Tokenizer, hi, tail, txt)
 	}
 	return hi
 }
 
-func update(tok syntax.Tokenizer, hi, tail []syntax.Highlight, txt rope.Rope) []syntax.Highlight {
+func update(ctx context.Context, tok syntax.Tokenizer, hi, tail []syntax.Highlight, txt rope.Rope) []syntax.Highlight {
 	var at int64
 	if len(hi) > 0 {
 		at = hi[len(hi)-1].At[1]
 	}
-	for {
-		h, ok := tok.NextToken(rope.Slice(txt, at, txt.Len()))
-		if !ok {
-			return append(hi, tail...)
-		}
-		h.At[0] += at
-		h.At[1] += at
+	// If the context is done, the rest of the tail is kept.
+	tok.Tokens(ctx, txt, at, func(h syntax.Highlight) bool {
 		if len(tail) > 0 && tail[0] == h {
-			return append(hi, tail...)
+			// The rest of the highlights are unchanged.
+			return false
 		}
 		for len(tail) > 0 && tail[0].At[0] < h.At[1] {
 			tail = tail[1:]
 		}
-		at = h.At[1]
 		hi = append(hi, h)
-	}
+		return true
+	})
+	return append(hi, tail...)
 }
@@ -62,6 +62,14 @@ func NewSheet(w *Win, title string) *Sheet {
 // Body returns the sheet's body text box.
 func (s *Sheet) Body() *TextBox { return s.body }
 
+// sheetFile is the edit.File of a sheet's body.
+type sheetFile struct{ *Sheet }
+
+func (f sheetFile) Name() string      { return f.Title() }
+func (f sheetFile) Text() rope.Rope   { return f.body.text }
+func (f sheetFile) Dot() [2]int64     { return f.body.dots[1].At }
+func (f sheetFile) Marks() edit.Marks { return f.body.marks }
+
 // Tick handles tic events.
 func (s *Sheet) Tick() bool {
 	redraw1 := s.body.Tick()
@@ -275,6 +283,13 @@ func getText(s *Sheet, f *os.File) error {
 	if err != nil {
 		return err
 	}
+	if s.body.text.Len() > 0 {
+		// Only change what changed in the file,
+		// so dot, marks, and the scroll position are kept,
+		// and the Get can be undone.
+		s.body.Change(edit.DiffRopes(s.body.text, txt))
+		return nil
+	}
 	s.body.setHighlighter(nil)
 	s.body.SetText(txt)
 	s.body.setHighlighter(syntaxHighlighter(s.win.dpi, s.Title()))
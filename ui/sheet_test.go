@@ -97,6 +97,37 @@ func TestSheetGet_TextFile(t *testing.T) {
 	}
 }
 
+func TestSheetGet_KeepsDot(t *testing.T) {
+	dir := tmpdir()
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file")
+
+	write(path, "Hello, World!")
+	sh := NewSheet(testWin, path)
+	if err := sh.Get(); err != nil {
+		t.Fatalf("Get()=%v, want nil", err)
+	}
+	sh.body.dots[1].At = [2]int64{7, 12}
+
+	const text = "Hi, World!!"
+	write(path, text)
+	if err := sh.Get(); err != nil {
+		t.Fatalf("Get()=%v, want nil", err)
+	}
+	if s := sh.body.text.String(); s != text {
+		t.Errorf("body text is %q, want %q", s, text)
+	}
+	if dot := sh.body.dots[1].At; dot != [2]int64{4, 9} {
+		t.Errorf("dot is %v, want [4 9]", dot)
+	}
+	if !sh.body.Undo() {
+		t.Fatalf("Undo()=false, want true")
+	}
+	if s := sh.body.text.String(); s != "Hello, World!" {
+		t.Errorf("body text after Undo is %q, want %q", s, "Hello, World!")
+	}
+}
+
 func TestSheetGet_Dir(t *testing.T) {
 	dir := tmpdir()
 	defer os.RemoveAll(dir)
@@ -2,6 +2,7 @@ package ui
 
 import (
 	"bufio"
+	"context"
 	"image"
 	"image/color"
 	"image/draw"
@@ -54,11 +55,23 @@ type TextBox struct {
 	syntax      []syntax.Highlight  // syntax highlighting
 	highlighter updater             // syntax highlighter
 
+	undo, redo []change // undo and redo history, most recent last
+	typeAt     int64    // address just after the last typed rune, or -1
+	marks      edit.Marks
+
 	dirty  bool
 	_lines []line
 	now    func() time.Time
 }
 
+// A change is an entry in the undo or redo history.
+type change struct {
+	// diffs are the diffs that, applied to the text, revert the change.
+	diffs edit.Diffs
+	// dot is the value of dot before the change was made.
+	dot [2]int64
+}
+
 type line struct {
 	dirty bool
 	n     int64
@@ -91,6 +104,8 @@ func NewTextBox(w *Win, styles [4]text.Style, size image.Point) *TextBox {
 			{Style: styles[3]},
 		},
 		cursorCol: -1,
+		typeAt:    -1,
+		marks:     make(edit.Marks),
 		now:       func() time.Time { return time.Now() },
 	}
 	return b
@@ -101,8 +116,13 @@ func (b *TextBox) Text() rope.Rope { return b.text }
 
 // SetText sets the text of the text box.
 // The text box always must be redrawn after setting the text.
+// Setting the text clears the undo and redo history and the marks.
 func (b *TextBox) SetText(text rope.Rope) {
 	b.text = text
+	b.undo = nil
+	b.redo = nil
+	b.typeAt = -1
+	b.marks = make(edit.Marks)
 
 	b.at = 0
 	b.cursorCol = -1
@@ -158,29 +178,75 @@ func (b *TextBox) setHighlighter(highlighter updater) {
 // If more than 0 diffs are returned, the text box needs to be redrawn.
 func (b *TextBox) Edit(t string) (edit.Diffs, error) { return ed(b, t) }
 
+// editTimeout is the maximum time for the searches of an edit.
+// Shell commands are not subject to the timeout.
+const editTimeout = 5 * time.Second
+
 func ed(b *TextBox, t string) (edit.Diffs, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), editTimeout)
+	defer cancel()
 	dot := b.dots[1].At
-	diffs, err := edit.Edit(dot, t, ioutil.Discard, b.text)
+	opts := edit.Options{
+		Context:      ctx,
+		ShellContext: context.Background(),
+		Marks:        b.marks,
+	}
+	diffs, dot, err := edit.EditOptions(dot, t, ioutil.Discard, b.text, opts)
 	if err != nil {
 		return nil, err
 	}
-	if len(diffs) > 0 {
-		dot := b.dots[1].At
-		b.Change(diffs)
-		dot[0] = diffs[len(diffs)-1].At[0]
-		dot[1] = dot[0] + diffs[len(diffs)-1].TextLen()
-		b.dots[1].At = dot
-	}
+	b.Change(diffs)
+	setDot(b, 1, dot[0], dot[1])
 	return diffs, nil
 }
 
 // Change applies a set of diffs to the text box.
+// The change is recorded in the undo history,
+// and the redo history is cleared.
 func (b *TextBox) Change(diffs edit.Diffs) {
 	if len(diffs) == 0 {
 		return
 	}
+	dot := b.dots[1].At
+	b.undo = append(b.undo, change{diffs: apply(b, diffs), dot: dot})
+	b.redo = b.redo[:0]
+	b.typeAt = -1
+}
+
+// Undo reverts the most recent change in the undo history
+// and restores dot to its value before the change.
+// It returns whether there was a change to undo.
+func (b *TextBox) Undo() bool { return undoRedo(b, &b.undo, &b.redo) }
+
+// Redo re-applies the most recently undone change
+// and restores dot to its value before the change was undone.
+// It returns whether there was a change to redo.
+func (b *TextBox) Redo() bool { return undoRedo(b, &b.redo, &b.undo) }
+
+// undoRedo pops a change from the from history, applies it,
+// and pushes its inverse onto the to history.
+func undoRedo(b *TextBox, from, to *[]change) bool {
+	n := len(*from)
+	if n == 0 {
+		return false
+	}
+	c := (*from)[n-1]
+	*from = (*from)[:n-1]
+	dot := b.dots[1].At
+	*to = append(*to, change{diffs: apply(b, c.diffs), dot: dot})
+	b.typeAt = -1
+	b.cursorCol = -1
+	setDot(b, 1, c.dot[0], c.dot[1])
+	return true
+}
+
+// apply applies diffs to the text,
+// updating all addresses that refer into the text,
+// and returns the diffs that undo the change.
+func apply(b *TextBox, diffs edit.Diffs) edit.Diffs {
 	dirtyLines(b)
-	b.text, _ = diffs.Apply(b.text)
+	var undo edit.Diffs
+	b.text, undo = diffs.Apply(b.text)
 
 	// TODO: if something else deletes \n before TextBox.at, scroll up
 	// to the beginning of the previous line.
@@ -192,9 +258,10 @@ func (b *TextBox) Change(diffs edit.Diffs) {
 	if b.highlighter != nil {
 		b.syntax = b.highlighter.Update(b.syntax, diffs, b.text)
 	}
-	for i := range b.highlight {
-		b.highlight[i].At = diffs.Update(b.highlight[i].At)
-	}
+	// Highlighted words are of the old text.
+	b.highlight = nil
+	b.marks.Update(diffs)
+	return undo
 }
 
 // Copy copies the selected text into the system clipboard.
@@ -607,16 +674,9 @@ func upDown(b *TextBox, dir string) int64 {
 }
 
 func cursorCol(b *TextBox) int {
-	var n int
-	rr := rope.NewReverseReader(rope.Slice(b.text, 0, b.dots[1].At[0]))
-	for {
-		r, _, err := rr.ReadRune()
-		if err != nil || r == '\n' {
-			break
-		}
-		n++
-	}
-	return n
+	at := b.dots[1].At[0]
+	bol := rope.LineIndex(b.text, rope.LineNumber(b.text, at))
+	return int(rope.RuneNumber(b.text, at) - rope.RuneNumber(b.text, bol))
 }
 
 func scrollUp(b *TextBox, delta int) {
@@ -640,6 +700,7 @@ func scrollUp(b *TextBox, delta int) {
 			break
 		}
 	}
+	b.highlight = nil
 	dirtyLines(b)
 }
 
@@ -661,6 +722,7 @@ func scrollDown(b *TextBox, delta int) {
 			break
 		}
 	}
+	b.highlight = nil
 	dirtyLines(b)
 }
 
@@ -688,7 +750,27 @@ const (
 //
 // If the rune is positive, the event is a key press,
 // if negative, a key release.
+//
+// While the meta or control modifier is held,
+// z undoes the most recent change,
+// and Z or y redoes the most recently undone change.
+//
+// A burst of runes typed without otherwise moving the cursor
+// is recorded as a single change in the undo history.
 func (b *TextBox) Rune(r rune) {
+	if b.win.mods[3] {
+		switch r {
+		case 'z':
+			b.Undo()
+			return
+		case 'Z', 'y':
+			b.Redo()
+			return
+		}
+	}
+	typeAt := b.typeAt
+	dot := b.dots[1].At
+	n := len(b.undo)
 	switch r {
 	case '\b':
 		if b.dots[1].At[0] == b.dots[1].At[1] {
@@ -710,6 +792,18 @@ func (b *TextBox) Rune(r rune) {
 		ed(b, ".c/"+string([]rune{r}))
 	}
 	setDot(b, 1, b.dots[1].At[1], b.dots[1].At[1])
+	if len(b.undo) == n {
+		return
+	}
+	if n > 0 && typeAt >= 0 && dot[0] == typeAt && dot[1] == typeAt {
+		// Merge with the previous typed runes.
+		// The new undo diffs revert this rune;
+		// they must be applied before those of the earlier runes.
+		prev := &b.undo[n-1]
+		prev.diffs = append(b.undo[n].diffs, prev.diffs...)
+		b.undo = b.undo[:n]
+	}
+	b.typeAt = b.dots[1].At[1]
 }
 
 // Draw draws the text box to the image with the upper-left of the box at 0,0.
@@ -911,6 +1005,44 @@ func atPoint(b *TextBox, pt image.Point) (int64, image.Rectangle) {
 	return at, rect
 }
 
+// addrPoint returns the point at the vertical middle
+// of the left edge of the rune at an address,
+// in the coordinates of atPoint,
+// and whether the address is visible.
+func addrPoint(b *TextBox, at int64) (image.Point, bool) {
+	lines := b.lines()
+	a := b.at
+	var y fixed.Int26_6
+	for i := range lines {
+		l := &lines[i]
+		if at < a {
+			break
+		}
+		if at >= a+l.n {
+			a += l.n
+			y += l.h
+			continue
+		}
+		var x fixed.Int26_6
+		var prevTextStyle text.Style
+		var prevRune rune
+		for _, s := range l.spans {
+			for _, r := range s.text {
+				if a == at {
+					return image.Pt(x.Floor(), (y + l.h/2).Floor()), true
+				}
+				if prevTextStyle == s.style {
+					x += kern(s.style, prevRune, r)
+				}
+				x += advance(b, s.style, x, r)
+				a += int64(utf8.RuneLen(r))
+				prevRune, prevTextStyle = r, s.style
+			}
+		}
+	}
+	return image.Point{}, false
+}
+
 func lastRune(l *line) rune {
 	if len(l.spans) == 0 {
 		return utf8.RuneError
@@ -945,6 +1077,7 @@ func showAddr(b *TextBox, at int64) {
 		panic(err.Error())
 	}
 	b.at = bol[0]
+	b.highlight = nil
 	// TODO: This shows the start of the line containing the addr.
 	// If it's a multi-line text line, then we may need to scroll forward
 	// in order to see the address.
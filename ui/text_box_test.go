@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/eaburns/T/clipboard"
+	"github.com/eaburns/T/edit"
 	"github.com/eaburns/T/rope"
 	"github.com/eaburns/T/syntax"
 	"github.com/eaburns/T/text"
@@ -1157,6 +1158,113 @@ func TestType(t *testing.T) {
 	}
 }
 
+func TestEditMarks(t *testing.T) {
+	b := NewTextBox(newTestWin(), testTextStyles, testSize)
+	b.SetText(rope.New("Hello, World"))
+	if _, err := b.Edit("/World/k w"); err != nil {
+		t.Fatalf("Edit failed: %v", err)
+	}
+	if _, err := b.Edit("0i/¡"); err != nil {
+		t.Fatalf("Edit failed: %v", err)
+	}
+	if _, err := b.Edit("'w c/世界"); err != nil {
+		t.Fatalf("Edit failed: %v", err)
+	}
+	if s := b.text.String(); s != "¡Hello, 世界" {
+		t.Errorf("got %q, want %q", s, "¡Hello, 世界")
+	}
+	b.SetText(rope.New("Hello, World"))
+	if _, err := b.Edit("'w d"); err == nil {
+		t.Errorf("Edit('w d) succeeded after SetText, want error")
+	}
+}
+
+func TestUndoRedo(t *testing.T) {
+	b := NewTextBox(newTestWin(), testTextStyles, testSize)
+	b.SetText(rope.New("Hello, World"))
+	b.dots[1].At = [2]int64{5, 5}
+	if _, err := b.Edit(".c/!"); err != nil {
+		t.Fatalf("Edit failed: %v", err)
+	}
+	b.dots[1].At = [2]int64{0, 0}
+	if _, err := b.Edit("/World/c/世界"); err != nil {
+		t.Fatalf("Edit failed: %v", err)
+	}
+	const (
+		text0 = "Hello, World"
+		text1 = "Hello!, World"
+		text2 = "Hello!, 世界"
+	)
+	steps := []struct {
+		op      func() bool
+		want    string
+		wantDot [2]int64
+		wantOK  bool
+	}{
+		{op: b.Undo, want: text1, wantDot: [2]int64{0, 0}, wantOK: true},
+		{op: b.Undo, want: text0, wantDot: [2]int64{5, 5}, wantOK: true},
+		{op: b.Undo, want: text0, wantDot: [2]int64{5, 5}, wantOK: false},
+		{op: b.Redo, want: text1, wantDot: [2]int64{0, 0}, wantOK: true},
+		{op: b.Redo, want: text2, wantDot: [2]int64{8, 14}, wantOK: true},
+		{op: b.Redo, want: text2, wantDot: [2]int64{8, 14}, wantOK: false},
+		{op: b.Undo, want: text1, wantDot: [2]int64{0, 0}, wantOK: true},
+	}
+	for i, step := range steps {
+		ok := step.op()
+		if got := b.text.String(); got != step.want || ok != step.wantOK {
+			t.Errorf("step %d: got %q,%v, want %q,%v", i, got, ok, step.want, step.wantOK)
+		}
+		if b.dots[1].At != step.wantDot {
+			t.Errorf("step %d: dot=%v, want %v", i, b.dots[1].At, step.wantDot)
+		}
+	}
+
+	// A new change clears the redo history.
+	b.Change(edit.Diffs{{At: [2]int64{0, 0}, Text: rope.New(">")}})
+	if b.Redo() {
+		t.Errorf("Redo()=true after a change, want false")
+	}
+}
+
+func TestUndoTyping(t *testing.T) {
+	w := newTestWin()
+	b := NewTextBox(w, testTextStyles, testSize)
+	b.SetText(rope.New("Hello"))
+	b.dots[1].At = [2]int64{5, 5}
+	for _, r := range ", World" {
+		b.Rune(r)
+	}
+	b.Rune('\b')
+	b.dots[1].At = [2]int64{0, 0}
+	b.Rune('>')
+	if got := b.text.String(); got != ">Hello, Worl" {
+		t.Fatalf("text=%q, want %q", got, ">Hello, Worl")
+	}
+
+	if !b.Undo() {
+		t.Fatalf("Undo()=false, want true")
+	}
+	if got := b.text.String(); got != "Hello, Worl" {
+		t.Errorf("after 1 undo, text=%q, want %q", got, "Hello, Worl")
+	}
+	if !b.Undo() {
+		t.Fatalf("Undo()=false, want true")
+	}
+	if got := b.text.String(); got != "Hello" {
+		t.Errorf("after 2 undos, text=%q, want %q", got, "Hello")
+	}
+	if b.dots[1].At != [2]int64{5, 5} {
+		t.Errorf("after 2 undos, dot=%v, want %v", b.dots[1].At, [2]int64{5, 5})
+	}
+
+	w.mods[3] = true
+	b.Rune('Z')
+	w.mods[3] = false
+	if got := b.text.String(); got != "Hello, Worl" {
+		t.Errorf("after redo, text=%q, want %q", got, "Hello, Worl")
+	}
+}
+
 // This is testing a bug where clicking below and to the right
 // of the last line of text  with >1 spans would cause dot to be set
 // out-of-bounds of the text.
@@ -4,7 +4,8 @@ package ui
 import (
 	"image"
 	"image/draw"
-	"strings"
+	"os"
+	"path/filepath"
 	"sync"
 
 	"github.com/eaburns/T/clipboard"
@@ -26,11 +27,18 @@ type Win struct {
 	lineHeight int
 	mods       [4]bool // currently held modifier keys
 	clipboard  clipboard.Clipboard
-	face       font.Face // default font face
-	output     *Sheet
+	face       font.Face         // default font face
+	warp       func(image.Point) // moves the mouse pointer, or nil
 
-	mu           sync.Mutex
-	outputBuffer strings.Builder
+	mu     sync.Mutex
+	output []pendingOutput // in order of first write
+	calls  []func()        // called on the next Tick, in order
+}
+
+// pendingOutput is output not yet added to its +Errors sheet.
+type pendingOutput struct {
+	title string
+	data  []byte
 }
 
 // NewWin returns a new window.
@@ -50,10 +58,14 @@ func NewWin(dpi float32) *Win {
 	w.cols = []*Col{NewCol(w)}
 	w.widths = []float64{1.0}
 	w.Col = w.cols[0]
-	w.output = NewSheet(w, "Output")
 	return w
 }
 
+// SetWarp sets the function that moves the mouse pointer
+// to a point in the window.
+// If it is not set, the pointer is never moved.
+func (w *Win) SetWarp(warp func(image.Point)) { w.warp = warp }
+
 // Add adds a new column to the window and returns it.
 func (w *Win) Add() *Col {
 	col := NewCol(w)
@@ -94,6 +106,9 @@ func (w *Win) Del(c *Col) {
 // Tick handles tick events.
 func (w *Win) Tick() bool {
 	var redraw bool
+	if runCalls(w) {
+		redraw = true
+	}
 	if showOutput(w) {
 		redraw = true
 	}
@@ -105,37 +120,73 @@ func (w *Win) Tick() bool {
 	return redraw
 }
 
-func showOutput(w *Win) bool {
+// call arranges for f to be called on the next Tick.
+// It is safe for concurrent calls.
+func (w *Win) call(f func()) {
 	w.mu.Lock()
-	output := w.outputBuffer.String()
-	w.outputBuffer.Reset()
+	w.calls = append(w.calls, f)
 	w.mu.Unlock()
+}
 
-	if len(output) == 0 {
-		return false
+func runCalls(w *Win) bool {
+	w.mu.Lock()
+	calls := w.calls
+	w.calls = nil
+	w.mu.Unlock()
+
+	for _, f := range calls {
+		f()
 	}
+	return len(calls) > 0
+}
+
+func showOutput(w *Win) bool {
+	w.mu.Lock()
+	output := w.output
+	w.output = nil
+	w.mu.Unlock()
 
-	b := w.output.body
-	b.Change(edit.Diffs{{
-		At:   [2]int64{b.text.Len(), b.text.Len()},
-		Text: rope.New(output),
-	}})
-	setDot(b, 1, b.text.Len(), b.text.Len())
-	// TODO: only showAddr on Output if the cursor was visible to begin with.
-	// If the user scrolls up, for example, we shouldn't scroll them back down.
-	// This should probably just be the behavior of b.Change by default.
-	showAddr(b, b.dots[1].At[1])
+	for _, o := range output {
+		_, s := findSheet(w, o.title)
+		if s == nil {
+			s = NewSheet(w, o.title)
+			errorsCol(w, filepath.Dir(o.title)).Add(s)
+		}
+		b := s.body
+		b.Change(edit.Diffs{{
+			At:   [2]int64{b.text.Len(), b.text.Len()},
+			Text: rope.New(string(o.data)),
+		}})
+		setDot(b, 1, b.text.Len(), b.text.Len())
+		// TODO: only showAddr on +Errors if the cursor was visible to begin with.
+		// If the user scrolls up, for example, we shouldn't scroll them back down.
+		// This should probably just be the behavior of b.Change by default.
+		showAddr(b, b.dots[1].At[1])
+	}
+	return len(output) > 0
+}
 
-	w.outputBuffer.Reset()
+// errorsCol returns the column for a new +Errors sheet of a directory:
+// the first column with a sheet in the directory,
+// or the last column if there is none.
+func errorsCol(w *Win, dir string) *Col {
 	for _, c := range w.cols {
 		for _, r := range c.rows {
-			if r == w.output {
-				return true
+			if s := getSheet(r); s != nil && filepath.Dir(s.Title()) == dir {
+				return c
 			}
 		}
 	}
-	w.cols[len(w.cols)-1].Add(w.output)
-	return true
+	return w.cols[len(w.cols)-1]
+}
+
+// errorsTitle returns the title of the +Errors sheet of a directory.
+// If dir is empty, the current directory is used.
+func errorsTitle(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	return filepath.Join(dir, "+Errors")
 }
 
 // Draw draws the window.
@@ -282,22 +333,113 @@ func (w *Win) Mod(m int) {
 	w.Col.Mod(m)
 }
 
-// OutputString appends a string to the Output sheet
-// and ensures that the Output sheet is visible.
+// OutputString appends a string to the +Errors sheet of a directory,
+// creating the sheet if needed, and ensures that the sheet is visible.
+// If dir is empty, the current directory is used.
 // It is safe for concurrent calls.
-func (w *Win) OutputString(str string) {
-	w.mu.Lock()
-	w.outputBuffer.WriteString(str)
-	w.mu.Unlock()
+func (w *Win) OutputString(dir, str string) {
+	w.OutputBytes(dir, []byte(str))
 }
 
-// OutputBytes appends bytes to the Output sheet
-// and ensures that the Output sheet is visible.
+// OutputBytes appends bytes to the +Errors sheet of a directory,
+// creating the sheet if needed, and ensures that the sheet is visible.
+// If dir is empty, the current directory is used.
 // It is safe for concurrent calls.
-func (w *Win) OutputBytes(data []byte) {
+func (w *Win) OutputBytes(dir string, data []byte) {
+	title := errorsTitle(dir)
 	w.mu.Lock()
-	w.outputBuffer.Write(data)
-	w.mu.Unlock()
+	defer w.mu.Unlock()
+	for i := range w.output {
+		if w.output[i].title == title {
+			w.output[i].data = append(w.output[i].data, data...)
+			return
+		}
+	}
+	w.output = append(w.output, pendingOutput{
+		title: title,
+		data:  append([]byte(nil), data...),
+	})
+}
+
+// Files returns an edit.File for the body of each sheet in the window.
+func (w *Win) Files() []edit.File {
+	var files []edit.File
+	for _, c := range w.cols {
+		for _, r := range c.rows {
+			if s := getSheet(r); s != nil {
+				files = append(files, sheetFile{s})
+			}
+		}
+	}
+	return files
+}
+
+// Current returns the edit.File of the focused sheet
+// or nil if no sheet is focused.
+func (w *Win) Current() edit.File {
+	if s := getSheet(w.Col.Row); s != nil {
+		return sheetFile{s}
+	}
+	return nil
+}
+
+// SetCurrent focuses the sheet of an edit.File returned by the window.
+func (w *Win) SetCurrent(f edit.File) {
+	s := f.(sheetFile).Sheet
+	for _, c := range w.cols {
+		if rowIndex(c, s) >= 0 {
+			setWinFocus(w, c)
+			setColFocus(c, s)
+			return
+		}
+	}
+}
+
+// Open returns the edit.File of the sheet with the given title.
+// If there is no such sheet, a new sheet is added to the focused column
+// with the contents of the file at the path, if it exists.
+// A relative path is relative to the directory of the focused sheet.
+func (w *Win) Open(name string) (edit.File, error) {
+	path, err := abs(getSheet(w.Col.Row), name)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range w.Files() {
+		if f.Name() == path {
+			return f, nil
+		}
+	}
+	s := NewSheet(w, path)
+	switch f, err := os.Open(path); {
+	case os.IsNotExist(err):
+		// Leave the sheet empty.
+	case err != nil:
+		return nil, err
+	default:
+		defer f.Close()
+		if err := get(s, f); err != nil {
+			return nil, err
+		}
+	}
+	w.Col.Add(s)
+	return sheetFile{s}, nil
+}
+
+// Close deletes the sheet of an edit.File returned by the window.
+func (w *Win) Close(f edit.File) error {
+	s := f.(sheetFile).Sheet
+	for _, c := range w.cols {
+		if rowIndex(c, s) >= 0 {
+			c.Del(s)
+		}
+	}
+	return nil
+}
+
+// Rename sets the title of the sheet of an edit.File returned by the window.
+func (w *Win) Rename(f edit.File, name string) error {
+	f.(sheetFile).SetTitle(name)
+	return nil
 }
 
 func x0(w *Win, i int) int {
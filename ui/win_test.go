@@ -0,0 +1,123 @@
+package ui
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/eaburns/T/edit"
+	"github.com/eaburns/T/rope"
+)
+
+func TestWinFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "T_TestWinFiles")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(path, []byte("Hello, World"), 0666); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	w := newTestWin()
+	a := NewSheet(w, filepath.Join(dir, "a"))
+	a.body.SetText(rope.New("abc"))
+	b := NewSheet(w, filepath.Join(dir, "b"))
+	b.body.SetText(rope.New("xyz"))
+	w.Col.Add(a)
+	w.Col.Add(b)
+
+	var print strings.Builder
+	fds, err := edit.EditFiles(w, "n", &print, edit.Options{})
+	if err != nil || len(fds) != 1 || fds[0].File.Name() != b.Title() {
+		t.Fatalf("EditFiles(n)=%v,%v, want [b],nil", fds, err)
+	}
+	want := "  " + a.Title() + "\n. " + b.Title() + "\n"
+	if print.String() != want {
+		t.Errorf("EditFiles(n) printed %q, want %q", print.String(), want)
+	}
+
+	fds, err = edit.EditFiles(w, "X/a$/ ,c/ABC/", &print, edit.Options{})
+	if err != nil || len(fds) != 2 || fds[0].File.Name() != a.Title() || fds[1].File.Name() != b.Title() {
+		t.Fatalf("EditFiles(X)=%v,%v, want [a b],nil", fds, err)
+	}
+
+	if _, err := edit.EditFiles(w, "B file", &print, edit.Options{}); err != nil {
+		t.Fatalf("EditFiles(B)=_,%v, want nil", err)
+	}
+	var titles []string
+	for _, f := range w.Files() {
+		titles = append(titles, f.Name())
+	}
+	if want := []string{a.Title(), b.Title(), path}; !reflect.DeepEqual(titles, want) {
+		t.Errorf("titles=%q, want %q", titles, want)
+	}
+	switch f := w.Current(); {
+	case f == nil || f.Name() != path:
+		t.Errorf("Current()=%v, want %s", f, path)
+	case f.Text().String() != "Hello, World":
+		t.Errorf("Current().Text()=%q, want %q", f.Text().String(), "Hello, World")
+	}
+
+	if _, err := edit.EditFiles(w, "D "+a.Title(), &print, edit.Options{}); err != nil {
+		t.Fatalf("EditFiles(D)=_,%v, want nil", err)
+	}
+	if n := len(w.Files()); n != 2 {
+		t.Errorf("len(Files())=%d, want 2", n)
+	}
+}
+
+func TestShowOutput(t *testing.T) {
+	dir1 := tmpdir()
+	defer os.RemoveAll(dir1)
+	dir2 := tmpdir()
+	defer os.RemoveAll(dir2)
+
+	w := newTestWin()
+	c0 := w.cols[0]
+	c0.Add(NewSheet(w, filepath.Join(dir1, "file")))
+	c1 := newTestCol(w)
+
+	if showOutput(w) {
+		t.Errorf("showOutput()=true with no output, want false")
+	}
+
+	w.OutputString(dir1, "a\n")
+	w.OutputBytes(dir2, []byte("b\n"))
+	w.OutputString(dir1, "c\n")
+	if !showOutput(w) {
+		t.Errorf("showOutput()=false, want true")
+	}
+	w.OutputString(dir1, "d\n")
+	showOutput(w)
+
+	tests := []struct {
+		dir  string
+		col  *Col
+		text string
+	}{
+		{dir: dir1, col: c0, text: "a\nc\nd\n"},
+		{dir: dir2, col: c1, text: "b\n"},
+	}
+	for _, test := range tests {
+		title := filepath.Join(test.dir, "+Errors")
+		c, s := findSheet(w, title)
+		if s == nil {
+			t.Errorf("no sheet %q", title)
+			continue
+		}
+		if c != test.col {
+			t.Errorf("sheet %q in column %d, want %d", title, colIndex(c), colIndex(test.col))
+		}
+		if str := s.body.text.String(); str != test.text {
+			t.Errorf("sheet %q body=%q, want %q", title, str, test.text)
+		}
+	}
+	if n := len(c0.rows) + len(c1.rows); n != 5 {
+		t.Errorf("%d rows, want 5", n)
+	}
+}